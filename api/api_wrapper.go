@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/sheets/v4"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -17,16 +20,49 @@ type ApiWrapperImpl struct {
 	docID string
 	sheet string
 
-	logger *zap.Logger
+	logger      *zap.Logger
+	retryPolicy RetryPolicy
+	limiter     *RateLimiter // nil if requests are not throttled
+	telemetry   *Telemetry   // nil if calls are not traced nor measured
 }
 
 func NewApiWrapper(srv *sheets.Service, docID string, sheet string, logger *zap.Logger) *ApiWrapperImpl {
+	return NewApiWrapperWithRetryPolicy(srv, docID, sheet, logger, DefaultRetryPolicy())
+}
+
+// NewApiWrapperWithRetryPolicy is the same as NewApiWrapper, but failed calls are retried according to the policy passed
+func NewApiWrapperWithRetryPolicy(srv *sheets.Service, docID string, sheet string, logger *zap.Logger, retryPolicy RetryPolicy) *ApiWrapperImpl {
 	return &ApiWrapperImpl{
-		srv:    srv,
-		docID:  docID,
-		sheet:  sheet,
-		logger: logger,
+		srv:         srv,
+		docID:       docID,
+		sheet:       sheet,
+		logger:      logger,
+		retryPolicy: retryPolicy,
+	}
+}
+
+// SetRateLimiter makes the wrapper wait for the limiter before each request, including retries. It must be called before the wrapper is used.
+func (aw *ApiWrapperImpl) SetRateLimiter(limiter *RateLimiter) {
+	aw.limiter = limiter
+}
+
+// throttle waits for the rate limiter if there is one
+func (aw *ApiWrapperImpl) throttle(ctx context.Context, write bool) error {
+	if aw.limiter == nil {
+		return nil
+	}
+	waited, err := aw.limiter.wait(ctx, write)
+	if err != nil {
+		return err
 	}
+	if aw.telemetry != nil {
+		// the limiter's Stats only has totals, the distribution shows whether calls wait a little often or a lot rarely
+		aw.telemetry.throttleDuration.Record(ctx, waited.Seconds(), metric.WithAttributes(AttrWrite.Bool(write)))
+	}
+	if waited > 0 {
+		aw.logger.Debug("Throttled request to stay within quota", zap.Bool("write", write), zap.Duration("waited", waited))
+	}
+	return nil
 }
 
 func IsTooManyRequests(err error) bool {
@@ -39,8 +75,24 @@ func IsServiceUnavailable(err error) bool { // sometimes google api does this lo
 	return errors.As(err, &apiErr) && apiErr.Code == http.StatusServiceUnavailable
 }
 
+// IsServerError reports 500, 502 and 504 errors, these are usually transient as well
+func IsServerError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusInternalServerError || apiErr.Code == http.StatusBadGateway || apiErr.Code == http.StatusGatewayTimeout
+}
+
+// IsNetworkTimeout reports errors of network operations that timed out, the deadline of our own context passing does not count
+func IsNetworkTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// ShouldRetryAPICall is the default classifier of RetryPolicy
 func ShouldRetryAPICall(err error) bool {
-	return IsTooManyRequests(err) || IsServiceUnavailable(err)
+	return IsTooManyRequests(err) || IsServiceUnavailable(err) || IsServerError(err) || IsNetworkTimeout(err)
 }
 
 func (aw *ApiWrapperImpl) bindRange(range_ string) string {
@@ -58,11 +110,11 @@ func (aw *ApiWrapperImpl) bindRange(range_ string) string {
 
 func (aw *ApiWrapperImpl) GetSpreadsheet(ctx context.Context) (*sheets.Spreadsheet, error) {
 	var result *sheets.Spreadsheet
-	return result, DoRetry(ctx, aw.logger, func() error {
+	return result, aw.call(ctx, "GetSpreadsheet", false, nil, func(ctx context.Context) (int64, error) {
 		var err error
 		result, err = aw.srv.Spreadsheets.Get(aw.docID).Context(ctx).Do()
-		return err
-	}, ShouldRetryAPICall)
+		return 0, err
+	})
 }
 
 func (aw *ApiWrapperImpl) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
@@ -70,11 +122,14 @@ func (aw *ApiWrapperImpl) GetRange(ctx context.Context, range_ string) (*sheets.
 	aw.logger.Debug("Attempting to get data from sheet", zap.String("range", boundRange))
 
 	var result *sheets.ValueRange
-	return result, DoRetry(ctx, aw.logger, func() error {
+	return result, aw.call(ctx, "GetRange", false, []attribute.KeyValue{AttrRange.String(boundRange)}, func(ctx context.Context) (int64, error) {
 		var err error
 		result, err = aw.srv.Spreadsheets.Values.Get(aw.docID, boundRange).Context(ctx).Do()
-		return err
-	}, ShouldRetryAPICall)
+		if err != nil {
+			return 0, err
+		}
+		return countCells(result), nil
+	})
 }
 
 func (aw *ApiWrapperImpl) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
@@ -85,11 +140,14 @@ func (aw *ApiWrapperImpl) BatchGetRanges(ctx context.Context, ranges []string) (
 	aw.logger.Debug("Attempting to batch get data from sheet", zap.Strings("ranges", boundRanges))
 
 	var result *sheets.BatchGetValuesResponse
-	return result, DoRetry(ctx, aw.logger, func() error {
+	return result, aw.call(ctx, "BatchGetRanges", false, []attribute.KeyValue{AttrRanges.StringSlice(boundRanges)}, func(ctx context.Context) (int64, error) {
 		var err error
 		result, err = aw.srv.Spreadsheets.Values.BatchGet(aw.docID).Context(ctx).Ranges(boundRanges...).Do()
-		return err
-	}, ShouldRetryAPICall)
+		if err != nil {
+			return 0, err
+		}
+		return countCells(result.ValueRanges...), nil
+	})
 }
 
 func (aw *ApiWrapperImpl) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
@@ -118,9 +176,12 @@ func (aw *ApiWrapperImpl) BatchUpdate(ctx context.Context, values []*sheets.Valu
 	}
 
 	var result *sheets.BatchUpdateValuesResponse
-	return result, DoRetry(ctx, aw.logger, func() error {
+	return result, aw.call(ctx, "BatchUpdate", true, []attribute.KeyValue{AttrRanges.StringSlice(boundRanges)}, func(ctx context.Context) (int64, error) {
 		var err error
 		result, err = aw.srv.Spreadsheets.Values.BatchUpdate(aw.docID, &req).Context(ctx).Do()
-		return err
-	}, ShouldRetryAPICall)
+		if err != nil {
+			return 0, err
+		}
+		return result.TotalUpdatedCells, nil
+	})
 }
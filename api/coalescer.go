@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCoalescerWindow  = 5 * time.Millisecond
+	defaultCoalescerTimeout = 2 * time.Minute
+)
+
+// CoalescerConfig configures a Coalescer
+type CoalescerConfig struct {
+	Window    time.Duration // how long to wait for more reads after the first one, defaults to 5ms
+	MaxRanges int           // the reads are sent early when this many distinct ranges are waiting, 0 means no limit
+	// Timeout bounds the merged read if any of its callers has no deadline, defaults to 2 minutes.
+	// Otherwise, the merged read is bounded by the latest deadline of its callers, so it is not cut short for any of them.
+	Timeout time.Duration
+}
+
+// coalescedBatch is a set of ranges to be read together, shared by every caller waiting for it
+type coalescedBatch struct {
+	ctx    context.Context // the context of the first caller, without its cancellation
+	ranges []string
+	index  map[string]int // range -> index in ranges
+	done   chan struct{}
+
+	deadline   time.Time // the latest deadline of the callers
+	noDeadline bool      // true if any of the callers has no deadline
+
+	// set before done is closed
+	values []*sheets.ValueRange
+	err    error
+}
+
+// Coalescer is an ApiWrapper that merges reads arriving within a short window into a single BatchGetRanges call, and fans the results out to the callers.
+// Identical ranges are requested only once. Everything else is passed to the wrapped ApiWrapper as-is.
+// If the merged request is rejected as a bad request, each caller retries its own ranges on its own, so one invalid range does not fail the others.
+type Coalescer struct {
+	next   ApiWrapper
+	logger *zap.Logger
+
+	window    time.Duration
+	maxRanges int
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	pending *coalescedBatch // nil if no reads are waiting
+}
+
+// NewCoalescer wraps next with a Coalescer
+func NewCoalescer(next ApiWrapper, logger *zap.Logger, config CoalescerConfig) *Coalescer {
+	window := config.Window
+	if window <= 0 {
+		window = defaultCoalescerWindow
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultCoalescerTimeout
+	}
+	return &Coalescer{
+		next:      next,
+		logger:    logger,
+		window:    window,
+		maxRanges: config.MaxRanges,
+		timeout:   timeout,
+	}
+}
+
+func (c *Coalescer) GetSpreadsheet(ctx context.Context) (*sheets.Spreadsheet, error) {
+	return c.next.GetSpreadsheet(ctx)
+}
+
+func (c *Coalescer) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	values, err := c.get(ctx, []string{range_})
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			return c.next.GetRange(ctx, range_)
+		}
+		return nil, err
+	}
+	return values[0], nil
+}
+
+func (c *Coalescer) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	values, err := c.get(ctx, ranges)
+	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest {
+			return c.next.BatchGetRanges(ctx, ranges)
+		}
+		return nil, err
+	}
+	return &sheets.BatchGetValuesResponse{ValueRanges: values}, nil
+}
+
+func (c *Coalescer) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	return c.next.BatchUpdate(ctx, values)
+}
+
+// get adds the ranges to the pending batch, and waits for its results
+func (c *Coalescer) get(ctx context.Context, ranges []string) ([]*sheets.ValueRange, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	b := c.pending
+	if b == nil {
+		b = &coalescedBatch{
+			ctx:   context.WithoutCancel(ctx),
+			index: make(map[string]int),
+			done:  make(chan struct{}),
+		}
+		c.pending = b
+		time.AfterFunc(c.window, func() {
+			c.flush(b)
+		})
+	}
+	if deadline, ok := ctx.Deadline(); !ok {
+		b.noDeadline = true
+	} else if deadline.After(b.deadline) {
+		b.deadline = deadline
+	}
+	indexes := make([]int, len(ranges))
+	for i, r := range ranges {
+		idx, ok := b.index[r]
+		if !ok {
+			idx = len(b.ranges)
+			b.index[r] = idx
+			b.ranges = append(b.ranges, r)
+		}
+		indexes[i] = idx
+	}
+	full := c.maxRanges > 0 && len(b.ranges) >= c.maxRanges
+	c.mu.Unlock()
+
+	if full {
+		go c.flush(b)
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err() // the others still get their results
+	}
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	out := make([]*sheets.ValueRange, len(ranges))
+	for i, idx := range indexes {
+		out[i] = b.values[idx]
+	}
+	return out, nil
+}
+
+// flush sends the batch if it was not sent yet
+func (c *Coalescer) flush(b *coalescedBatch) {
+	c.mu.Lock()
+	if c.pending != b {
+		c.mu.Unlock()
+		return // already sent
+	}
+	c.pending = nil
+	c.mu.Unlock()
+
+	// no more callers can join once it is not pending, the read is bounded by the one waiting the longest
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if b.noDeadline {
+		ctx, cancel = context.WithTimeout(b.ctx, c.timeout)
+	} else {
+		ctx, cancel = context.WithDeadline(b.ctx, b.deadline)
+	}
+	defer cancel()
+
+	c.logger.Debug("Sending coalesced reads", zap.Int("len(ranges)", len(b.ranges)))
+	resp, err := c.next.BatchGetRanges(ctx, b.ranges)
+	if err == nil && len(resp.ValueRanges) != len(b.ranges) {
+		err = fmt.Errorf("expected %d value ranges, got %d", len(b.ranges), len(resp.ValueRanges))
+	}
+	if err != nil {
+		c.logger.Debug("Coalesced reads failed", zap.Error(err))
+		b.err = err
+	} else {
+		b.values = resp.ValueRanges
+	}
+	close(b.done)
+}
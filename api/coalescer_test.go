@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRangesResponse returns a response where each value range holds its own range, so results can be traced back
+func testRangesResponse(ranges []string) *sheets.BatchGetValuesResponse {
+	resp := &sheets.BatchGetValuesResponse{}
+	for _, r := range ranges {
+		resp.ValueRanges = append(resp.ValueRanges, &sheets.ValueRange{Range: r, Values: [][]interface{}{{r}}})
+	}
+	return resp
+}
+
+func matchRanges(expected ...string) interface{} {
+	return mock.MatchedBy(func(ranges []string) bool {
+		sorted := append([]string(nil), ranges...)
+		sort.Strings(sorted)
+		return assert.ObjectsAreEqual(expected, sorted)
+	})
+}
+
+// testCoalescerApi is a mock that builds its BatchGetRanges response from the ranges requested
+type testCoalescerApi struct {
+	MockApiWrapper
+}
+
+func (m *testCoalescerApi) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	args := m.Called(ctx, ranges)
+	if err := args.Error(1); err != nil {
+		return nil, err
+	}
+	return testRangesResponse(ranges), nil
+}
+
+func TestCoalescer_MergesConcurrentReads(t *testing.T) {
+	m := &testCoalescerApi{}
+	m.On("BatchGetRanges", mock.Anything, matchRanges("A2:A", "A5:B5", "A7:B7")).Return(nil, nil).Once()
+
+	c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: 20 * time.Millisecond})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vr, err := c.GetRange(ctx, "A2:A") // deduplicated
+			assert.NoError(t, err)
+			assert.Equal(t, "A2:A", vr.Range)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, err := c.BatchGetRanges(ctx, []string{"A7:B7", "A5:B5"})
+		assert.NoError(t, err)
+		assert.Equal(t, "A7:B7", resp.ValueRanges[0].Range) // in the order requested
+		assert.Equal(t, "A5:B5", resp.ValueRanges[1].Range)
+	}()
+	wg.Wait()
+
+	m.AssertExpectations(t)
+}
+
+func TestCoalescer_SeparateWindows(t *testing.T) {
+	m := &testCoalescerApi{}
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:A"}).Return(nil, nil).Twice()
+
+	c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: time.Millisecond})
+	for i := 0; i < 2; i++ {
+		_, err := c.GetRange(context.Background(), "A2:A")
+		assert.NoError(t, err)
+	}
+	m.AssertExpectations(t)
+}
+
+func TestCoalescer_MaxRanges(t *testing.T) {
+	m := &testCoalescerApi{}
+	m.On("BatchGetRanges", mock.Anything, []string{"A1", "A2"}).Return(nil, nil).Once()
+
+	c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: time.Hour, MaxRanges: 2})
+	start := time.Now()
+	resp, err := c.BatchGetRanges(context.Background(), []string{"A1", "A2"})
+	assert.NoError(t, err)
+	assert.Len(t, resp.ValueRanges, 2)
+	assert.Less(t, time.Since(start), time.Second) // sent without waiting for the window
+	m.AssertExpectations(t)
+}
+
+func TestCoalescer_Deadline(t *testing.T) {
+	deadlineOf := func(ctx context.Context) time.Time {
+		deadline, _ := ctx.Deadline()
+		return deadline
+	}
+
+	t.Run("latest_of_callers", func(t *testing.T) {
+		early, cancelEarly := context.WithTimeout(context.Background(), time.Minute)
+		defer cancelEarly()
+		late, cancelLate := context.WithTimeout(context.Background(), time.Hour)
+		defer cancelLate()
+
+		m := &testCoalescerApi{}
+		m.On("BatchGetRanges", mock.MatchedBy(func(ctx context.Context) bool {
+			return deadlineOf(ctx).Equal(deadlineOf(late))
+		}), matchRanges("A1", "A2")).Return(nil, nil).Once()
+
+		c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: time.Hour, MaxRanges: 2})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetRange(early, "A1")
+			assert.NoError(t, err)
+		}()
+		time.Sleep(10 * time.Millisecond) // the early one starts the batch
+		_, err := c.GetRange(late, "A2")
+		assert.NoError(t, err)
+		wg.Wait()
+		m.AssertExpectations(t)
+	})
+
+	t.Run("timeout_without_deadline", func(t *testing.T) {
+		m := &testCoalescerApi{}
+		m.On("BatchGetRanges", mock.MatchedBy(func(ctx context.Context) bool {
+			return time.Until(deadlineOf(ctx)) <= time.Second
+		}), []string{"A1"}).Return(nil, nil).Once()
+
+		c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: time.Millisecond, Timeout: time.Second})
+		_, err := c.GetRange(context.Background(), "A1")
+		assert.NoError(t, err)
+		m.AssertExpectations(t)
+	})
+}
+
+func TestCoalescer_Errors(t *testing.T) {
+	t.Run("shared_error", func(t *testing.T) {
+		testErr := errors.New("hello")
+		m := &testCoalescerApi{}
+		m.On("BatchGetRanges", mock.Anything, []string{"A1"}).Return(nil, testErr).Once()
+
+		c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{})
+		_, err := c.GetRange(context.Background(), "A1")
+		assert.ErrorIs(t, err, testErr)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("bad_request_falls_back", func(t *testing.T) {
+		m := &testCoalescerApi{}
+		m.On("BatchGetRanges", mock.Anything, []string{"A1"}).Return(nil, &googleapi.Error{Code: http.StatusBadRequest}).Once()
+		m.On("GetRange", mock.Anything, "A1").Return(&sheets.ValueRange{Range: "A1"}, nil).Once()
+
+		c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{})
+		vr, err := c.GetRange(context.Background(), "A1")
+		assert.NoError(t, err)
+		assert.Equal(t, "A1", vr.Range)
+		m.AssertExpectations(t)
+	})
+
+	t.Run("context_cancelled", func(t *testing.T) {
+		m := &testCoalescerApi{}
+		m.On("BatchGetRanges", mock.MatchedBy(func(ctx context.Context) bool {
+			return ctx.Err() == nil
+		}), []string{"A1"}).Return(nil, nil).Once()
+
+		c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{Window: 50 * time.Millisecond})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.GetRange(ctx, "A1")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		time.Sleep(100 * time.Millisecond) // the batch is still sent, with a context that is not cancelled
+		m.AssertExpectations(t)
+	})
+}
+
+func TestCoalescer_PassesWrites(t *testing.T) {
+	m := &testCoalescerApi{}
+	m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+
+	c := NewCoalescer(m, zaptest.NewLogger(t), CoalescerConfig{})
+	_, err := c.BatchUpdate(context.Background(), []*sheets.ValueRange{{Range: "A1"}})
+	assert.NoError(t, err)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/api/sheets/v4"
+	"sync"
+)
+
+// PlannedWrite is a write that was not executed because of a dry run
+type PlannedWrite struct {
+	Range     string          // unbound from the sheet, as passed to BatchUpdate
+	Values    [][]interface{} // the values that would have been written, in rows
+	OldValues [][]interface{} // the current values of the same cells, in the same shape as Values
+}
+
+// DryRunApiWrapper passes reads to the wrapped ApiWrapper, but does not execute writes. Instead, it reads the current values of the cells
+// that would be written, logs the planned writes, and collects them, so they can be reviewed. It is safe for concurrent use.
+type DryRunApiWrapper struct {
+	next   ApiWrapper
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	writes []PlannedWrite
+}
+
+func NewDryRunApiWrapper(next ApiWrapper, logger *zap.Logger) *DryRunApiWrapper {
+	return &DryRunApiWrapper{next: next, logger: logger}
+}
+
+// Writes returns the writes planned so far, in the order they were requested
+func (dr *DryRunApiWrapper) Writes() []PlannedWrite {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return append([]PlannedWrite(nil), dr.writes...)
+}
+
+// Reset forgets the writes planned so far
+func (dr *DryRunApiWrapper) Reset() {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.writes = nil
+}
+
+func (dr *DryRunApiWrapper) GetSpreadsheet(ctx context.Context) (*sheets.Spreadsheet, error) {
+	return dr.next.GetSpreadsheet(ctx)
+}
+
+func (dr *DryRunApiWrapper) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	return dr.next.GetRange(ctx, range_)
+}
+
+func (dr *DryRunApiWrapper) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	return dr.next.BatchGetRanges(ctx, ranges)
+}
+
+// BatchUpdate reads the current values of the ranges with a single call, and records the planned writes instead of executing them.
+// It returns a response as if the values were written.
+func (dr *DryRunApiWrapper) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	resp := &sheets.BatchUpdateValuesResponse{}
+	if len(values) == 0 {
+		return resp, nil
+	}
+
+	ranges := make([]string, len(values))
+	for i, vr := range values {
+		ranges[i] = vr.Range
+	}
+	current, err := dr.next.BatchGetRanges(ctx, ranges)
+	if err != nil {
+		dr.logger.Error("Failed to read the current values for the dry run", zap.Strings("ranges", ranges), zap.Error(err))
+		return nil, err
+	}
+
+	planned := make([]PlannedWrite, len(values))
+	for i, vr := range values {
+		rows := vr.Values
+		if vr.MajorDimension == "COLUMNS" {
+			rows = transposeValues(rows)
+		}
+		var oldRows [][]interface{}
+		if i < len(current.ValueRanges) {
+			oldRows = current.ValueRanges[i].Values
+		}
+
+		planned[i] = PlannedWrite{Range: vr.Range, Values: rows, OldValues: shapeLike(oldRows, rows)}
+		dr.logger.Info("Dry run: skipped writing range", zap.String("range", vr.Range), zap.Any("values", rows), zap.Any("oldValues", planned[i].OldValues))
+
+		var cols int64
+		for _, row := range rows {
+			cols = max(cols, int64(len(row)))
+			resp.TotalUpdatedCells += int64(len(row))
+		}
+		resp.TotalUpdatedRows += int64(len(rows))
+		resp.TotalUpdatedColumns += cols
+	}
+
+	dr.mu.Lock()
+	dr.writes = append(dr.writes, planned...)
+	dr.mu.Unlock()
+	return resp, nil
+}
+
+// shapeLike returns the values in the shape of like, the API omits empty cells from the end of rows, and empty rows from the end, these are filled with empty strings
+func shapeLike(values [][]interface{}, like [][]interface{}) [][]interface{} {
+	out := make([][]interface{}, len(like))
+	for i, row := range like {
+		out[i] = make([]interface{}, len(row))
+		for j := range row {
+			out[i][j] = ""
+			if i < len(values) && j < len(values[i]) {
+				out[i][j] = values[i][j]
+			}
+		}
+	}
+	return out
+}
+
+func transposeValues(values [][]interface{}) [][]interface{} {
+	var out [][]interface{}
+	for col, vals := range values {
+		for row, val := range vals {
+			for len(out) <= row {
+				out = append(out, nil)
+			}
+			for len(out[row]) <= col {
+				out[row] = append(out[row], nil)
+			}
+			out[row][col] = val
+		}
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/sheets/v4"
+	"testing"
+)
+
+func TestDryRunApiWrapper_BatchUpdate(t *testing.T) {
+	testCases := []struct {
+		name          string
+		values        []*sheets.ValueRange
+		current       []*sheets.ValueRange
+		readErr       error
+		expected      []PlannedWrite
+		expectedCells int64
+	}{
+		{
+			name: "old values padded",
+			values: []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "A2:C2", Values: [][]interface{}{{"alice", "23", "x"}}},
+				{MajorDimension: "ROWS", Range: "B5", Values: [][]interface{}{{"44"}}},
+			},
+			current: []*sheets.ValueRange{
+				{Values: [][]interface{}{{"alice", "22"}}},
+				{},
+			},
+			expected: []PlannedWrite{
+				{Range: "A2:C2", Values: [][]interface{}{{"alice", "23", "x"}}, OldValues: [][]interface{}{{"alice", "22", ""}}},
+				{Range: "B5", Values: [][]interface{}{{"44"}}, OldValues: [][]interface{}{{""}}},
+			},
+			expectedCells: 4,
+		},
+		{
+			name: "columns",
+			values: []*sheets.ValueRange{
+				{MajorDimension: "COLUMNS", Range: "A2:A3", Values: [][]interface{}{{"bob", "carol"}}},
+			},
+			current: []*sheets.ValueRange{
+				{Values: [][]interface{}{{"alice"}}},
+			},
+			expected: []PlannedWrite{
+				{Range: "A2:A3", Values: [][]interface{}{{"bob"}, {"carol"}}, OldValues: [][]interface{}{{"alice"}, {""}}},
+			},
+			expectedCells: 2,
+		},
+		{
+			name:    "read failed",
+			values:  []*sheets.ValueRange{{Range: "A2", Values: [][]interface{}{{"bob"}}}},
+			readErr: errors.New("boom"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &MockApiWrapper{}
+			ranges := make([]string, len(tc.values))
+			for i, vr := range tc.values {
+				ranges[i] = vr.Range
+			}
+			m.On("BatchGetRanges", mock.Anything, ranges).Return(&sheets.BatchGetValuesResponse{ValueRanges: tc.current}, tc.readErr).Once()
+
+			dr := NewDryRunApiWrapper(m, zaptest.NewLogger(t))
+			resp, err := dr.BatchUpdate(context.Background(), tc.values)
+			if tc.readErr != nil {
+				assert.ErrorIs(t, err, tc.readErr)
+				assert.Empty(t, dr.Writes())
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedCells, resp.TotalUpdatedCells)
+				assert.Equal(t, tc.expected, dr.Writes())
+			}
+
+			m.AssertExpectations(t)
+			m.AssertNotCalled(t, "BatchUpdate", mock.Anything, mock.Anything)
+
+			dr.Reset()
+			assert.Empty(t, dr.Writes())
+		})
+	}
+}
+
+func TestDryRunApiWrapper_Reads(t *testing.T) {
+	m := &MockApiWrapper{}
+	m.On("GetSpreadsheet", mock.Anything).Return(&sheets.Spreadsheet{SpreadsheetId: "doc"}, nil).Once()
+	m.On("GetRange", mock.Anything, "A1").Return(&sheets.ValueRange{Range: "A1"}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A1", "B1"}).Return(&sheets.BatchGetValuesResponse{}, nil).Once()
+
+	dr := NewDryRunApiWrapper(m, zaptest.NewLogger(t))
+	ctx := context.Background()
+
+	ss, err := dr.GetSpreadsheet(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "doc", ss.SpreadsheetId)
+	vr, err := dr.GetRange(ctx, "A1")
+	assert.NoError(t, err)
+	assert.Equal(t, "A1", vr.Range)
+	_, err = dr.BatchGetRanges(ctx, []string{"A1", "B1"})
+	assert.NoError(t, err)
+
+	resp, err := dr.BatchUpdate(ctx, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, resp.TotalUpdatedCells)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Middleware decorates an ApiWrapper, for example to log, throttle or alter calls
+type Middleware func(next ApiWrapper) ApiWrapper
+
+// Chain wraps aw with the middlewares, the first one being the outermost, so it sees every call first
+func Chain(aw ApiWrapper, middlewares ...Middleware) ApiWrapper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		aw = middlewares[i](aw)
+	}
+	return aw
+}
+
+// CoalescingMiddleware returns a Middleware that wraps with a Coalescer
+func CoalescingMiddleware(logger *zap.Logger, config CoalescerConfig) Middleware {
+	return func(next ApiWrapper) ApiWrapper {
+		return NewCoalescer(next, logger, config)
+	}
+}
+
+// RateLimitingMiddleware returns a Middleware that waits for the limiter before each call.
+// Use it with wrappers other than ApiWrapperImpl, that one should be given the limiter by SetRateLimiter instead, so retries are throttled as well.
+func RateLimitingMiddleware(limiter *RateLimiter) Middleware {
+	return func(next ApiWrapper) ApiWrapper {
+		return &rateLimitedApiWrapper{next: next, limiter: limiter}
+	}
+}
+
+type rateLimitedApiWrapper struct {
+	next    ApiWrapper
+	limiter *RateLimiter
+}
+
+func (rl *rateLimitedApiWrapper) GetSpreadsheet(ctx context.Context) (*sheets.Spreadsheet, error) {
+	if _, err := rl.limiter.WaitRead(ctx); err != nil {
+		return nil, err
+	}
+	return rl.next.GetSpreadsheet(ctx)
+}
+
+func (rl *rateLimitedApiWrapper) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	if _, err := rl.limiter.WaitRead(ctx); err != nil {
+		return nil, err
+	}
+	return rl.next.GetRange(ctx, range_)
+}
+
+func (rl *rateLimitedApiWrapper) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	if _, err := rl.limiter.WaitRead(ctx); err != nil {
+		return nil, err
+	}
+	return rl.next.BatchGetRanges(ctx, ranges)
+}
+
+func (rl *rateLimitedApiWrapper) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	if _, err := rl.limiter.WaitWrite(ctx); err != nil {
+		return nil, err
+	}
+	return rl.next.BatchUpdate(ctx, values)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/sheets/v4"
+	"testing"
+)
+
+// testNamedApiWrapper records its name to the shared list on each call, to check the order middlewares are called in
+type testNamedApiWrapper struct {
+	ApiWrapper
+	name  string
+	calls *[]string
+}
+
+func (w *testNamedApiWrapper) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	*w.calls = append(*w.calls, w.name)
+	return w.ApiWrapper.GetRange(ctx, range_)
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	named := func(name string) Middleware {
+		return func(next ApiWrapper) ApiWrapper {
+			return &testNamedApiWrapper{ApiWrapper: next, name: name, calls: &calls}
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		middlewares []Middleware
+		expected    []string
+	}{
+		{name: "none", middlewares: nil, expected: nil},
+		{name: "single", middlewares: []Middleware{named("a")}, expected: []string{"a"}},
+		{name: "first is outermost", middlewares: []Middleware{named("a"), named("b"), named("c")}, expected: []string{"a", "b", "c"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls = nil
+			m := &MockApiWrapper{}
+			m.On("GetRange", mock.Anything, "A1").Return(&sheets.ValueRange{}, nil).Once()
+
+			aw := Chain(m, tc.middlewares...)
+			_, err := aw.GetRange(context.Background(), "A1")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, calls)
+			m.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRateLimitingMiddleware(t *testing.T) {
+	m := &MockApiWrapper{}
+	m.On("GetSpreadsheet", mock.Anything).Return(&sheets.Spreadsheet{}, nil).Once()
+	m.On("GetRange", mock.Anything, "A1").Return(&sheets.ValueRange{}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A1"}).Return(&sheets.BatchGetValuesResponse{}, nil).Once()
+	m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+
+	limiter := NewRateLimiter(RateLimiterConfig{ReadsPerMinute: 60, WritesPerMinute: 60, Burst: 1})
+	aw := Chain(m, RateLimitingMiddleware(limiter))
+	ctx := context.Background()
+
+	_, err := aw.GetSpreadsheet(ctx)
+	assert.NoError(t, err)
+	_, err = aw.BatchUpdate(ctx, nil)
+	assert.NoError(t, err)
+
+	// the read bucket is empty now, so the next read would have to wait
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = aw.GetRange(cancelled, "A1")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = aw.BatchGetRanges(cancelled, []string{"A1"})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	stats := limiter.Stats()
+	assert.Equal(t, uint64(3), stats.Reads)
+	assert.Equal(t, uint64(1), stats.Writes)
+	assert.Equal(t, uint64(2), stats.ReadWaits)
+	m.AssertNumberOfCalls(t, "GetRange", 0)
+	m.AssertNumberOfCalls(t, "BatchGetRanges", 0)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiterConfig configures the quotas of a RateLimiter. Zero values mean no limit.
+// Google applies the quotas per minute, see https://developers.google.com/sheets/api/limits
+type RateLimiterConfig struct {
+	ReadsPerMinute  int // requests reading values or metadata
+	WritesPerMinute int // requests writing values
+	Burst           int // maximum number of requests of each kind that can be done at once, defaults to the per minute quota
+}
+
+// RateLimiterStats holds the counters of a RateLimiter
+type RateLimiterStats struct {
+	Reads         uint64
+	Writes        uint64
+	ReadWaits     uint64 // reads that had to wait for the limiter
+	WriteWaits    uint64 // writes that had to wait for the limiter
+	ReadWaitTime  time.Duration
+	WriteWaitTime time.Duration
+}
+
+// tokenBucket is refilled continuously, tokens may go negative, which means requests are already waiting for them
+type tokenBucket struct {
+	perSecond float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+}
+
+func newTokenBucket(perMinute int, burst int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &tokenBucket{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+	}
+}
+
+// reserve takes a token, and returns how long to wait until it is actually available
+func (tb *tokenBucket) reserve(now time.Time) time.Duration {
+	if !tb.last.IsZero() {
+		tb.tokens = min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.perSecond)
+	}
+	tb.last = now
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.perSecond * float64(time.Second))
+}
+
+// cancel gives back a token that was reserved but not used
+func (tb *tokenBucket) cancel() {
+	tb.tokens = min(tb.burst, tb.tokens+1)
+}
+
+// RateLimiter throttles requests on the client side, so the quotas are not exceeded in the first place. Reads and writes are counted separately.
+// It is safe for concurrent use, so the same instance can be shared among more wrappers using the same project.
+type RateLimiter struct {
+	mu     sync.Mutex
+	reads  *tokenBucket // nil if not limited
+	writes *tokenBucket
+	stats  RateLimiterStats
+
+	now func() time.Time // replaced in tests
+}
+
+// NewRateLimiter creates a new RateLimiter with the configured quotas
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	return &RateLimiter{
+		reads:  newTokenBucket(config.ReadsPerMinute, config.Burst),
+		writes: newTokenBucket(config.WritesPerMinute, config.Burst),
+		now:    time.Now,
+	}
+}
+
+// WaitRead blocks until a read request can be done, it returns the time waited
+func (rl *RateLimiter) WaitRead(ctx context.Context) (time.Duration, error) {
+	return rl.wait(ctx, false)
+}
+
+// WaitWrite blocks until a write request can be done, it returns the time waited
+func (rl *RateLimiter) WaitWrite(ctx context.Context) (time.Duration, error) {
+	return rl.wait(ctx, true)
+}
+
+func (rl *RateLimiter) wait(ctx context.Context, write bool) (time.Duration, error) {
+	rl.mu.Lock()
+	bucket := rl.reads
+	count, waits, waitTime := &rl.stats.Reads, &rl.stats.ReadWaits, &rl.stats.ReadWaitTime
+	if write {
+		bucket = rl.writes
+		count, waits, waitTime = &rl.stats.Writes, &rl.stats.WriteWaits, &rl.stats.WriteWaitTime
+	}
+	*count++
+	var d time.Duration
+	if bucket != nil {
+		d = bucket.reserve(rl.now())
+	}
+	if d > 0 {
+		*waits++
+		*waitTime += d
+	}
+	rl.mu.Unlock()
+
+	if d == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return d, nil
+	case <-ctx.Done():
+		rl.mu.Lock()
+		bucket.cancel()
+		rl.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (rl *RateLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.stats
+}
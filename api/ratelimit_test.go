@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tb := newTokenBucket(60, 2) // one per second
+
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+	assert.Equal(t, time.Second, tb.reserve(now))
+	assert.Equal(t, 2*time.Second, tb.reserve(now)) // queued behind the previous one
+
+	now = now.Add(10 * time.Second) // refilled, but not above the burst
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+	assert.Equal(t, time.Second, tb.reserve(now))
+
+	tb.cancel()
+	assert.Equal(t, time.Second, tb.reserve(now))
+
+	assert.Nil(t, newTokenBucket(0, 10))
+}
+
+func TestRateLimiter(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{ReadsPerMinute: 600, Burst: 1}) // one per 100ms, writes are not limited
+	ctx := context.Background()
+
+	waited, err := rl.WaitRead(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, waited)
+
+	start := time.Now()
+	waited, err = rl.WaitRead(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, waited, 50*time.Millisecond)
+	assert.GreaterOrEqual(t, time.Since(start), waited)
+
+	for i := 0; i < 10; i++ {
+		waited, err = rl.WaitWrite(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, waited)
+	}
+
+	stats := rl.Stats()
+	assert.Equal(t, uint64(2), stats.Reads)
+	assert.Equal(t, uint64(1), stats.ReadWaits)
+	assert.Greater(t, stats.ReadWaitTime, 50*time.Millisecond)
+	assert.Equal(t, uint64(10), stats.Writes)
+	assert.Zero(t, stats.WriteWaits)
+}
+
+func TestRateLimiterContextCancel(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{WritesPerMinute: 1})
+	_, err := rl.WaitWrite(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = rl.WaitWrite(ctx) // would wait a minute
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	assert.InDelta(t, 0, rl.writes.tokens, 0.01) // the token was given back
+}
+
+func TestRateLimiterShared(t *testing.T) {
+	rl := NewRateLimiter(RateLimiterConfig{ReadsPerMinute: 6000, Burst: 5}) // one per 10ms
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := rl.WaitRead(ctx)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// 5 go through at once, the other 5 have to wait for 10ms each
+	assert.GreaterOrEqual(t, time.Since(start), 45*time.Millisecond)
+	assert.Equal(t, uint64(5), rl.Stats().ReadWaits)
+}
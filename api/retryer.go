@@ -2,19 +2,60 @@ package api
 
 import (
 	"context"
+	"errors"
 	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
 	"math"
 	"math/rand"
+	"net/http"
+	"strconv"
 	"time"
 )
 
 const (
 	maxRetryCount  = 7
+	baseBackoff    = time.Second * 2
 	maxBackoffTime = time.Second * 32
+	maxJitter      = time.Second
 )
 
-// DoRetry is a lazy implementation based on https://developers.google.com/sheets/api/limits
+// RetryPolicy configures how failed API calls are retried. The zero value does not retry at all.
+// The delay before the n-th retry is BaseDelay * 2^(n-1) plus a random jitter, capped at MaxDelay.
+// If the server sends a Retry-After header, that delay is used instead, capped at MaxDelay as well.
+type RetryPolicy struct {
+	MaxAttempts int              // number of attempts including the first one, values below 1 are treated as 1
+	BaseDelay   time.Duration    // delay before the first retry, doubled for each one after
+	MaxDelay    time.Duration    // upper limit of a single delay, 0 means no limit
+	Jitter      time.Duration    // upper limit of the random duration added to each delay, 0 means none
+	ShouldRetry func(error) bool // classifies errors as retryable, ShouldRetryAPICall is used if nil
+}
+
+// DefaultRetryPolicy returns the policy recommended by https://developers.google.com/sheets/api/limits
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxRetryCount,
+		BaseDelay:   baseBackoff,
+		MaxDelay:    maxBackoffTime,
+		Jitter:      maxJitter,
+		ShouldRetry: ShouldRetryAPICall,
+	}
+}
+
+// DoRetry retries with the default policy, but with a custom classifier
 func DoRetry(ctx context.Context, logger *zap.Logger, retryableFunc func() error, shouldRetry func(error) bool) error {
+	p := DefaultRetryPolicy()
+	p.ShouldRetry = shouldRetry
+	return p.Do(ctx, logger, retryableFunc)
+}
+
+// Do calls retryableFunc until it succeeds, the error is not retryable, the attempts are exhausted, or the context is done.
+// If the context has a deadline that would pass before the next attempt, it gives up right away, returning the last error.
+func (p RetryPolicy) Do(ctx context.Context, logger *zap.Logger, retryableFunc func() error) error {
+	maxAttempts := max(p.MaxAttempts, 1)
+	shouldRetry := p.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = ShouldRetryAPICall
+	}
 
 	var nextTryTimeout time.Duration = 0
 	var tryCount int
@@ -22,34 +63,77 @@ func DoRetry(ctx context.Context, logger *zap.Logger, retryableFunc func() error
 		tryCount++
 		select {
 		case <-time.After(nextTryTimeout):
-			logger.Debug("Attempting to do retryable request...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount))
+			logger.Debug("Attempting to do retryable request...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts))
 			err := retryableFunc()
 
 			if err == nil {
 				// no error, we are good
-				logger.Debug("Retryable request succeeded", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount))
+				logger.Debug("Retryable request succeeded", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts))
 				return nil
 			}
 
 			// there was an error
-			if tryCount >= maxRetryCount { // if tries exhausted, return
-				logger.Warn("Maximum retry limit reached... giving up...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount))
+			if tryCount >= maxAttempts { // if tries exhausted, return
+				logger.Warn("Maximum retry limit reached... giving up...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts))
 				return err
 			}
 			if !shouldRetry(err) { // otherwise, check if we should retry
-				logger.Warn("The error is not retryable... giving up...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount), zap.Error(err))
+				logger.Warn("The error is not retryable... giving up...", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts), zap.Error(err))
 				return err
 			}
 
 			// if we should retry, set the timeout
-			randomNumberMilliseconds := time.Duration(rand.Intn(1000)) * time.Millisecond // #nosec G404 this is just a retryer
-			exp := time.Duration(math.Pow(2, float64(tryCount))) * time.Second
-			nextTryTimeout = min(exp+randomNumberMilliseconds, maxBackoffTime)
-			logger.Debug("The retryable request failed. Scheduled a retry", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount), zap.Error(err), zap.Duration("nextTryTimeout", nextTryTimeout))
+			nextTryTimeout = p.delay(tryCount, err)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < nextTryTimeout {
+				logger.Warn("The next retry would be after the deadline... giving up...", zap.Int("tryCount", tryCount), zap.Duration("nextTryTimeout", nextTryTimeout), zap.Error(err))
+				return err
+			}
+			logger.Debug("The retryable request failed. Scheduled a retry", zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts), zap.Error(err), zap.Duration("nextTryTimeout", nextTryTimeout))
 		case <-ctx.Done():
-			logger.Debug("context cancelled", zap.Error(ctx.Err()), zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxRetryCount))
+			logger.Debug("context cancelled", zap.Error(ctx.Err()), zap.Int("tryCount", tryCount), zap.Int("maxRetryCount", maxAttempts))
 			return ctx.Err()
 		}
 	}
+}
+
+// delay returns the time to wait after the tryCount-th attempt failed with err
+func (p RetryPolicy) delay(tryCount int, err error) time.Duration {
+	if retryAfter, ok := RetryAfter(err); ok {
+		if p.MaxDelay > 0 {
+			retryAfter = min(retryAfter, p.MaxDelay)
+		}
+		return retryAfter
+	}
+
+	d := p.BaseDelay
+	for i := 1; i < tryCount && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(p.Jitter))) // #nosec G404 this is just a retryer
+	}
+	if p.MaxDelay > 0 {
+		d = min(d, p.MaxDelay)
+	}
+	return d
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of an API error, if there is one
+func RetryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
 
+	val := apiErr.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, parseErr := strconv.Atoi(val); parseErr == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, parseErr := http.ParseTime(val); parseErr == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
 }
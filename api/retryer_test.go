@@ -3,8 +3,13 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/googleapi"
+	"net"
+	"net/http"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -88,3 +93,153 @@ func TestRetryerDontRetryIfShouldNot(t *testing.T) {
 	assert.ErrorIs(t, err, testErr)
 	assert.Equal(t, 1, calls)
 }
+
+func TestRetryPolicy(t *testing.T) {
+	testErr := errors.New("hello")
+
+	testCases := []struct {
+		name          string
+		policy        RetryPolicy
+		errs          []error
+		expectedErr   error
+		expectedCalls int
+	}{
+		{
+			name:          "zero_value_does_not_retry",
+			policy:        RetryPolicy{ShouldRetry: func(error) bool { return true }},
+			errs:          []error{testErr, nil},
+			expectedErr:   testErr,
+			expectedCalls: 1,
+		},
+		{
+			name:          "succeeds_after_retries",
+			policy:        RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, ShouldRetry: func(error) bool { return true }},
+			errs:          []error{testErr, testErr, nil},
+			expectedCalls: 3,
+		},
+		{
+			name:          "attempts_exhausted",
+			policy:        RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Jitter: time.Millisecond, ShouldRetry: func(error) bool { return true }},
+			errs:          []error{testErr, testErr, testErr, nil},
+			expectedErr:   testErr,
+			expectedCalls: 3,
+		},
+		{
+			name:          "default_classifier",
+			policy:        RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errs:          []error{&googleapi.Error{Code: http.StatusBadGateway}, testErr, nil},
+			expectedErr:   testErr,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			err := tc.policy.Do(context.Background(), zaptest.NewLogger(t), func() error {
+				calls++
+				return tc.errs[calls-1]
+			})
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedCalls, calls)
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	assert.Equal(t, time.Second, p.delay(1, nil))
+	assert.Equal(t, 2*time.Second, p.delay(2, nil))
+	assert.Equal(t, 8*time.Second, p.delay(4, nil))
+	assert.Equal(t, 10*time.Second, p.delay(5, nil))
+	assert.Equal(t, 10*time.Second, p.delay(1000, nil))
+
+	p.Jitter = time.Second
+	for i := 0; i < 16; i++ {
+		d := p.delay(1, nil)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.Less(t, d, 2*time.Second)
+	}
+
+	// Retry-After takes precedence, but it is capped as well
+	err := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"60"}}}
+	assert.Equal(t, 10*time.Second, p.delay(1, err))
+}
+
+func TestRetryAfter(t *testing.T) {
+	testCases := []struct {
+		name          string
+		err           error
+		expectedDelay time.Duration
+		expectedOk    bool
+
+		maxDelay            time.Duration // the delay of a policy with this MaxDelay is checked as well, if set
+		expectedPolicyDelay time.Duration
+	}{
+		{name: "not_api_error", err: errors.New("hello")},
+		{name: "no_header", err: &googleapi.Error{Code: http.StatusTooManyRequests}},
+		{name: "seconds", err: &googleapi.Error{Header: http.Header{"Retry-After": []string{"3"}}}, expectedDelay: 3 * time.Second, expectedOk: true},
+		{name: "past_date", err: &googleapi.Error{Header: http.Header{"Retry-After": []string{"Wed, 21 Oct 2015 07:28:00 GMT"}}}, expectedDelay: 0, expectedOk: true},
+		{name: "invalid", err: &googleapi.Error{Header: http.Header{"Retry-After": []string{"soon"}}}},
+		{name: "wrapped", err: fmt.Errorf("wrapped: %w", &googleapi.Error{Header: http.Header{"Retry-After": []string{"1"}}}), expectedDelay: time.Second, expectedOk: true},
+		{name: "capped_by_policy", err: &googleapi.Error{Header: http.Header{"Retry-After": []string{"120"}}}, expectedDelay: 2 * time.Minute, expectedOk: true, maxDelay: 10 * time.Second, expectedPolicyDelay: 10 * time.Second},
+		{name: "below_policy_cap", err: &googleapi.Error{Header: http.Header{"Retry-After": []string{"3"}}}, expectedDelay: 3 * time.Second, expectedOk: true, maxDelay: 10 * time.Second, expectedPolicyDelay: 3 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, ok := RetryAfter(tc.err)
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedDelay, d)
+
+			if tc.maxDelay > 0 {
+				assert.Equal(t, tc.expectedPolicyDelay, RetryPolicy{MaxDelay: tc.maxDelay}.delay(1, tc.err))
+			}
+		})
+	}
+}
+
+func TestRetryPolicyGivesUpBeforeDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	apiErr := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"30"}}}
+	var calls int
+	start := time.Now()
+	err := DefaultRetryPolicy().Do(ctx, zaptest.NewLogger(t), func() error {
+		calls++
+		return apiErr
+	})
+
+	assert.ErrorIs(t, err, apiErr) // the API error, not the context error
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestShouldRetryAPICall(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "too_many_requests", err: &googleapi.Error{Code: http.StatusTooManyRequests}, expected: true},
+		{name: "unavailable", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, expected: true},
+		{name: "internal", err: &googleapi.Error{Code: http.StatusInternalServerError}, expected: true},
+		{name: "bad_gateway", err: &googleapi.Error{Code: http.StatusBadGateway}, expected: true},
+		{name: "gateway_timeout", err: &googleapi.Error{Code: http.StatusGatewayTimeout}, expected: true},
+		{name: "network_timeout", err: &url.Error{Op: "Get", URL: "https://example.com", Err: &net.DNSError{IsTimeout: true}}, expected: true},
+		{name: "own_deadline", err: &url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}, expected: false},
+		{name: "bad_request", err: &googleapi.Error{Code: http.StatusBadRequest}, expected: false},
+		{name: "other", err: errors.New("hello"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ShouldRetryAPICall(tc.err))
+		})
+	}
+}
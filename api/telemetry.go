@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/api/sheets/v4"
+	"time"
+)
+
+// InstrumentationName is the name of the tracer and meter used by sheetsorm
+const InstrumentationName = "github.com/pproj/sheetsorm"
+
+// attribute keys used in spans and metrics
+const (
+	AttrMethod   = attribute.Key("sheetsorm.method")
+	AttrDocID    = attribute.Key("sheetsorm.doc_id")
+	AttrSheet    = attribute.Key("sheetsorm.sheet")
+	AttrRange    = attribute.Key("sheetsorm.range")
+	AttrRanges   = attribute.Key("sheetsorm.ranges")
+	AttrAttempt  = attribute.Key("sheetsorm.attempt")
+	AttrAttempts = attribute.Key("sheetsorm.attempts")
+	AttrRetries  = attribute.Key("sheetsorm.retries")
+	AttrCells    = attribute.Key("sheetsorm.cells")
+	AttrError    = attribute.Key("sheetsorm.error") // true if the call failed
+	AttrWrite    = attribute.Key("sheetsorm.write") // true if the call counts against the write quota
+)
+
+// Telemetry holds the tracer and the instruments used to observe API calls. A nil *Telemetry does nothing.
+type Telemetry struct {
+	tracer           trace.Tracer
+	callDuration     metric.Float64Histogram
+	retries          metric.Int64Counter
+	throttleDuration metric.Float64Histogram
+}
+
+// NewTelemetry creates the instruments from the providers, nil providers are replaced with no-op ones
+func NewTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) (*Telemetry, error) {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+	meter := mp.Meter(InstrumentationName)
+
+	callDuration, err := meter.Float64Histogram("sheetsorm.api.call.duration",
+		metric.WithDescription("Duration of Sheets API calls, including retries and throttling"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	retries, err := meter.Int64Counter("sheetsorm.api.retries",
+		metric.WithDescription("Number of Sheets API calls retried"),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	throttleDuration, err := meter.Float64Histogram("sheetsorm.api.throttle.duration",
+		metric.WithDescription("Time Sheets API calls waited for the rate limiter, recorded only if a rate limiter is set"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Telemetry{
+		tracer:           tp.Tracer(InstrumentationName),
+		callDuration:     callDuration,
+		retries:          retries,
+		throttleDuration: throttleDuration,
+	}, nil
+}
+
+// SetTelemetry makes the wrapper trace and measure every call. It must be called before the wrapper is used.
+func (aw *ApiWrapperImpl) SetTelemetry(t *Telemetry) {
+	aw.telemetry = t
+}
+
+// call runs a single API call with throttling, retries and telemetry. do makes one attempt with the context of the call's span,
+// and returns the number of cells read or written
+func (aw *ApiWrapperImpl) call(ctx context.Context, method string, write bool, attrs []attribute.KeyValue, do func(ctx context.Context) (int64, error)) error {
+	t := aw.telemetry
+	var span trace.Span
+	if t != nil {
+		attrs = append(attrs, AttrMethod.String(method), AttrDocID.String(aw.docID), AttrSheet.String(aw.sheet))
+		ctx, span = t.tracer.Start(ctx, "sheetsorm.ApiWrapper."+method, trace.WithAttributes(attrs...), trace.WithSpanKind(trace.SpanKindClient))
+	}
+	start := time.Now()
+
+	var attempts int
+	var cells int64
+	err := aw.retryPolicy.Do(ctx, aw.logger, func() error {
+		attempts++
+		if span != nil && attempts > 1 {
+			span.AddEvent("retry", trace.WithAttributes(AttrAttempt.Int(attempts)))
+		}
+		err := aw.throttle(ctx, write)
+		if err != nil {
+			return err
+		}
+		cells, err = do(ctx)
+		return err
+	})
+
+	if t == nil {
+		return err
+	}
+
+	metricAttrs := metric.WithAttributes(AttrMethod.String(method), AttrError.Bool(err != nil))
+	t.callDuration.Record(ctx, time.Since(start).Seconds(), metricAttrs)
+	if attempts > 1 {
+		t.retries.Add(ctx, int64(attempts-1), metric.WithAttributes(AttrMethod.String(method)))
+	}
+
+	span.SetAttributes(AttrAttempts.Int(attempts), AttrRetries.Int(attempts-1), AttrCells.Int64(cells))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
+
+// countCells counts the cells returned in value ranges
+func countCells(vrs ...*sheets.ValueRange) int64 {
+	var cells int64
+	for _, vr := range vrs {
+		if vr == nil {
+			continue
+		}
+		for _, row := range vr.Values {
+			cells += int64(len(row))
+		}
+	}
+	return cells
+}
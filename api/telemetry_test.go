@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSpan struct {
+	tracenoop.Span
+	name   string
+	attrs  map[attribute.Key]attribute.Value
+	events []string
+	status codes.Code
+	ended  bool
+}
+
+func (s *testSpan) IsRecording() bool { return true }
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+func (s *testSpan) AddEvent(name string, _ ...trace.EventOption) { s.events = append(s.events, name) }
+func (s *testSpan) SetStatus(code codes.Code, _ string)          { s.status = code }
+func (s *testSpan) End(...trace.SpanEndOption)                   { s.ended = true }
+
+type testTracer struct {
+	tracenoop.Tracer
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &testSpan{name: name, attrs: make(map[attribute.Key]attribute.Value)}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+type testTracerProvider struct {
+	tracenoop.TracerProvider
+	tracer *testTracer
+}
+
+func (p *testTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer { return p.tracer }
+
+// testMeter sums every measurement by instrument name and attributes
+type testMeter struct {
+	metricnoop.Meter
+	mu     sync.Mutex
+	sums   map[string]float64
+	counts map[string]int
+}
+
+func (m *testMeter) record(name string, attrs attribute.Set, val float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := name + "{" + attrs.Encoded(attribute.DefaultEncoder()) + "}"
+	m.sums[key] += val
+	m.counts[key]++
+}
+
+type testCounter struct {
+	metricnoop.Int64Counter
+	name  string
+	meter *testMeter
+}
+
+func (c *testCounter) Add(_ context.Context, incr int64, opts ...metric.AddOption) {
+	cfg := metric.NewAddConfig(opts)
+	c.meter.record(c.name, cfg.Attributes(), float64(incr))
+}
+
+type testHistogram struct {
+	metricnoop.Float64Histogram
+	name  string
+	meter *testMeter
+}
+
+func (h *testHistogram) Record(_ context.Context, val float64, opts ...metric.RecordOption) {
+	cfg := metric.NewRecordConfig(opts)
+	h.meter.record(h.name, cfg.Attributes(), val)
+}
+
+func (m *testMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &testCounter{name: name, meter: m}, nil
+}
+
+func (m *testMeter) Float64Histogram(name string, _ ...metric.Float64HistogramOption) (metric.Float64Histogram, error) {
+	return &testHistogram{name: name, meter: m}, nil
+}
+
+type testMeterProvider struct {
+	metricnoop.MeterProvider
+	meter *testMeter
+}
+
+func (p *testMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter { return p.meter }
+
+func TestApiWrapperTelemetry(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"range": "Sheet1!A1:B2", "majorDimension": "ROWS", "values": [["a", "b"], ["c"]]}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	service, err := sheets.NewService(ctx, option.WithoutAuthentication(), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	tracer := &testTracer{}
+	meter := &testMeter{sums: make(map[string]float64), counts: make(map[string]int)}
+	telemetry, err := NewTelemetry(&testTracerProvider{tracer: tracer}, &testMeterProvider{meter: meter})
+	assert.NoError(t, err)
+
+	aw := NewApiWrapperWithRetryPolicy(service, "doc", "Sheet1", zaptest.NewLogger(t), RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	aw.SetTelemetry(telemetry)
+
+	vr, err := aw.GetRange(ctx, "A1:B2")
+	assert.NoError(t, err)
+	assert.Len(t, vr.Values, 2)
+
+	assert.Len(t, tracer.spans, 1)
+	span := tracer.spans[0]
+	assert.Equal(t, "sheetsorm.ApiWrapper.GetRange", span.name)
+	assert.True(t, span.ended)
+	assert.Equal(t, codes.Unset, span.status)
+	assert.Equal(t, []string{"retry"}, span.events)
+	assert.Equal(t, "Sheet1!A1:B2", span.attrs[AttrRange].AsString())
+	assert.Equal(t, "doc", span.attrs[AttrDocID].AsString())
+	assert.Equal(t, int64(2), span.attrs[AttrAttempts].AsInt64())
+	assert.Equal(t, int64(1), span.attrs[AttrRetries].AsInt64())
+	assert.Equal(t, int64(3), span.attrs[AttrCells].AsInt64())
+
+	assert.Equal(t, 1.0, meter.sums["sheetsorm.api.retries{sheetsorm.method=GetRange}"])
+	assert.Equal(t, 1, meter.counts["sheetsorm.api.call.duration{sheetsorm.error=false,sheetsorm.method=GetRange}"])
+}
+
+func TestApiWrapperTelemetryError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	service, err := sheets.NewService(ctx, option.WithoutAuthentication(), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	tracer := &testTracer{}
+	meter := &testMeter{sums: make(map[string]float64), counts: make(map[string]int)}
+	telemetry, err := NewTelemetry(&testTracerProvider{tracer: tracer}, &testMeterProvider{meter: meter})
+	assert.NoError(t, err)
+
+	aw := NewApiWrapper(service, "doc", "", zaptest.NewLogger(t))
+	aw.SetTelemetry(telemetry)
+
+	_, err = aw.BatchGetRanges(ctx, []string{"A1", "B2"})
+	assert.Error(t, err)
+
+	span := tracer.spans[0]
+	assert.Equal(t, codes.Error, span.status)
+	assert.Equal(t, []string{"A1", "B2"}, span.attrs[AttrRanges].AsStringSlice())
+	assert.Equal(t, int64(0), span.attrs[AttrRetries].AsInt64())
+	assert.Equal(t, 1, meter.counts["sheetsorm.api.call.duration{sheetsorm.error=true,sheetsorm.method=BatchGetRanges}"])
+	assert.Zero(t, meter.sums["sheetsorm.api.retries{sheetsorm.method=BatchGetRanges}"])
+}
+
+func TestNewTelemetryNilProviders(t *testing.T) {
+	telemetry, err := NewTelemetry(nil, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, telemetry)
+}
+
+type spanCheckingTransport struct {
+	spans []trace.Span
+}
+
+func (t *spanCheckingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.spans = append(t.spans, trace.SpanFromContext(r.Context()))
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestApiWrapperTelemetrySpanContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	transport := &spanCheckingTransport{}
+	service, err := sheets.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: transport}), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	tracer := &testTracer{}
+	telemetry, err := NewTelemetry(&testTracerProvider{tracer: tracer}, nil)
+	assert.NoError(t, err)
+
+	aw := NewApiWrapper(service, "doc", "", zaptest.NewLogger(t))
+	aw.SetTelemetry(telemetry)
+
+	_, err = aw.GetSpreadsheet(ctx)
+	assert.NoError(t, err)
+
+	// the request is made within the span of the call, so the HTTP client instrumentation nests under it
+	if assert.Len(t, transport.spans, 1) {
+		assert.Same(t, tracer.spans[0], transport.spans[0])
+	}
+}
+
+func TestApiWrapperTelemetryThrottle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	service, err := sheets.NewService(ctx, option.WithoutAuthentication(), option.WithEndpoint(srv.URL))
+	assert.NoError(t, err)
+
+	meter := &testMeter{sums: make(map[string]float64), counts: make(map[string]int)}
+	telemetry, err := NewTelemetry(nil, &testMeterProvider{meter: meter})
+	assert.NoError(t, err)
+
+	aw := NewApiWrapper(service, "doc", "", zaptest.NewLogger(t))
+	aw.SetTelemetry(telemetry)
+	aw.SetRateLimiter(NewRateLimiter(RateLimiterConfig{ReadsPerMinute: 6000, Burst: 1})) // one per 10ms
+
+	for range 2 {
+		_, err = aw.GetRange(ctx, "A1")
+		assert.NoError(t, err)
+	}
+
+	key := "sheetsorm.api.throttle.duration{sheetsorm.write=false}"
+	assert.Equal(t, 2, meter.counts[key]) // the first one did not wait, but it is recorded as well
+	assert.Greater(t, meter.sums[key], 0.0)
+}
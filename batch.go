@@ -0,0 +1,576 @@
+package sheetsorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/typemagic"
+	"go.uber.org/zap"
+	"google.golang.org/api/sheets/v4"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+type batchOpKind int
+
+const (
+	batchCreate batchOpKind = iota
+	batchUpdate
+	batchDelete
+)
+
+// batchOp is a single staged operation on a single record
+type batchOp struct {
+	kind   batchOpKind
+	record interface{} // pointer to struct
+
+	// filled while committing
+	uid      string
+	data     map[string]string // the cells to be written
+	rowNum   int
+	previous map[string]string // the row as it was before writing, used for rolling back
+}
+
+// batchGroup holds the operations for a single record type
+type batchGroup struct {
+	toolkit      *sheetsToolkit
+	writableCols []string // the columns that are not read-only, only these are cleared by deletes
+	ops          []*batchOp
+}
+
+// Batch collects creates, updates and deletes of records, and writes them together with Commit.
+// Records of different types can be mixed in the same batch. A Batch is not safe for concurrent use, and should not be reused after Commit.
+//
+// As only cell values are written, Delete clears every column of the record type that is not read-only in the row of the record instead of removing the row,
+// and Create writes the new records to the rows right after the last row with a uid. Nothing is written if any of those rows holds data in the columns of the record.
+type Batch struct {
+	si  *SheetImpl
+	ops []*batchOp
+	err error // the first error while staging, returned by Commit
+}
+
+// Batch returns a new, empty batch
+func (si *SheetImpl) Batch() *Batch {
+	return &Batch{si: si}
+}
+
+// Create stages new records, no record with the same uid may exist in the sheet
+func (b *Batch) Create(records ...interface{}) *Batch {
+	return b.stage(batchCreate, records)
+}
+
+// Update stages updates of existing records, the same way UpdateRecords would update them
+func (b *Batch) Update(records ...interface{}) *Batch {
+	return b.stage(batchUpdate, records)
+}
+
+// Delete stages deletion of existing records, only the uid field has to be filled (and the version field if the type has one)
+func (b *Batch) Delete(records ...interface{}) *Batch {
+	return b.stage(batchDelete, records)
+}
+
+// Len returns the number of records staged
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+func (b *Batch) stage(kind batchOpKind, records []interface{}) *Batch {
+	if b.err != nil {
+		return b
+	}
+	unwrappedRecords, err := unwrapRecords(records)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	for _, r := range unwrappedRecords {
+		b.ops = append(b.ops, &batchOp{kind: kind, record: r})
+	}
+	return b
+}
+
+// Commit validates every staged record, then writes all of them to the sheet. It uses a single API call to resolve the uids of each record type,
+// a single one to read the rows before writing, a single batch update to write them, and a single call to read back the written rows.
+// Created and updated records are loaded with the data read back, the same way UpdateRecords does.
+//
+// Nothing is written if any of the records is invalid, not found (or exists already when creating), or was changed by someone else when conflicts are checked.
+// If the write itself fails, the cells written are restored from the rows read before, best-effort, unless they were changed by someone else since,
+// or the lock was lost. If anything could not be restored, errors.ErrRollbackFailed is returned along with both errors.
+// The rollback only restores the values as they were displayed: formulas in the written cells are replaced by their results, and the values are parsed again
+// like user input, so formatting (precision hidden by a number format for example) may be lost. Cells that were not written are left as they are.
+func (b *Batch) Commit(ctx context.Context) error {
+	if b.err != nil {
+		return b.err
+	}
+	if len(b.ops) == 0 {
+		return nil
+	}
+
+	ctx, end := b.si.telemetry.start(ctx, "Batch.Commit", attrRecords.Int(len(b.ops)))
+	err := b.commit(ctx)
+	end(err)
+	return err
+}
+
+func (b *Batch) commit(ctx context.Context) error {
+	si := b.si
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	groups, expected, err := si.prepareBatch(ctx, b.ops)
+	if err != nil {
+		return err
+	}
+
+	if si.lock != nil {
+		for _, g := range groups {
+			err = si.lock.checkOutside(g.toolkit)
+			if err != nil {
+				return err
+			}
+		}
+
+		var release func()
+		ctx, release, err = si.lock.hold(ctx)
+		if err != nil {
+			si.logger.Error("Failed to acquire lock", zap.Error(err))
+			return err
+		}
+		defer release()
+	}
+
+	err = si.writeBatch(ctx, groups, expected)
+	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, e.ErrLockLost) {
+			err = errors.Join(cause, err)
+		}
+		si.logger.Error("error while committing batch", zap.Error(err))
+		return err
+	}
+
+	if si.dryRun != nil {
+		// nothing was written, so the records are left as they are
+		return nil
+	}
+
+	for _, g := range groups {
+		si.telemetry.addRowsWritten(ctx, recordTypeName(g.ops[0].record), len(g.ops))
+	}
+
+	return si.readBackBatch(ctx, groups)
+}
+
+// prepareBatch groups the operations by record type, and dumps and validates every record, without any API calls.
+// It returns the cells expected for each update and delete as well, with nil maps for operations not to be checked.
+func (si *SheetImpl) prepareBatch(ctx context.Context, ops []*batchOp) ([]*batchGroup, map[*batchOp]map[string]string, error) {
+	var groups []*batchGroup
+	groupsByType := make(map[reflect.Type]*batchGroup)
+	uidsByGroup := make(map[*batchGroup][]string)
+
+	for _, op := range ops {
+		typ := reflect.TypeOf(op.record).Elem()
+		g, ok := groupsByType[typ]
+		if !ok {
+			toolkit, err := si.getToolkit(reflect.New(typ).Elem().Interface())
+			if err != nil {
+				si.logger.Error("Failed to initialize toolkit", zap.Error(err))
+				return nil, nil, err
+			}
+			g = &batchGroup{toolkit: toolkit}
+			schema, _ := typemagic.SchemaOfType(typ) // already compiled by getToolkit
+			for _, f := range schema.Fields() {
+				if !f.Tag.IsReadOnly {
+					g.writableCols = append(g.writableCols, f.Tag.Column)
+				}
+			}
+			groupsByType[typ] = g
+			groups = append(groups, g)
+		}
+
+		uid, err := typemagic.DumpUID(op.record)
+		if err != nil {
+			return nil, nil, err
+		}
+		if uid == "" {
+			return nil, nil, e.ErrEmptyUID
+		}
+		if slices.Contains(uidsByGroup[g], uid) {
+			return nil, nil, e.ErrMultiUpdate
+		}
+		uidsByGroup[g] = append(uidsByGroup[g], uid)
+		op.uid = uid
+		g.ops = append(g.ops, op)
+
+		switch op.kind {
+		case batchCreate:
+			if !slices.Contains(g.writableCols, g.toolkit.uidCol) {
+				return nil, nil, errors.Join(e.ErrInvalidType, fmt.Errorf("records with a read-only uid field can not be created"))
+			}
+			op.data, err = typemagic.DumpStruct(op.record, true)
+		case batchUpdate:
+			op.data, err = typemagic.DumpStruct(op.record, true)
+		case batchDelete:
+			if !slices.Contains(g.writableCols, g.toolkit.uidCol) {
+				return nil, nil, errors.Join(e.ErrInvalidType, fmt.Errorf("records with a read-only uid field can not be deleted"))
+			}
+			if g.toolkit.versionCol != "" { // otherwise only the uid is needed
+				op.data, err = typemagic.DumpStruct(op.record, true)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	expected := make(map[*batchOp]map[string]string)
+	for _, g := range groups {
+		var uids []string
+		var allData []map[string]string
+		var records []interface{}
+		var checkedOps []*batchOp
+		for _, op := range g.ops {
+			if op.kind == batchCreate {
+				if g.toolkit.versionCol != "" {
+					if _, err := bumpVersion(g.toolkit, op.data); err != nil {
+						return nil, nil, err
+					}
+				}
+				continue
+			}
+			uids = append(uids, op.uid)
+			allData = append(allData, op.data)
+			records = append(records, op.record)
+			checkedOps = append(checkedOps, op)
+		}
+
+		groupExpected, err := si.expectedRows(g.toolkit, uids, allData, records)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, op := range checkedOps {
+			if groupExpected != nil && groupExpected[i] != nil {
+				expected[op] = groupExpected[i]
+			}
+		}
+	}
+
+	return groups, expected, nil
+}
+
+// writeBatch resolves the rows of the operations, reads them and checks them, then writes every operation with a single batch update
+func (si *SheetImpl) writeBatch(ctx context.Context, groups []*batchGroup, expected map[*batchOp]map[string]string) error {
+	err := si.resolveBatchRows(ctx, groups)
+	if err != nil {
+		return err
+	}
+
+	var ops []*batchOp
+	var toolkits []*sheetsToolkit
+	for _, g := range groups {
+		for _, op := range g.ops {
+			ops = append(ops, op)
+			toolkits = append(toolkits, g.toolkit)
+		}
+	}
+
+	previous, err := readBatchRows(ctx, toolkits, ops)
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		op.previous = previous[i]
+	}
+
+	for _, g := range groups {
+		err = checkBatchRows(g, expected)
+		if err != nil {
+			return err
+		}
+	}
+
+	valRanges := make([]*sheets.ValueRange, 0)
+	var writableCols [][]string
+	for _, g := range groups {
+		for range g.ops {
+			writableCols = append(writableCols, g.writableCols)
+		}
+	}
+	for i, op := range ops {
+		st := toolkits[i]
+		if op.kind == batchDelete {
+			// read-only columns are usually formulas or maintained by others, so they are left as they are
+			op.data = make(map[string]string, len(writableCols[i]))
+			for _, col := range writableCols[i] {
+				op.data[col] = ""
+			}
+		}
+		if len(op.data) == 0 {
+			continue
+		}
+
+		valRanges = append(valRanges, st.translateRowDataToUpdateRanges(op.rowNum, op.data)...)
+
+		if st.dryRun {
+			continue // nothing is written, so nothing goes stale
+		}
+		// every uid touched may be cached with a row that is going to be stale
+		st.uidCache.InvalidateUID(st.uidNs, op.uid)
+		if newUID := op.data[st.uidCol]; newUID != "" && newUID != op.uid {
+			st.uidCache.InvalidateUID(st.uidNs, newUID)
+		}
+		st.rowCache.InvalidateRow(st.rowNs, op.rowNum)
+	}
+	si.logger.Debug("Translated batch to range updates", zap.Int("len(ops)", len(ops)), zap.Int("len(valRanges)", len(valRanges)))
+
+	if len(valRanges) == 0 {
+		si.logger.Debug("nothing to update...")
+		return nil
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	_, err = si.aw.BatchUpdate(ctx, valRanges)
+	if err != nil {
+		// the update may have been applied even if it reported an error (a timeout for example), so write back what was there before
+		si.logger.Error("Batch update failed, rolling back", zap.Error(err))
+		rollbackErr := si.rollbackBatch(ctx, toolkits, ops)
+		if rollbackErr != nil {
+			si.logger.Error("Rolling back batch failed", zap.Error(rollbackErr))
+			return errors.Join(err, e.ErrRollbackFailed, rollbackErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// rollbackBatch restores the cells written by a failed batch update from the rows read before, best-effort.
+// The rows are read again first, and only the cells still holding the values written by the batch are restored, as the write may not have landed,
+// or someone else may have changed them since. It is skipped entirely if the lock was lost, as the new holder may be writing the same rows.
+// Only the written cells are restored, the previous values are formatted, so restoring anything else would replace formulas.
+func (si *SheetImpl) rollbackBatch(ctx context.Context, toolkits []*sheetsToolkit, ops []*batchOp) error {
+	if cause := context.Cause(ctx); errors.Is(cause, e.ErrLockLost) {
+		return errors.New("rollback skipped, as the lock was lost")
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	var writtenOps []*batchOp
+	var writtenToolkits []*sheetsToolkit
+	for i, op := range ops {
+		if len(op.data) > 0 {
+			writtenOps = append(writtenOps, op)
+			writtenToolkits = append(writtenToolkits, toolkits[i])
+		}
+	}
+
+	current, err := readBatchRows(ctx, writtenToolkits, writtenOps)
+	if err != nil {
+		return err
+	}
+
+	rollbackRanges := make([]*sheets.ValueRange, 0)
+	var skipped []string
+	for i, op := range writtenOps {
+		st := writtenToolkits[i]
+		rollbackData := make(map[string]string, len(op.data))
+		for col, val := range op.data {
+			switch current[i][col] {
+			case op.previous[col]:
+				// not written, or written with the same value, nothing to restore
+			case val:
+				rollbackData[col] = op.previous[col]
+			default:
+				skipped = append(skipped, fmt.Sprintf("%s%d", col, op.rowNum))
+			}
+		}
+		if len(rollbackData) > 0 {
+			rollbackRanges = append(rollbackRanges, st.translateRowDataToUpdateRanges(op.rowNum, rollbackData)...)
+		}
+	}
+
+	if len(rollbackRanges) > 0 {
+		_, err = si.aw.BatchUpdate(ctx, rollbackRanges)
+		if err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		slices.Sort(skipped)
+		return fmt.Errorf("cells changed by someone else were not rolled back: %s", strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// resolveBatchRows finds the row of each update and delete, and assigns rows to creates after the last row with a uid.
+// The uid column is read only once for record types sharing the same uid column. The rows assigned to creates are only known to have no uid,
+// checkBatchRows makes sure they are empty in every column of the record before anything is written.
+func (si *SheetImpl) resolveBatchRows(ctx context.Context, groups []*batchGroup) error {
+	type uidColumn struct {
+		rowNums map[string]int
+		created []string
+	}
+	uidColumns := make(map[string]*uidColumn)
+	var lastRow int
+
+	for _, g := range groups {
+		st := g.toolkit
+		column, ok := uidColumns[st.uidCol]
+		if !ok {
+			rowNums, last, err := st.readUIDColumn(ctx)
+			if err != nil {
+				return err
+			}
+			column = &uidColumn{rowNums: rowNums}
+			uidColumns[st.uidCol] = column
+			lastRow = max(lastRow, last)
+		}
+
+		for _, op := range g.ops {
+			rowNum, found := column.rowNums[op.uid]
+			if op.kind != batchCreate {
+				if !found {
+					return errors.Join(e.ErrRecordNotFound, fmt.Errorf("uid: %s", op.uid))
+				}
+				op.rowNum = rowNum
+				continue
+			}
+
+			if found {
+				return errors.Join(e.ErrRecordExists, fmt.Errorf("uid: %s", op.uid))
+			}
+			if slices.Contains(column.created, op.uid) {
+				return e.ErrMultiUpdate // different record types creating the same uid
+			}
+			column.created = append(column.created, op.uid)
+		}
+	}
+
+	// rows for new records are assigned after every uid column read, so they do not overlap with anything known
+	for _, g := range groups {
+		for _, op := range g.ops {
+			if op.kind == batchCreate {
+				lastRow++
+				op.rowNum = lastRow
+			}
+		}
+	}
+
+	return nil
+}
+
+// readBatchRows reads the rows of the operations with a single API call, each with the columns of its own toolkit. Empty rows are returned with empty cells.
+func readBatchRows(ctx context.Context, toolkits []*sheetsToolkit, ops []*batchOp) ([]map[string]string, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	ranges := make([]string, len(ops))
+	for i, op := range ops {
+		ranges[i] = fmt.Sprintf("%[1]s%[3]d:%[2]s%[3]d", toolkits[i].firstCol, toolkits[i].lastCol, op.rowNum)
+	}
+
+	vals, err := toolkits[0].aw.BatchGetRanges(ctx, ranges)
+	if err != nil {
+		toolkits[0].logger.Error("Failed to get rows from sheet", zap.Error(err), zap.Strings("ranges", ranges))
+		return nil, err
+	}
+	if len(vals.ValueRanges) != len(ranges) {
+		return nil, e.ErrInconsistentData
+	}
+
+	out := make([]map[string]string, len(ops))
+	for i, vr := range vals.ValueRanges {
+		var row []interface{}
+		if len(vr.Values) > 0 {
+			row = vr.Values[0]
+		}
+		out[i] = toolkits[i].translateFullRowToMap(row)
+	}
+	return out, nil
+}
+
+// checkBatchRows checks that updated and deleted rows still belong to their uids and hold the expected values, and that the rows of new records are empty in every column
+func checkBatchRows(g *batchGroup, expected map[*batchOp]map[string]string) error {
+	st := g.toolkit
+	var uids []string
+	var rowNums []int
+	var currentData []map[string]string
+	var expectedData []map[string]string
+
+	for _, op := range g.ops {
+		if op.kind == batchCreate {
+			for _, col := range st.cols {
+				if op.previous[col] != "" {
+					// the row may hold data without a uid, that must not be overwritten
+					st.logger.Error("The row for a new record is not empty", zap.Int("rowNum", op.rowNum), zap.String("col", col), zap.String("valueFound", op.previous[col]))
+					return errors.Join(e.ErrInconsistentData, fmt.Errorf("row %d is not empty in column %s", op.rowNum, col))
+				}
+			}
+			continue
+		}
+		uids = append(uids, op.uid)
+		rowNums = append(rowNums, op.rowNum)
+		currentData = append(currentData, op.previous)
+		expectedData = append(expectedData, expected[op])
+	}
+
+	return st.checkCurrentRows(uids, rowNums, currentData, expectedData)
+}
+
+// readBackBatch reads back the created and updated rows, loads them into their records, and updates the snapshot
+func (si *SheetImpl) readBackBatch(ctx context.Context, groups []*batchGroup) error {
+	var ops []*batchOp
+	var toolkits []*sheetsToolkit
+	for _, g := range groups {
+		for _, op := range g.ops {
+			if op.kind == batchDelete {
+				si.loadedRows.delete(newLoadedRowKey(g.toolkit, op.uid, op.record))
+				continue
+			}
+			ops = append(ops, op)
+			toolkits = append(toolkits, g.toolkit)
+		}
+	}
+
+	updatedData, err := readBatchRows(ctx, toolkits, ops)
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		toolkits[i].rowCache.CacheRow(toolkits[i].rowNs, op.rowNum, updatedData[i])
+		op.data = updatedData[i] // from now on data holds the row as it is in the sheet
+	}
+
+	if si.snapshot != nil {
+		for _, g := range groups {
+			groupData := make([]map[string]string, len(g.ops))
+			groupRowNums := make([]int, len(g.ops))
+			for i, op := range g.ops {
+				groupData[i] = op.data
+				groupRowNums[i] = op.rowNum
+			}
+			si.snapshot.update(g.toolkit, groupData, groupRowNums)
+		}
+	}
+
+	for i, op := range ops {
+		err = si.loadRecord(toolkits[i], op.data, op.rowNum, op.record)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package sheetsorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pproj/sheetsorm/api"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/sheetsormtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/sheets/v4"
+	"testing"
+	"time"
+)
+
+func testBatchRanges(rows ...[]interface{}) *sheets.BatchGetValuesResponse {
+	resp := &sheets.BatchGetValuesResponse{}
+	for _, row := range rows {
+		vr := &sheets.ValueRange{}
+		if row != nil {
+			vr.Values = [][]interface{}{row}
+		}
+		resp.ValueRanges = append(resp.ValueRanges, vr)
+	}
+	return resp
+}
+
+func testBatchUIDCol() *sheets.ValueRange {
+	return &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}, {"bob"}, {}, {"carol"}},
+	}
+}
+
+func TestBatch_Commit(t *testing.T) {
+	type withFormula struct {
+		Name  string `sheet:"A,uid"`
+		Total int    `sheet:"B,readonly"` // not cleared by the delete
+	}
+
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:A").Return(testBatchUIDCol(), nil).Once() // shared by both types
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A6:B6", "A3:B3"}).Return(testBatchRanges(
+		[]interface{}{"alice", "22"},
+		nil,
+		[]interface{}{"bob", "33"},
+	), nil).Once()
+	m.On("BatchUpdate", mock.Anything, []*sheets.ValueRange{
+		{MajorDimension: "ROWS", Range: "A2:B2", Values: [][]interface{}{{"alice", "23"}}},
+		{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"dave", "40"}}},
+		{MajorDimension: "ROWS", Range: "A3", Values: [][]interface{}{{""}}},
+	}).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A6:B6"}).Return(testBatchRanges(
+		[]interface{}{"alice", "23"},
+		[]interface{}{"dave", "41"}, // changed by a formula for example
+	), nil).Once()
+
+	si := newTestSheet(t, m, 1)
+	alice := &testSheetRecord{Name: "alice", Age: 23}
+	dave := &testSheetRecord{Name: "dave", Age: 40}
+
+	b := si.Batch().Update(alice).Delete(&withFormula{Name: "bob"}).Create([]*testSheetRecord{dave})
+	assert.Equal(t, 3, b.Len())
+	assert.NoError(t, b.Commit(context.Background()))
+
+	assert.Equal(t, testSheetRecord{Name: "alice", Age: 23}, *alice)
+	assert.Equal(t, testSheetRecord{Name: "dave", Age: 41}, *dave)
+	m.AssertExpectations(t)
+}
+
+func TestBatch_CommitEmpty(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	si := newTestSheet(t, m, 1)
+	assert.NoError(t, si.Batch().Commit(context.Background()))
+	m.AssertExpectations(t) // no calls at all
+}
+
+func TestBatch_LocalValidation(t *testing.T) {
+	type constrained struct {
+		Name string `sheet:"A,uid"`
+		Age  int    `sheet:"B,min=0"`
+	}
+	type readOnlyUID struct {
+		Name string `sheet:"A,uid,readonly"`
+		Age  int    `sheet:"B"`
+	}
+
+	testCases := []struct {
+		name        string
+		batch       func(b *Batch) *Batch
+		expectedErr error
+	}{
+		{
+			name: "invalid_type",
+			batch: func(b *Batch) *Batch {
+				return b.Update(&testSheetRecord{Name: "alice"}).Create(testSheetRecord{Name: "bob"})
+			},
+			expectedErr: e.ErrInvalidType,
+		},
+		{
+			name: "empty_uid",
+			batch: func(b *Batch) *Batch {
+				return b.Create(&testSheetRecord{})
+			},
+			expectedErr: e.ErrEmptyUID,
+		},
+		{
+			name: "same_uid_twice",
+			batch: func(b *Batch) *Batch {
+				return b.Update(&testSheetRecord{Name: "alice"}).Delete(&testSheetRecord{Name: "alice"})
+			},
+			expectedErr: e.ErrMultiUpdate,
+		},
+		{
+			name: "constraint_violated",
+			batch: func(b *Batch) *Batch {
+				return b.Update(&testSheetRecord{Name: "alice"}).Create(&constrained{Name: "bob", Age: -1})
+			},
+			expectedErr: e.ErrValidationFailed,
+		},
+		{
+			name: "create_readonly_uid",
+			batch: func(b *Batch) *Batch {
+				return b.Create(&readOnlyUID{Name: "bob"})
+			},
+			expectedErr: e.ErrInvalidType,
+		},
+		{
+			name: "delete_readonly_uid",
+			batch: func(b *Batch) *Batch {
+				return b.Delete(&readOnlyUID{Name: "bob"})
+			},
+			expectedErr: e.ErrInvalidType,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &api.MockApiWrapper{}
+			si := newTestSheet(t, m, 1)
+			err := tc.batch(si.Batch()).Commit(context.Background())
+			assert.ErrorIs(t, err, tc.expectedErr)
+			m.AssertExpectations(t) // nothing is called before everything is validated
+		})
+	}
+}
+
+func TestBatch_Resolve(t *testing.T) {
+	testCases := []struct {
+		name        string
+		batch       func(b *Batch) *Batch
+		expectedErr error
+	}{
+		{
+			name: "create_existing",
+			batch: func(b *Batch) *Batch {
+				return b.Create(&testSheetRecord{Name: "carol"})
+			},
+			expectedErr: e.ErrRecordExists,
+		},
+		{
+			name: "update_missing",
+			batch: func(b *Batch) *Batch {
+				return b.Update(&testSheetRecord{Name: "dave"})
+			},
+			expectedErr: e.ErrRecordNotFound,
+		},
+		{
+			name: "delete_missing",
+			batch: func(b *Batch) *Batch {
+				return b.Delete(&testSheetRecord{Name: "dave"})
+			},
+			expectedErr: e.ErrRecordNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &api.MockApiWrapper{}
+			m.On("GetRange", mock.Anything, "A2:A").Return(testBatchUIDCol(), nil).Once()
+			si := newTestSheet(t, m, 1)
+			err := tc.batch(si.Batch()).Commit(context.Background())
+			assert.ErrorIs(t, err, tc.expectedErr)
+			m.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBatch_CreateNotEmpty(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:A").Return(testBatchUIDCol(), nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A6:B6"}).Return(testBatchRanges(
+		[]interface{}{"", "note"}, // data without a uid
+	), nil).Once()
+
+	si := newTestSheet(t, m, 1)
+	err := si.Batch().Create(&testSheetRecord{Name: "dave", Age: 40}).Commit(context.Background())
+	assert.ErrorIs(t, err, e.ErrInconsistentData)
+	m.AssertExpectations(t) // nothing written
+}
+
+func TestBatch_Conflict(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:A").Return(testBatchUIDCol(), nil).Twice()
+	m.On("GetRange", mock.Anything, "A2:B2").Return(&sheets.ValueRange{
+		Values: [][]interface{}{{"alice", "22"}},
+	}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A3:B3"}).Return(testBatchRanges(
+		[]interface{}{"alice", "30"}, // changed since loaded
+		[]interface{}{"bob", "33"},
+	), nil).Once()
+
+	si := newTestSheet(t, m, 1)
+	si.conflictDetection = true
+	ctx := context.Background()
+
+	alice := &testSheetRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, alice))
+
+	alice.Age = 23
+	err := si.Batch().Update(alice, &testSheetRecord{Name: "bob", Age: 34}).Commit(ctx)
+	var conflictErr *e.ConflictError
+	assert.ErrorAs(t, err, &conflictErr)
+	assert.Len(t, conflictErr.Conflicts, 1)
+	assert.Equal(t, "alice", conflictErr.Conflicts[0].UID)
+	m.AssertExpectations(t) // nothing written
+}
+
+func TestBatch_Rollback(t *testing.T) {
+	testErr := errors.New("hello")
+	rollbackErr := errors.New("world")
+
+	testCases := []struct {
+		name           string
+		current        []interface{} // alice's row read back after the failed write, dave's row always holds what was written
+		rollbackRanges []*sheets.ValueRange
+		rollbackErr    error
+		expectedFailed bool
+	}{
+		{
+			name:    "rolled_back",
+			current: []interface{}{"alice", "23"},
+			rollbackRanges: []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "B2", Values: [][]interface{}{{"22"}}},
+				{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"", ""}}},
+			},
+		},
+		{
+			name:    "rollback_failed",
+			current: []interface{}{"alice", "23"},
+			rollbackRanges: []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "B2", Values: [][]interface{}{{"22"}}},
+				{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"", ""}}},
+			},
+			rollbackErr:    rollbackErr,
+			expectedFailed: true,
+		},
+		{
+			name:    "changed_since",
+			current: []interface{}{"alice", "30"}, // written by someone else after the failed write, so it is kept
+			rollbackRanges: []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"", ""}}},
+			},
+			expectedFailed: true,
+		},
+		{
+			name:    "not_written",
+			current: []interface{}{"alice", "22"},
+			rollbackRanges: []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"", ""}}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &api.MockApiWrapper{}
+			m.On("GetRange", mock.Anything, "A2:A").Return(testBatchUIDCol(), nil).Once()
+			m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A6:B6"}).Return(testBatchRanges(
+				[]interface{}{"alice", "22"},
+				nil,
+			), nil).Once()
+			m.On("BatchUpdate", mock.Anything, []*sheets.ValueRange{
+				{MajorDimension: "ROWS", Range: "A2:B2", Values: [][]interface{}{{"alice", "23"}}},
+				{MajorDimension: "ROWS", Range: "A6:B6", Values: [][]interface{}{{"dave", "40"}}},
+			}).Return((*sheets.BatchUpdateValuesResponse)(nil), testErr).Once()
+			m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A6:B6"}).Return(testBatchRanges(
+				tc.current,
+				[]interface{}{"dave", "40"},
+			), nil).Once()
+			m.On("BatchUpdate", mock.Anything, tc.rollbackRanges).Return(&sheets.BatchUpdateValuesResponse{}, tc.rollbackErr).Once()
+
+			si := newTestSheet(t, m, 1)
+			err := si.Batch().
+				Update(&testSheetRecord{Name: "alice", Age: 23}).
+				Create(&testSheetRecord{Name: "dave", Age: 40}).
+				Commit(context.Background())
+
+			assert.ErrorIs(t, err, testErr)
+			if tc.expectedFailed {
+				assert.ErrorIs(t, err, e.ErrRollbackFailed)
+			} else {
+				assert.NotErrorIs(t, err, e.ErrRollbackFailed)
+			}
+			if tc.rollbackErr != nil {
+				assert.ErrorIs(t, err, tc.rollbackErr)
+			}
+			m.AssertExpectations(t)
+		})
+	}
+}
+
+// lockThiefApi makes another process take over the lock and write to the sheet during the first write of records, which then hangs until cancelled
+type lockThiefApi struct {
+	api.ApiWrapper
+	s      *sheetsormtest.Spreadsheet
+	stolen bool
+}
+
+func (l *lockThiefApi) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	if values[0].Range == "Z1" || l.stolen { // only the lock is written by other goroutines
+		return l.ApiWrapper.BatchUpdate(ctx, values)
+	}
+	l.stolen = true
+	expiry := time.Now().Add(time.Hour).UnixMilli()
+	_ = l.s.SetValues("Z1", [][]string{{fmt.Sprintf("%d|thief", expiry)}})
+	_ = l.s.SetValues("A2:B2", [][]string{{"alice", "99"}})
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestBatch_LockLostDuringCommit(t *testing.T) {
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:B2", [][]string{{"name", "age"}, {"alice", "22"}}))
+
+	si, err := NewSheetWithWrapper(&lockThiefApi{ApiWrapper: s.ApiWrapper(""), s: s}, StructureConfig{DocID: "doc", SkipRows: 1},
+		WithAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", OwnerID: "me", TTL: 30 * time.Millisecond, RetryInterval: time.Millisecond, SettleDelay: -1}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = si.Batch().Update(&testSheetRecord{Name: "alice", Age: 23}).Commit(context.Background())
+	assert.ErrorIs(t, err, e.ErrLockLost)
+	assert.ErrorIs(t, err, e.ErrRollbackFailed)
+
+	// the rollback is skipped, so the thief's write is kept
+	values, err := s.Values("A2:B2")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"alice", "99"}}, values)
+}
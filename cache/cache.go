@@ -1,23 +1,40 @@
 package cache
 
+import "strings"
+
+// Namespace identifies the document and sheet (and for UIDs, the uid column) a cache entry belongs to.
+// It is built by sheetsorm and should be treated as an opaque string, for example a prefix for the keys of a remote cache.
+// Entries of different namespaces must not overwrite each other, as the same UID might be in different rows in two sheets.
+type Namespace string
+
+// NewUIDNamespace builds the namespace of the UIDs in the uid column of a sheet, it is shared by every record type using the same uid column
+func NewUIDNamespace(docID, sheet, uidCol string) Namespace {
+	return Namespace(strings.Join([]string{docID, sheet, uidCol}, "/"))
+}
+
+// NewRowNamespace builds the namespace of the rows of a sheet, it is shared by every record type of the sheet
+func NewRowNamespace(docID, sheet string) Namespace {
+	return Namespace(strings.Join([]string{docID, sheet}, "/"))
+}
+
 // RowUIDCache caches the row numbers for certain UIDs
 // Implementations must be thread safe
 // If the cache runs into any error, sheetsorm does not really care about that, so the cache can not report an error, it has to figure it out for itself
 // UIDs are always strings, they are what typemagic spits out for that row
 // It's generally okay to return stale data, because sheetsorm will check if the returned data has the correct UID, if not then it will invalidate the data.
-// The same RowUIDCache backend could be shared among more instances of sheetsorm to improve performance.
+// The same RowUIDCache backend could be shared among more instances of sheetsorm to improve performance, every call carries the Namespace the entry belongs to.
 type RowUIDCache interface {
 	// CacheUID should store the uid-rowNum pair in the cache
-	CacheUID(string, int)
+	CacheUID(Namespace, string, int)
 
 	// GetRowNumByUID should return a row number for a specific UID if it is in the cache. If not then the second return value must be false
 	// If anything goes wrong with the cache, this method should just return as if the data was not in the cache, and sheetsorm will go and fetch it
-	GetRowNumByUID(string) (int, bool)
+	GetRowNumByUID(Namespace, string) (int, bool)
 
-	// InvalidateUID should drop a cache entry for the specified UID
+	// InvalidateUID should drop a cache entry for the specified UID in the namespace
 	// Note: since caches may implement any sort of forget mechanism, dropping the entire cache for this call is
 	// a valid operation, but not really efficient
-	InvalidateUID(string)
+	InvalidateUID(Namespace, string)
 }
 
 // RowCache caches entire rows of data identified by their RowNum
@@ -27,13 +44,15 @@ type RowUIDCache interface {
 // Generally for small applications, RowCache isn't really recommended, as it's not really useful when there are a low amount of requests.
 // Errors from RowCache isn't interesting by sheetsorm either, the interface does not require error reporting capability on purpose.
 // The row cache WILL NEVER be used to lookup UIDs even if they are technically could be
+// Rows are namespaced by sheet only, so invalidating a row drops it for every record type of the sheet.
+// A row holds the columns of the record type that cached it last, sheetsorm treats rows missing some of its columns as a cache miss.
 type RowCache interface {
 	// CacheRow stores an entire row for a rowNum
-	CacheRow(int, map[string]string)
+	CacheRow(Namespace, int, map[string]string)
 
 	// GetRow should return a row for the rowNum if it's in the cache, if the rowNum is not in the cache, then the second return value must be false
-	GetRow(int) (map[string]string, bool)
+	GetRow(Namespace, int) (map[string]string, bool)
 
 	// InvalidateRow should drop the cache entry for the rowNum. Or drop the entire cache, it's fine either way.
-	InvalidateRow(int)
+	InvalidateRow(Namespace, int)
 }
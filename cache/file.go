@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileCacheVersion is increased whenever the on-disk format (including the format of namespaces) changes, files with a different version are ignored
+const fileCacheVersion = 2
+
+type fileCacheContent struct {
+	Version int                          `json:"version"`
+	UIDs    map[Namespace]map[string]int `json:"uids"`
+}
+
+// FileCache is a RowUIDCache that can persist its content to a local file, so short-lived processes (like CLI tools) do not start cold every time.
+// The stored row numbers might go stale between runs, that's fine, as sheetsorm checks every cached UID before using it.
+// It is safe for concurrent use, but a single file should only be used by one FileCache at a time.
+// Entries are never evicted, only invalidated, so the cache (and the file) grows with the number of distinct UIDs seen,
+// it is meant for sheets of a moderate size. Deleting the file is a safe way to start over.
+type FileCache struct {
+	mu    sync.Mutex
+	path  string
+	uids  map[Namespace]map[string]int
+	dirty bool
+}
+
+// NewFileCache creates a FileCache backed by the file at path, and loads its content if the file exists.
+// A file written by a different version of sheetsorm, or a file that can not be parsed is ignored, as if it did not exist.
+// An error is returned only if the file exists but can not be read.
+func NewFileCache(path string) (*FileCache, error) {
+	fc := &FileCache{
+		path: path,
+		uids: make(map[Namespace]map[string]int),
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fc, nil
+		}
+		return nil, err
+	}
+
+	var content fileCacheContent
+	if json.Unmarshal(raw, &content) != nil || content.Version != fileCacheVersion || content.UIDs == nil {
+		// it is just a cache, starting over is fine, the file will be overwritten on the next flush
+		fc.dirty = true
+		return fc, nil
+	}
+	fc.uids = content.UIDs
+
+	return fc, nil
+}
+
+func (fc *FileCache) CacheUID(ns Namespace, uid string, rowNum int) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	nsUIDs, ok := fc.uids[ns]
+	if !ok {
+		nsUIDs = make(map[string]int)
+		fc.uids[ns] = nsUIDs
+	}
+	if current, ok := nsUIDs[uid]; ok && current == rowNum {
+		return
+	}
+	nsUIDs[uid] = rowNum
+	fc.dirty = true
+}
+
+func (fc *FileCache) GetRowNumByUID(ns Namespace, uid string) (int, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	rowNum, ok := fc.uids[ns][uid]
+	return rowNum, ok
+}
+
+func (fc *FileCache) InvalidateUID(ns Namespace, uid string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if _, ok := fc.uids[ns][uid]; !ok {
+		return
+	}
+	delete(fc.uids[ns], uid)
+	if len(fc.uids[ns]) == 0 {
+		delete(fc.uids, ns)
+	}
+	fc.dirty = true
+}
+
+// Flush writes the content of the cache to the file if it changed since it was loaded or last flushed.
+// The file is synced and then replaced atomically, so a crash during the write never leaves a half-written cache behind.
+func (fc *FileCache) Flush() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.dirty {
+		return nil
+	}
+
+	raw, err := json.Marshal(fileCacheContent{
+		Version: fileCacheVersion,
+		UIDs:    fc.uids,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fc.path), filepath.Base(fc.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails after a successful rename, that's fine
+
+	_, err = tmp.Write(raw)
+	if err == nil {
+		err = tmp.Sync() // otherwise the rename may reach the disk before the content does
+	}
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), fc.path)
+	if err != nil {
+		return err
+	}
+
+	fc.dirty = false
+	return nil
+}
+
+// Close flushes the cache, it should be called before the process exits. The cache can still be used after it's closed.
+func (fc *FileCache) Close() error {
+	return fc.Flush()
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCache_Interfaces(t *testing.T) {
+	var _ RowUIDCache = &FileCache{}
+}
+
+func TestFileCache_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uids.json")
+
+	fc, err := NewFileCache(path)
+	assert.NoError(t, err)
+
+	_, ok := fc.GetRowNumByUID(testNs, "alma")
+	assert.False(t, ok)
+
+	fc.CacheUID(testNs, "alma", 2)
+	fc.CacheUID(testNs, "korte", 3)
+	fc.InvalidateUID(testNs, "korte")
+	fc.InvalidateUID(testNs, "does_not_exist")
+	assert.NoError(t, fc.Close())
+
+	reloaded, err := NewFileCache(path)
+	assert.NoError(t, err)
+	rowNum, ok := reloaded.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rowNum)
+	_, ok = reloaded.GetRowNumByUID(testNs, "korte")
+	assert.False(t, ok)
+	_, ok = reloaded.GetRowNumByUID("other", "alma")
+	assert.False(t, ok)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1) // no temp files left behind
+}
+
+func TestFileCache_FlushOnlyWhenDirty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uids.json")
+
+	fc, err := NewFileCache(path)
+	assert.NoError(t, err)
+	assert.NoError(t, fc.Flush())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fc.CacheUID(testNs, "alma", 2)
+	assert.NoError(t, fc.Flush())
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
+
+func TestFileCache_InvalidFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "garbage",
+			content: "not json",
+		},
+		{
+			name:    "other_version",
+			content: `{"version":999,"uids":{"doc/Sheet1/A":{"alma":2}}}`,
+		},
+		{
+			name:    "old_namespaces",
+			content: `{"version":1,"uids":{"doc/Sheet1/A":{"alma":2}}}`,
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "uids.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tc.content), 0600))
+
+			fc, err := NewFileCache(path)
+			assert.NoError(t, err)
+			_, ok := fc.GetRowNumByUID(testNs, "alma")
+			assert.False(t, ok)
+
+			// the invalid file is replaced on flush
+			assert.NoError(t, fc.Flush())
+			reloaded, err := NewFileCache(path)
+			assert.NoError(t, err)
+			assert.False(t, reloaded.dirty)
+		})
+	}
+}
+
+func TestFileCache_UnreadableFile(t *testing.T) {
+	_, err := NewFileCache(t.TempDir()) // a directory can not be read as a file
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+type lruEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time // zero if the entry never expires
+}
+
+// lru is a size bounded least recently used store with optional expiry, it is not thread safe on its own
+type lru[K comparable, V any] struct {
+	maxEntries int           // 0 means unbounded
+	ttl        time.Duration // 0 means entries never expire
+
+	order   *list.List // front is the most recently used
+	entries map[K]*list.Element
+
+	stats *Stats
+}
+
+func newLRU[K comparable, V any](maxEntries int, ttl time.Duration, stats *Stats) *lru[K, V] {
+	return &lru[K, V]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[K]*list.Element),
+		stats:      stats,
+	}
+}
+
+func (l *lru[K, V]) set(key K, value V, now time.Time) {
+	var expiresAt time.Time
+	if l.ttl > 0 {
+		expiresAt = now.Add(l.ttl)
+	}
+
+	if el, ok := l.entries[key]; ok {
+		entry := el.Value.(*lruEntry[K, V])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		l.order.MoveToFront(el)
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry[K, V]{key: key, value: value, expiresAt: expiresAt})
+
+	if l.maxEntries > 0 && l.order.Len() > l.maxEntries {
+		l.remove(l.order.Back())
+		l.stats.Evictions++
+	}
+}
+
+func (l *lru[K, V]) get(key K, now time.Time) (V, bool) {
+	el, ok := l.entries[key]
+	if !ok {
+		l.stats.Misses++
+		var zero V
+		return zero, false
+	}
+
+	entry := el.Value.(*lruEntry[K, V])
+	if !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt) {
+		l.remove(el)
+		l.stats.Expirations++
+		l.stats.Misses++
+		var zero V
+		return zero, false
+	}
+
+	l.order.MoveToFront(el)
+	l.stats.Hits++
+	return entry.value, true
+}
+
+func (l *lru[K, V]) invalidate(key K) {
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+}
+
+func (l *lru[K, V]) len() int {
+	return l.order.Len()
+}
+
+func (l *lru[K, V]) remove(el *list.Element) {
+	entry := l.order.Remove(el).(*lruEntry[K, V])
+	delete(l.entries, entry.key)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// MemoryCacheConfig configures the limits of a MemoryCache.
+// Zero values mean no limit, but as the RowCache docs say, RowTTL should really be set to something short.
+type MemoryCacheConfig struct {
+	MaxUIDs int           // maximum number of uid-rowNum pairs kept, the least recently used is evicted first
+	UIDTTL  time.Duration // how long a uid-rowNum pair is kept
+	MaxRows int           // maximum number of rows kept, the least recently used is evicted first
+	RowTTL  time.Duration // how long a row is kept
+}
+
+// Stats holds the counters of a MemoryCache, uid and row lookups are counted together
+type Stats struct {
+	Hits        uint64
+	Misses      uint64 // expired entries count as misses too
+	Evictions   uint64 // entries dropped because the cache was full
+	Expirations uint64 // entries dropped because their TTL passed
+}
+
+type uidKey struct {
+	ns  Namespace
+	uid string
+}
+
+type rowKey struct {
+	ns     Namespace
+	rowNum int
+}
+
+// MemoryCache is a bounded in-memory cache that implements both RowUIDCache and RowCache.
+// It is safe for concurrent use, so the same instance can be shared among more SheetImpl instances, the limits apply to all namespaces together.
+type MemoryCache struct {
+	mu    sync.Mutex
+	uids  *lru[uidKey, int]
+	rows  *lru[rowKey, map[string]string]
+	stats Stats
+
+	now func() time.Time // replaced in tests
+}
+
+// NewMemoryCache creates a new MemoryCache with the configured limits
+func NewMemoryCache(config MemoryCacheConfig) *MemoryCache {
+	mc := &MemoryCache{
+		now: time.Now,
+	}
+	mc.uids = newLRU[uidKey, int](config.MaxUIDs, config.UIDTTL, &mc.stats)
+	mc.rows = newLRU[rowKey, map[string]string](config.MaxRows, config.RowTTL, &mc.stats)
+	return mc
+}
+
+func (mc *MemoryCache) CacheUID(ns Namespace, uid string, rowNum int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.uids.set(uidKey{ns, uid}, rowNum, mc.now())
+}
+
+func (mc *MemoryCache) GetRowNumByUID(ns Namespace, uid string) (int, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.uids.get(uidKey{ns, uid}, mc.now())
+}
+
+func (mc *MemoryCache) InvalidateUID(ns Namespace, uid string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.uids.invalidate(uidKey{ns, uid})
+}
+
+// CacheRow stores a copy of the row, so later changes to the map by the caller do not affect the cache
+func (mc *MemoryCache) CacheRow(ns Namespace, rowNum int, rowData map[string]string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.rows.set(rowKey{ns, rowNum}, maps.Clone(rowData), mc.now())
+}
+
+// GetRow returns a copy of the cached row, the caller is free to modify it
+func (mc *MemoryCache) GetRow(ns Namespace, rowNum int) (map[string]string, bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	rowData, ok := mc.rows.get(rowKey{ns, rowNum}, mc.now())
+	if !ok {
+		return nil, false
+	}
+	return maps.Clone(rowData), true
+}
+
+func (mc *MemoryCache) InvalidateRow(ns Namespace, rowNum int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.rows.invalidate(rowKey{ns, rowNum})
+}
+
+// Stats returns a snapshot of the counters
+func (mc *MemoryCache) Stats() Stats {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.stats
+}
+
+// Len returns the number of uids and rows currently stored, including expired entries that were not looked up since they expired
+func (mc *MemoryCache) Len() (uids int, rows int) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.uids.len(), mc.rows.len()
+}
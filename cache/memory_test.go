@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMemoryCache(config MemoryCacheConfig) (*MemoryCache, *time.Time) {
+	mc := NewMemoryCache(config)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mc.now = func() time.Time {
+		return now
+	}
+	return mc, &now
+}
+
+const testNs = Namespace("doc/Sheet1/A")
+
+func TestMemoryCache_Interfaces(t *testing.T) {
+	var _ RowUIDCache = &MemoryCache{}
+	var _ RowCache = &MemoryCache{}
+}
+
+func TestMemoryCache_UID(t *testing.T) {
+	mc, _ := newTestMemoryCache(MemoryCacheConfig{})
+
+	_, ok := mc.GetRowNumByUID(testNs, "alma")
+	assert.False(t, ok)
+
+	mc.CacheUID(testNs, "alma", 2)
+	mc.CacheUID(testNs, "korte", 3)
+	rowNum, ok := mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rowNum)
+
+	mc.CacheUID(testNs, "alma", 4) // overwrite
+	rowNum, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+	assert.Equal(t, 4, rowNum)
+
+	mc.InvalidateUID(testNs, "alma")
+	mc.InvalidateUID(testNs, "does_not_exist")
+	_, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.False(t, ok)
+	_, ok = mc.GetRowNumByUID(testNs, "korte")
+	assert.True(t, ok)
+
+	assert.Equal(t, Stats{Hits: 3, Misses: 2}, mc.Stats())
+}
+
+func TestMemoryCache_Row(t *testing.T) {
+	mc, _ := newTestMemoryCache(MemoryCacheConfig{})
+
+	row := map[string]string{"A": "alma"}
+	mc.CacheRow(testNs, 2, row)
+	row["A"] = "changed" // must not affect the cache
+
+	cached, ok := mc.GetRow(testNs, 2)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"A": "alma"}, cached)
+
+	cached["A"] = "changed" // must not affect the cache either
+	cached, ok = mc.GetRow(testNs, 2)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"A": "alma"}, cached)
+
+	mc.InvalidateRow(testNs, 2)
+	cached, ok = mc.GetRow(testNs, 2)
+	assert.False(t, ok)
+	assert.Nil(t, cached)
+}
+
+func TestMemoryCache_Namespaces(t *testing.T) {
+	mc, _ := newTestMemoryCache(MemoryCacheConfig{})
+	otherSheet := NewUIDNamespace("doc", "Sheet2", "A")
+	otherRows := NewRowNamespace("doc", "Sheet1")
+
+	mc.CacheUID(testNs, "alma", 2)
+	mc.CacheUID(otherSheet, "alma", 3)
+	mc.CacheRow(testNs, 2, map[string]string{"A": "alma", "B": "1"})
+	mc.CacheRow(otherRows, 2, map[string]string{"A": "alma", "C": "2"})
+
+	rowNum, ok := mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+	assert.Equal(t, 2, rowNum)
+	rowNum, ok = mc.GetRowNumByUID(otherSheet, "alma")
+	assert.True(t, ok)
+	assert.Equal(t, 3, rowNum)
+
+	row, ok := mc.GetRow(otherRows, 2)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"A": "alma", "C": "2"}, row)
+
+	mc.InvalidateUID(otherSheet, "alma")
+	_, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+}
+
+func TestMemoryCache_LRU(t *testing.T) {
+	mc, _ := newTestMemoryCache(MemoryCacheConfig{MaxUIDs: 2, MaxRows: 1})
+
+	mc.CacheUID(testNs, "a", 1)
+	mc.CacheUID(testNs, "b", 2)
+	mc.GetRowNumByUID(testNs, "a") // a is now more recently used than b
+	mc.CacheUID(testNs, "c", 3)    // evicts b
+
+	_, ok := mc.GetRowNumByUID(testNs, "b")
+	assert.False(t, ok)
+	_, ok = mc.GetRowNumByUID(testNs, "a")
+	assert.True(t, ok)
+	_, ok = mc.GetRowNumByUID(testNs, "c")
+	assert.True(t, ok)
+
+	mc.CacheRow(testNs, 1, map[string]string{})
+	mc.CacheRow(testNs, 2, map[string]string{})
+	_, ok = mc.GetRow(testNs, 1)
+	assert.False(t, ok)
+
+	uids, rows := mc.Len()
+	assert.Equal(t, 2, uids)
+	assert.Equal(t, 1, rows)
+	assert.Equal(t, uint64(2), mc.Stats().Evictions)
+}
+
+func TestMemoryCache_TTL(t *testing.T) {
+	mc, now := newTestMemoryCache(MemoryCacheConfig{UIDTTL: time.Minute, RowTTL: time.Second})
+
+	mc.CacheUID(testNs, "alma", 2)
+	mc.CacheRow(testNs, 2, map[string]string{"A": "alma"})
+
+	*now = now.Add(time.Second)
+	_, ok := mc.GetRow(testNs, 2)
+	assert.False(t, ok)
+	_, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+
+	mc.CacheUID(testNs, "alma", 2) // refreshes the ttl
+	*now = now.Add(59 * time.Second)
+	_, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.True(t, ok)
+
+	*now = now.Add(time.Second)
+	_, ok = mc.GetRowNumByUID(testNs, "alma")
+	assert.False(t, ok)
+
+	assert.Equal(t, Stats{Hits: 2, Misses: 2, Expirations: 2}, mc.Stats())
+}
+
+func TestMemoryCache_Concurrent(t *testing.T) {
+	mc := NewMemoryCache(MemoryCacheConfig{MaxUIDs: 10, MaxRows: 10, RowTTL: time.Second})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				uid := strconv.Itoa(i*100 + j)
+				mc.CacheUID(testNs, uid, j)
+				mc.GetRowNumByUID(testNs, uid)
+				mc.CacheRow(testNs, j, map[string]string{"A": uid})
+				mc.GetRow(testNs, j)
+				mc.InvalidateRow(testNs, j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	uids, rows := mc.Len()
+	assert.Equal(t, 10, uids)
+	assert.LessOrEqual(t, rows, 10)
+}
@@ -8,19 +8,19 @@ type MockRowUIDCache struct {
 }
 
 // CacheUID mocks the CacheUID method of the RowUIDCache interface.
-func (m *MockRowUIDCache) CacheUID(uid string, rowNum int) {
-	m.Called(uid, rowNum)
+func (m *MockRowUIDCache) CacheUID(ns Namespace, uid string, rowNum int) {
+	m.Called(ns, uid, rowNum)
 }
 
 // GetRowNumByUID mocks the GetRowNumByUID method of the RowUIDCache interface.
-func (m *MockRowUIDCache) GetRowNumByUID(uid string) (int, bool) {
-	args := m.Called(uid)
+func (m *MockRowUIDCache) GetRowNumByUID(ns Namespace, uid string) (int, bool) {
+	args := m.Called(ns, uid)
 	return args.Int(0), args.Bool(1)
 }
 
 // InvalidateUID mocks the InvalidateUID method of the RowUIDCache interface.
-func (m *MockRowUIDCache) InvalidateUID(uid string) {
-	m.Called(uid)
+func (m *MockRowUIDCache) InvalidateUID(ns Namespace, uid string) {
+	m.Called(ns, uid)
 }
 
 // MockRowCache is a mock implementation of the RowCache interface.
@@ -29,17 +29,17 @@ type MockRowCache struct {
 }
 
 // CacheRow mocks the CacheRow method of the RowCache interface.
-func (m *MockRowCache) CacheRow(rowNum int, rowData map[string]string) {
-	m.Called(rowNum, rowData)
+func (m *MockRowCache) CacheRow(ns Namespace, rowNum int, rowData map[string]string) {
+	m.Called(ns, rowNum, rowData)
 }
 
 // GetRow mocks the GetRow method of the RowCache interface.
-func (m *MockRowCache) GetRow(rowNum int) (map[string]string, bool) {
-	args := m.Called(rowNum)
+func (m *MockRowCache) GetRow(ns Namespace, rowNum int) (map[string]string, bool) {
+	args := m.Called(ns, rowNum)
 	return args.Get(0).(map[string]string), args.Bool(1)
 }
 
 // InvalidateRow mocks the InvalidateRow method of the RowCache interface.
-func (m *MockRowCache) InvalidateRow(rowNum int) {
-	m.Called(rowNum)
+func (m *MockRowCache) InvalidateRow(ns Namespace, rowNum int) {
+	m.Called(ns, rowNum)
 }
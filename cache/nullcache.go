@@ -5,24 +5,24 @@ package cache
 type NullCache struct {
 }
 
-func (nc *NullCache) CacheUID(uid string, rowNum int) {
+func (nc *NullCache) CacheUID(ns Namespace, uid string, rowNum int) {
 }
 
-func (nc *NullCache) GetRowNumByUID(uid string) (int, bool) {
+func (nc *NullCache) GetRowNumByUID(ns Namespace, uid string) (int, bool) {
 	return 0, false
 }
 
-func (nc *NullCache) InvalidateUID(uid string) {
+func (nc *NullCache) InvalidateUID(ns Namespace, uid string) {
 
 }
 
-func (nc *NullCache) CacheRow(rowNum int, rowData map[string]string) {
+func (nc *NullCache) CacheRow(ns Namespace, rowNum int, rowData map[string]string) {
 }
 
-func (nc *NullCache) GetRow(rowNum int) (map[string]string, bool) {
+func (nc *NullCache) GetRow(ns Namespace, rowNum int) (map[string]string, bool) {
 	return nil, false
 }
 
-func (nc *NullCache) InvalidateRow(rowNum int) {
+func (nc *NullCache) InvalidateRow(ns Namespace, rowNum int) {
 
 }
@@ -0,0 +1,60 @@
+package sheetsorm
+
+import (
+	"github.com/pproj/sheetsorm/typemagic"
+)
+
+// FieldChange describes a single cell written by UpdateRecordsDiff
+type FieldChange struct {
+	UID    string // the uid of the record
+	Row    int    // the row number of the record
+	Field  string // the Go field path, nested fields are separated by dots
+	Column string
+	Old    string // the value of the cell before the update
+	New    string // the value written
+}
+
+// collectFieldChanges compares the dumped records with the rows they replaced, the changes are ordered by record, then by column
+func collectFieldChanges(sample interface{}, toolkit *sheetsToolkit, uids []string, rowNums []int, previousData []map[string]string, records []map[string]string) ([]FieldChange, error) {
+	schema, err := typemagic.SchemaOf(sample)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldNames := make(map[string]string, len(toolkit.cols))
+	for _, f := range schema.Fields() {
+		if _, ok := fieldNames[f.Tag.Column]; ok || f.Tag.IsReadOnly {
+			continue // read-only fields are never dumped for updates
+		}
+		fieldNames[f.Tag.Column] = f.Name
+	}
+
+	var changes []FieldChange
+	for i, r := range records {
+		changed := toolkit.diffRowData(previousData[i], r)
+		for _, col := range toolkit.cols {
+			val, ok := changed[col]
+			if !ok {
+				continue
+			}
+			changes = append(changes, FieldChange{
+				UID:    uids[i],
+				Row:    rowNums[i],
+				Field:  fieldNames[col],
+				Column: col,
+				Old:    previousData[i][col],
+				New:    val,
+			})
+		}
+	}
+	return changes, nil
+}
+
+// countChangedRows counts the distinct rows the changes are in
+func countChangedRows(changes []FieldChange) int {
+	rows := make(map[int]struct{})
+	for _, c := range changes {
+		rows[c.Row] = struct{}{}
+	}
+	return len(rows)
+}
@@ -0,0 +1,39 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrConflict = errors.New("record was modified since it was loaded")
+
+// Conflict describes a single record that was changed in the sheet by someone else
+type Conflict struct {
+	UID     string
+	Row     int
+	Columns []string          // the columns that differ from what was expected
+	Current map[string]string // the current content of the row
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("row %d (uid %q) changed in columns %s", c.Row, c.UID, strings.Join(c.Columns, ", "))
+}
+
+// ConflictError lists every record of an update that was changed by someone else, nothing is written if it is returned.
+// It matches ErrConflict with errors.Is
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (ce *ConflictError) Error() string {
+	msgs := make([]string, len(ce.Conflicts))
+	for i, c := range ce.Conflicts {
+		msgs[i] = c.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrConflict.Error(), strings.Join(msgs, "; "))
+}
+
+func (ce *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
@@ -19,3 +19,16 @@ var ErrColsInvalid = errors.New("columns are invalid")
 var ErrConfigInvalid = errors.New("structure config of sheet is invalid")
 
 var ErrOverflow = errors.New("integer/float overflow error")
+
+var ErrInvalidTag = errors.New("invalid sheet struct tag")
+var ErrUnsupportedType = errors.New("unsupported field type")
+var ErrDuplicateColumn = errors.New("multiple fields assigned to the same column")
+var ErrNoUIDField = errors.New("no suitable field for uid found")
+var ErrUnknownCodec = errors.New("codec not registered")
+var ErrConversionFailed = errors.New("value could not be converted") // conversion of a value failed for a reason other than the data being invalid
+
+var ErrLockNotAcquired = errors.New("advisory lock could not be acquired")
+var ErrLockLost = errors.New("advisory lock was lost while holding it") // someone took over the lock, because it was not renewed in time
+
+var ErrRecordExists = errors.New("record with this uid already exists in the sheet")
+var ErrRollbackFailed = errors.New("rolling back a failed batch failed, the sheet may be left partially written")
@@ -0,0 +1,36 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError wraps an error that happened while loading or dumping a single field of a record
+type FieldError struct {
+	Field  string // the Go field path, nested fields are separated by dots
+	Column string // empty if the tag of the field could not be parsed
+	Row    int    // the row number in the sheet, 0 if not known (for example when dumping)
+	Value  string // the raw cell value, only set when loading
+	Err    error
+}
+
+func (fe *FieldError) Error() string {
+	details := make([]string, 0, 3)
+	if fe.Column != "" {
+		details = append(details, "column "+fe.Column)
+	}
+	if fe.Row != 0 {
+		details = append(details, fmt.Sprintf("row %d", fe.Row))
+	}
+	if fe.Value != "" {
+		details = append(details, fmt.Sprintf("value %q", fe.Value))
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("field %s: %s", fe.Field, fe.Err.Error())
+	}
+	return fmt.Sprintf("field %s (%s): %s", fe.Field, strings.Join(details, ", "), fe.Err.Error())
+}
+
+func (fe *FieldError) Unwrap() error {
+	return fe.Err
+}
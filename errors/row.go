@@ -0,0 +1,42 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RowError wraps an error that happened while loading a single row of the sheet
+type RowError struct {
+	Row int
+	UID string
+	Err error // usually a *FieldError or a *ValidationError
+}
+
+func (re *RowError) Error() string {
+	return fmt.Sprintf("row %d (uid %q): %s", re.Row, re.UID, re.Err.Error())
+}
+
+func (re *RowError) Unwrap() error {
+	return re.Err
+}
+
+// RowErrors is a report of every row that could not be loaded, it works with errors.Is and errors.As like errors.Join does
+type RowErrors struct {
+	Errors []*RowError
+}
+
+func (re *RowErrors) Error() string {
+	msgs := make([]string, len(re.Errors))
+	for i, err := range re.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d rows invalid: %s", len(re.Errors), strings.Join(msgs, "\n"))
+}
+
+func (re *RowErrors) Unwrap() []error {
+	errs := make([]error, len(re.Errors))
+	for i, err := range re.Errors {
+		errs[i] = err
+	}
+	return errs
+}
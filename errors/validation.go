@@ -0,0 +1,38 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrValidationFailed = errors.New("one or more fields failed validation")
+
+// Violation describes a single field that did not satisfy one of the constraints defined in its struct tag
+type Violation struct {
+	Field  string // the Go field path, nested fields are separated by dots
+	Column string
+	Value  string // the cell representation of the value that was checked
+	Rule   string // the tag option that was violated, for example "min=3"
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s (column %s): value %q violates %s", v.Field, v.Column, v.Value, v.Rule)
+}
+
+// ValidationError lists every violation found in a single record, it matches ErrValidationFailed with errors.Is
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (ve *ValidationError) Error() string {
+	msgs := make([]string, len(ve.Violations))
+	for i, v := range ve.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidationFailed.Error(), strings.Join(msgs, "; "))
+}
+
+func (ve *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
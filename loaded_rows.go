@@ -0,0 +1,91 @@
+package sheetsorm
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxLoadedRows bounds the rows remembered for conflict detection, and the records kept alive by them, the least recently used ones are forgotten first
+const maxLoadedRows = 10000
+
+// loadedRowKey identifies a row as it was loaded into one specific record, by the pointer to the struct it was loaded into.
+// The key holds the pointer, so the record is kept alive while its row is remembered, and its address can not be reused by another record.
+type loadedRowKey struct {
+	typeKey string
+	uid     string
+	record  interface{} // pointer to struct
+}
+
+type loadedRowEntry struct {
+	key  loadedRowKey
+	data map[string]string
+}
+
+// loadedRows remembers the rows as they were last loaded into each record, only used with conflict detection.
+// The zero value is ready to use.
+type loadedRows struct {
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used
+	entries map[loadedRowKey]*list.Element
+}
+
+func newLoadedRowKey(toolkit *sheetsToolkit, uid string, record interface{}) loadedRowKey {
+	return loadedRowKey{typeKey: toolkit.typeKey, uid: uid, record: record}
+}
+
+func (lr *loadedRows) store(key loadedRowKey, data map[string]string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if lr.entries == nil {
+		lr.order = list.New()
+		lr.entries = make(map[loadedRowKey]*list.Element)
+	}
+
+	if el, ok := lr.entries[key]; ok {
+		el.Value.(*loadedRowEntry).data = data
+		lr.order.MoveToFront(el)
+		return
+	}
+
+	lr.entries[key] = lr.order.PushFront(&loadedRowEntry{key: key, data: data})
+	if lr.order.Len() > maxLoadedRows {
+		lr.remove(lr.order.Back())
+	}
+}
+
+func (lr *loadedRows) load(key loadedRowKey) (map[string]string, bool) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	el, ok := lr.entries[key]
+	if !ok {
+		return nil, false
+	}
+	lr.order.MoveToFront(el)
+	return el.Value.(*loadedRowEntry).data, true
+}
+
+func (lr *loadedRows) delete(key loadedRowKey) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if el, ok := lr.entries[key]; ok {
+		lr.remove(el)
+	}
+}
+
+func (lr *loadedRows) len() int {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if lr.order == nil {
+		return 0
+	}
+	return lr.order.Len()
+}
+
+func (lr *loadedRows) remove(el *list.Element) {
+	entry := lr.order.Remove(el).(*loadedRowEntry)
+	delete(lr.entries, entry.key)
+}
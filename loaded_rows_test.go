@@ -0,0 +1,60 @@
+package sheetsorm
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadedRows_Eviction(t *testing.T) {
+	var lr loadedRows
+	records := make([]testSheetRecord, maxLoadedRows+1)
+	toolkit := &sheetsToolkit{typeKey: "test"}
+
+	for i := range records[:maxLoadedRows] {
+		lr.store(newLoadedRowKey(toolkit, "uid", &records[i]), map[string]string{"A": "uid"})
+	}
+	_, ok := lr.load(newLoadedRowKey(toolkit, "uid", &records[0])) // the first one was used, so the second one is evicted instead
+	assert.True(t, ok)
+
+	lr.store(newLoadedRowKey(toolkit, "uid", &records[maxLoadedRows]), map[string]string{"A": "uid"})
+	_, ok = lr.load(newLoadedRowKey(toolkit, "uid", &records[0]))
+	assert.True(t, ok)
+	assert.Equal(t, maxLoadedRows, lr.len())
+
+	_, ok = lr.load(newLoadedRowKey(toolkit, "uid", &records[1]))
+	assert.False(t, ok, "the least recently used row should be evicted")
+
+	lr.delete(newLoadedRowKey(toolkit, "uid", &records[0]))
+	_, ok = lr.load(newLoadedRowKey(toolkit, "uid", &records[0]))
+	assert.False(t, ok)
+}
+
+func TestLoadedRows_KeepsRecordsAlive(t *testing.T) {
+	var lr loadedRows
+	toolkit := &sheetsToolkit{typeKey: "test"}
+
+	var collected atomic.Bool
+	record := &testSheetRecord{Name: "alice"}
+	runtime.SetFinalizer(record, func(*testSheetRecord) { collected.Store(true) })
+	lr.store(newLoadedRowKey(toolkit, "alice", record), map[string]string{"A": "alice"})
+	record = nil
+
+	// the entry holds the record, so its address can not be reused by another record while it is remembered
+	runtime.GC()
+	time.Sleep(10 * time.Millisecond) // finalizers run in their own goroutine
+	assert.False(t, collected.Load())
+	assert.Equal(t, 1, lr.len())
+
+	lr.mu.Lock()
+	lr.entries = nil
+	lr.order = nil
+	lr.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		runtime.GC()
+		return collected.Load()
+	}, time.Second, 10*time.Millisecond)
+}
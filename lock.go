@@ -0,0 +1,322 @@
+package sheetsorm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/pproj/sheetsorm/column"
+	e "github.com/pproj/sheetsorm/errors"
+	"go.uber.org/zap"
+	"google.golang.org/api/sheets/v4"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLockTTL           = 30 * time.Second
+	defaultLockRetryInterval = time.Second
+	defaultLockSettleDelay   = 500 * time.Millisecond
+)
+
+// AdvisoryLockConfig configures the lock used to coordinate writes between processes.
+// The lock is cooperative: only instances configured with the same cell respect it, humans editing the sheet don't.
+type AdvisoryLockConfig struct {
+	// Cell holds the lock, for example "Z1". It must be outside the range of the records, a header row is a good place for it.
+	Cell string
+	// OwnerID identifies this instance, it defaults to the hostname, the pid and a random suffix
+	OwnerID string
+	// TTL is how long the lock is valid without renewal, a lock not renewed in time can be taken over by others. Defaults to 30s.
+	// The expiry is written and checked with the local clock of each instance, there is no margin for clock skew. The lock is renewed every TTL/3,
+	// so an instance with its clock ahead of the holder's by more than two thirds of the TTL may take over a lock that is still held.
+	TTL time.Duration
+	// RetryInterval is the time waited between attempts to acquire a lock held by someone else. Defaults to 1s
+	RetryInterval time.Duration
+	// SettleDelay is the time waited after writing the lock cell before reading it back, to see if someone else wrote it at the same time. Defaults to 500ms.
+	// A negative value disables the wait, the lock is read back right away, which only detects writes that already landed by then.
+	SettleDelay time.Duration
+}
+
+// advisoryLock stores the lock in a single cell in the form of "<expiry in unix millis>|<owner>", an empty cell means the lock is free
+type advisoryLock struct {
+	aw     api.ApiWrapper
+	logger *zap.Logger
+
+	cell          string
+	cellCol       string
+	cellRow       int
+	owner         string
+	ttl           time.Duration
+	retryInterval time.Duration
+	settleDelay   time.Duration
+
+	now func() time.Time // replaced in tests
+}
+
+func newAdvisoryLock(config AdvisoryLockConfig) (*advisoryLock, error) {
+	col, row, ok := splitCell(config.Cell)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid lock cell: %q", e.ErrConfigInvalid, config.Cell)
+	}
+	if config.TTL < 0 || config.RetryInterval < 0 {
+		return nil, fmt.Errorf("%w: lock durations can not be negative", e.ErrConfigInvalid)
+	}
+
+	l := &advisoryLock{
+		cell:          config.Cell,
+		cellCol:       col,
+		cellRow:       row,
+		owner:         config.OwnerID,
+		ttl:           config.TTL,
+		retryInterval: config.RetryInterval,
+		settleDelay:   config.SettleDelay,
+		now:           time.Now,
+	}
+	if l.owner == "" {
+		l.owner = defaultLockOwner()
+	}
+	if strings.Contains(l.owner, "|") {
+		return nil, fmt.Errorf("%w: lock owner can not contain '|'", e.ErrConfigInvalid)
+	}
+	if l.ttl == 0 {
+		l.ttl = defaultLockTTL
+	}
+	if l.retryInterval == 0 {
+		l.retryInterval = defaultLockRetryInterval
+	}
+	if config.SettleDelay == 0 {
+		l.settleDelay = defaultLockSettleDelay
+	} else if config.SettleDelay < 0 {
+		l.settleDelay = 0 // disabled
+	}
+	return l, nil
+}
+
+// splitCell splits a cell reference like "Z1" to its column and row
+func splitCell(cell string) (string, int, bool) {
+	i := strings.IndexFunc(cell, func(r rune) bool {
+		return r >= '0' && r <= '9'
+	})
+	if i <= 0 {
+		return "", 0, false
+	}
+	col := cell[:i]
+	row, err := strconv.Atoi(cell[i:])
+	if err != nil || row < 1 || !column.IsValidCol(col) {
+		return "", 0, false
+	}
+	return col, row, true
+}
+
+func defaultLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// checkOutside returns an error if the lock cell is in the range of the records handled by the toolkit
+func (l *advisoryLock) checkOutside(toolkit *sheetsToolkit) error {
+	if l.cellRow > toolkit.skipRows && column.ColIndex(l.cellCol) >= column.ColIndex(toolkit.firstCol) && column.ColIndex(l.cellCol) <= column.ColIndex(toolkit.lastCol) {
+		return fmt.Errorf("%w: lock cell %s is in the range of the records", e.ErrConfigInvalid, l.cell)
+	}
+	return nil
+}
+
+func (l *advisoryLock) read(ctx context.Context) (string, error) {
+	vals, err := l.aw.GetRange(ctx, l.cell)
+	if err != nil {
+		return "", err
+	}
+	if len(vals.Values) == 0 || len(vals.Values[0]) == 0 {
+		return "", nil
+	}
+	return vals.Values[0][0].(string), nil
+}
+
+func (l *advisoryLock) write(ctx context.Context, val string) error {
+	_, err := l.aw.BatchUpdate(ctx, []*sheets.ValueRange{{
+		MajorDimension: "ROWS",
+		Range:          l.cell,
+		Values:         [][]interface{}{{val}},
+	}})
+	return err
+}
+
+// parseLockVal returns the owner and the expiry of the lock, a malformed value is treated as a free lock
+func parseLockVal(val string) (string, time.Time) {
+	expiry, owner, ok := strings.Cut(val, "|")
+	if !ok {
+		return "", time.Time{}
+	}
+	millis, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return owner, time.UnixMilli(millis)
+}
+
+func (l *advisoryLock) newLockVal() string {
+	return fmt.Sprintf("%d|%s", l.now().Add(l.ttl).UnixMilli(), l.owner)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryAcquire writes the lock if it is free, expired or already ours, then reads it back to see if someone else did the same
+func (l *advisoryLock) tryAcquire(ctx context.Context) (bool, error) {
+	current, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	owner, expiry := parseLockVal(current)
+	if owner != "" && owner != l.owner && l.now().Before(expiry) {
+		l.logger.Debug("Lock is held by someone else", zap.String("owner", owner), zap.Time("expiry", expiry))
+		return false, nil
+	}
+	if owner != "" && owner != l.owner {
+		l.logger.Info("Taking over expired lock", zap.String("owner", owner), zap.Time("expiry", expiry))
+	}
+
+	return l.writeAndCheck(ctx, l.newLockVal())
+}
+
+// writeAndCheck writes the lock, waits for the settle delay, then reads it back. It returns false if someone else wrote the lock in the meantime.
+func (l *advisoryLock) writeAndCheck(ctx context.Context, val string) (bool, error) {
+	err := l.write(ctx, val)
+	if err != nil {
+		return false, err
+	}
+
+	err = sleepCtx(ctx, l.settleDelay)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return current == val, nil
+}
+
+// acquire waits until the lock is acquired or the context is done
+func (l *advisoryLock) acquire(ctx context.Context) error {
+	for {
+		ok, err := l.tryAcquire(ctx)
+		if err != nil {
+			return errors.Join(e.ErrLockNotAcquired, err)
+		}
+		if ok {
+			l.logger.Debug("Lock acquired", zap.String("owner", l.owner))
+			return nil
+		}
+
+		err = sleepCtx(ctx, l.retryInterval)
+		if err != nil {
+			return errors.Join(e.ErrLockNotAcquired, err)
+		}
+	}
+}
+
+// renew extends the lock, it returns ErrLockLost if someone else holds it now
+func (l *advisoryLock) renew(ctx context.Context) error {
+	current, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	owner, _ := parseLockVal(current)
+	if owner != l.owner {
+		return e.ErrLockLost
+	}
+	// someone may take the lock over between the read and the write, just like when acquiring it
+	ok, err := l.writeAndCheck(ctx, l.newLockVal())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return e.ErrLockLost
+	}
+	return nil
+}
+
+// release frees the lock, if it's still ours
+func (l *advisoryLock) release(ctx context.Context) error {
+	current, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	owner, _ := parseLockVal(current)
+	if owner != l.owner {
+		return nil // someone took it over already
+	}
+	return l.write(ctx, "")
+}
+
+// hold acquires the lock, and keeps renewing it until the returned function is called, which releases the lock.
+// The returned context is cancelled with ErrLockLost as the cause, if the lock is lost in the meantime.
+func (l *advisoryLock) hold(ctx context.Context) (context.Context, func(), error) {
+	err := l.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	heldCtx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-heldCtx.Done():
+				return
+			case <-ticker.C:
+				renewErr := l.renew(heldCtx)
+				if errors.Is(renewErr, e.ErrLockLost) {
+					l.logger.Error("Lock lost while holding it")
+					cancel(e.ErrLockLost)
+					return
+				}
+				if renewErr != nil {
+					l.logger.Warn("Failed to renew lock", zap.Error(renewErr)) // it will be retried on the next tick, before the lock expires
+				}
+			}
+		}
+	}()
+
+	stop := func() {
+		close(done)
+		wg.Wait()
+		cancel(nil)
+		releaseErr := l.release(context.WithoutCancel(ctx))
+		if releaseErr != nil {
+			// it will expire anyway
+			l.logger.Warn("Failed to release lock", zap.Error(releaseErr))
+		}
+	}
+	return heldCtx, stop, nil
+}
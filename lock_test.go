@@ -0,0 +1,252 @@
+package sheetsorm
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/api"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/sheets/v4"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLockCellApi keeps the lock cell in memory, and passes everything else to the mock
+type testLockCellApi struct {
+	*api.MockApiWrapper
+	cell string
+
+	mu      sync.Mutex
+	val     string
+	onWrite func(val string) string // can replace the value written, to simulate a concurrent writer
+}
+
+func (a *testLockCellApi) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	if range_ != a.cell {
+		return a.MockApiWrapper.GetRange(ctx, range_)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.val == "" {
+		return &sheets.ValueRange{}, nil // google omits empty cells
+	}
+	return &sheets.ValueRange{Values: [][]interface{}{{a.val}}}, nil
+}
+
+func (a *testLockCellApi) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	if len(values) != 1 || values[0].Range != a.cell {
+		return a.MockApiWrapper.BatchUpdate(ctx, values)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.val = values[0].Values[0][0].(string)
+	if a.onWrite != nil {
+		a.val = a.onWrite(a.val)
+	}
+	return &sheets.BatchUpdateValuesResponse{}, nil
+}
+
+func (a *testLockCellApi) get() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.val
+}
+
+func (a *testLockCellApi) set(val string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.val = val
+}
+
+func newTestLock(t *testing.T, owner string, ttl time.Duration) (*advisoryLock, *testLockCellApi) {
+	aw := &testLockCellApi{MockApiWrapper: &api.MockApiWrapper{}, cell: "Z1"}
+	l, err := newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", OwnerID: owner, TTL: ttl, RetryInterval: time.Millisecond})
+	assert.NoError(t, err)
+	l.aw = aw
+	l.logger = zaptest.NewLogger(t)
+	l.settleDelay = 0
+	return l, aw
+}
+
+func TestSplitCell(t *testing.T) {
+	testCases := []struct {
+		cell        string
+		expectedCol string
+		expectedRow int
+		expectedOk  bool
+	}{
+		{cell: "Z1", expectedCol: "Z", expectedRow: 1, expectedOk: true},
+		{cell: "AB12", expectedCol: "AB", expectedRow: 12, expectedOk: true},
+		{cell: "Z", expectedOk: false},
+		{cell: "1", expectedOk: false},
+		{cell: "Z0", expectedOk: false},
+		{cell: "Z1A", expectedOk: false},
+		{cell: "", expectedOk: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.cell, func(t *testing.T) {
+			col, row, ok := splitCell(tc.cell)
+			assert.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				assert.Equal(t, tc.expectedCol, col)
+				assert.Equal(t, tc.expectedRow, row)
+			}
+		})
+	}
+}
+
+func TestNewAdvisoryLock(t *testing.T) {
+	l, err := newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, l.owner)
+	assert.Equal(t, defaultLockTTL, l.ttl)
+	assert.Equal(t, defaultLockRetryInterval, l.retryInterval)
+	assert.Equal(t, defaultLockSettleDelay, l.settleDelay)
+
+	_, err = newAdvisoryLock(AdvisoryLockConfig{Cell: "invalid"})
+	assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	_, err = newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", OwnerID: "a|b"})
+	assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	_, err = newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", TTL: -time.Second})
+	assert.ErrorIs(t, err, e.ErrConfigInvalid)
+
+	l, err = newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", SettleDelay: -1})
+	assert.NoError(t, err)
+	assert.Zero(t, l.settleDelay) // disabled
+}
+
+func TestAdvisoryLock_AcquireRelease(t *testing.T) {
+	l, aw := newTestLock(t, "me", time.Minute)
+	ctx := context.Background()
+
+	assert.NoError(t, l.acquire(ctx))
+	owner, expiry := parseLockVal(aw.get())
+	assert.Equal(t, "me", owner)
+	assert.True(t, expiry.After(time.Now()))
+
+	assert.NoError(t, l.acquire(ctx)) // re-entrant for the same owner
+
+	assert.NoError(t, l.release(ctx))
+	assert.Equal(t, "", aw.get())
+}
+
+func TestAdvisoryLock_HeldByOther(t *testing.T) {
+	l, aw := newTestLock(t, "me", time.Minute)
+	otherVal := "9999999999999|other"
+	aw.set(otherVal)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := l.acquire(ctx)
+	assert.ErrorIs(t, err, e.ErrLockNotAcquired)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, otherVal, aw.get())
+
+	// release does not touch the lock of others
+	assert.NoError(t, l.release(context.Background()))
+	assert.Equal(t, otherVal, aw.get())
+}
+
+func TestAdvisoryLock_TakeOverExpired(t *testing.T) {
+	l, aw := newTestLock(t, "me", time.Minute)
+	aw.set("1000|other") // expired a long time ago
+
+	assert.NoError(t, l.acquire(context.Background()))
+	owner, _ := parseLockVal(aw.get())
+	assert.Equal(t, "me", owner)
+}
+
+func TestAdvisoryLock_LostRace(t *testing.T) {
+	l, aw := newTestLock(t, "me", time.Minute)
+	otherVal := "9999999999999|other"
+	aw.onWrite = func(string) string {
+		return otherVal // the other writer always wins
+	}
+
+	ok, err := l.tryAcquire(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestAdvisoryLock_RenewLostRace(t *testing.T) {
+	l, aw := newTestLock(t, "me", time.Minute)
+	ctx := context.Background()
+	assert.NoError(t, l.acquire(ctx))
+
+	otherVal := "9999999999999|other"
+	aw.onWrite = func(string) string {
+		return otherVal // the other writer takes the lock over while it is being renewed
+	}
+
+	assert.ErrorIs(t, l.renew(ctx), e.ErrLockLost)
+	assert.Equal(t, otherVal, aw.get())
+}
+
+func TestAdvisoryLock_Hold(t *testing.T) {
+	t.Run("renewed_and_released", func(t *testing.T) {
+		l, aw := newTestLock(t, "me", 30*time.Millisecond)
+
+		heldCtx, release, err := l.hold(context.Background())
+		assert.NoError(t, err)
+		_, firstExpiry := parseLockVal(aw.get())
+
+		time.Sleep(50 * time.Millisecond) // longer than the ttl, so it must have been renewed
+		assert.NoError(t, heldCtx.Err())
+		owner, expiry := parseLockVal(aw.get())
+		assert.Equal(t, "me", owner)
+		assert.True(t, expiry.After(firstExpiry))
+
+		release()
+		assert.Equal(t, "", aw.get())
+	})
+
+	t.Run("lost", func(t *testing.T) {
+		l, aw := newTestLock(t, "me", 30*time.Millisecond)
+
+		heldCtx, release, err := l.hold(context.Background())
+		assert.NoError(t, err)
+		aw.set("9999999999999|other")
+
+		select {
+		case <-heldCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context was not cancelled")
+		}
+		assert.ErrorIs(t, context.Cause(heldCtx), e.ErrLockLost)
+
+		release()
+		assert.Equal(t, "9999999999999|other", aw.get()) // not ours anymore
+	})
+}
+
+func TestSheet_UpdateRecordsWithLock(t *testing.T) {
+	aw := &testLockCellApi{MockApiWrapper: &api.MockApiWrapper{}, cell: "Z1"}
+	aw.On("GetRange", mock.Anything, "A2:A").Run(func(_ mock.Arguments) {
+		owner, _ := parseLockVal(aw.get())
+		assert.Equal(t, "me", owner) // the lock is held while the update runs
+	}).Return(&sheets.ValueRange{Values: [][]interface{}{{"alice"}}}, nil).Once()
+	aw.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+	aw.On("BatchGetRanges", mock.Anything, []string{"A2:B2"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "23"}}}},
+	}, nil).Once()
+
+	si := newTestSheet(t, aw, 1)
+	l, err := newAdvisoryLock(AdvisoryLockConfig{Cell: "Z1", OwnerID: "me"})
+	assert.NoError(t, err)
+	l.aw = aw
+	l.logger = si.logger
+	l.settleDelay = 0
+	si.lock = l
+
+	assert.NoError(t, si.UpdateRecords(context.Background(), &testSheetRecord{Name: "alice", Age: 23}))
+	assert.Equal(t, "", aw.get()) // released
+	aw.AssertExpectations(t)
+
+	t.Run("lock_cell_in_records", func(t *testing.T) {
+		l.cell, l.cellCol, l.cellRow = "B5", "B", 5
+		err := si.UpdateRecords(context.Background(), &testSheetRecord{Name: "alice", Age: 23})
+		assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	})
+}
@@ -0,0 +1,92 @@
+// Package logging adapts the zap logger used throughout sheetsorm to other logging libraries.
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewSlogLogger returns a zap logger that writes every entry to the slog handler, so sheetsorm logs through whatever the application uses.
+// The structured fields are kept as they are, errors are passed as error values.
+func NewSlogLogger(h slog.Handler) *zap.Logger {
+	return zap.New(&slogCore{handler: h})
+}
+
+// slogCore is a zapcore.Core that forwards entries to a slog.Handler
+type slogCore struct {
+	handler slog.Handler
+}
+
+func (c *slogCore) Enabled(level zapcore.Level) bool {
+	return c.handler.Enabled(context.Background(), slogLevel(level))
+}
+
+func (c *slogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &slogCore{handler: c.handler.WithAttrs(fieldsToAttrs(fields))}
+}
+
+func (c *slogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *slogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	var pc uintptr
+	if ent.Caller.Defined {
+		pc = ent.Caller.PC
+	}
+	rec := slog.NewRecord(ent.Time, slogLevel(ent.Level), ent.Message, pc)
+	if ent.LoggerName != "" {
+		rec.AddAttrs(slog.String("logger", ent.LoggerName))
+	}
+	rec.AddAttrs(fieldsToAttrs(fields)...)
+	return c.handler.Handle(context.Background(), rec)
+}
+
+func (c *slogCore) Sync() error {
+	return nil
+}
+
+// slogLevel maps zap levels to slog levels, the levels above error are mapped above slog.LevelError
+func slogLevel(level zapcore.Level) slog.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return slog.LevelDebug
+	case level == zapcore.InfoLevel:
+		return slog.LevelInfo
+	case level == zapcore.WarnLevel:
+		return slog.LevelWarn
+	case level == zapcore.ErrorLevel:
+		return slog.LevelError
+	default: // DPanic, Panic and Fatal
+		return slog.LevelError + slog.Level(level-zapcore.ErrorLevel)
+	}
+}
+
+// fieldsToAttrs converts zap fields to slog attributes, keeping their order
+func fieldsToAttrs(fields []zapcore.Field) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		switch f.Type {
+		case zapcore.SkipType:
+			continue
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				attrs = append(attrs, slog.Any(f.Key, err))
+				continue
+			}
+		}
+
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		for k, v := range enc.Fields {
+			attrs = append(attrs, slog.Any(k, v))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &m))
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestNewSlogLogger(t *testing.T) {
+	testCases := []struct {
+		name     string
+		level    slog.Level
+		log      func(l *zap.Logger)
+		expected []map[string]interface{}
+	}{
+		{
+			name:  "fields",
+			level: slog.LevelDebug,
+			log: func(l *zap.Logger) {
+				l.Debug("Getting range", zap.String("range", "A2:B2"), zap.Int("rowNum", 2), zap.Int("tryCount", 1))
+			},
+			expected: []map[string]interface{}{
+				{"level": "DEBUG", "msg": "Getting range", "range": "A2:B2", "rowNum": float64(2), "tryCount": float64(1)},
+			},
+		},
+		{
+			name:  "levels filtered",
+			level: slog.LevelWarn,
+			log: func(l *zap.Logger) {
+				l.Debug("debug")
+				l.Info("info")
+				l.Warn("warn")
+				l.Error("error")
+			},
+			expected: []map[string]interface{}{
+				{"level": "WARN", "msg": "warn"},
+				{"level": "ERROR", "msg": "error"},
+			},
+		},
+		{
+			name:  "with",
+			level: slog.LevelInfo,
+			log: func(l *zap.Logger) {
+				l.With(zap.String("uid", "alice")).Info("Updated record", zap.Int("rowNum", 3))
+			},
+			expected: []map[string]interface{}{
+				{"level": "INFO", "msg": "Updated record", "uid": "alice", "rowNum": float64(3)},
+			},
+		},
+		{
+			name:  "error",
+			level: slog.LevelInfo,
+			log: func(l *zap.Logger) {
+				l.Error("Failed", zap.Error(errors.New("boom")))
+			},
+			expected: []map[string]interface{}{
+				{"level": "ERROR", "msg": "Failed", "error": "boom"},
+			},
+		},
+		{
+			name:  "named",
+			level: slog.LevelInfo,
+			log: func(l *zap.Logger) {
+				l.Named("api").Info("hello", zap.Strings("ranges", []string{"A1", "B1"}))
+			},
+			expected: []map[string]interface{}{
+				{"level": "INFO", "msg": "hello", "logger": "api", "ranges": []interface{}{"A1", "B1"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+				Level: tc.level,
+				ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey && len(groups) == 0 {
+						return slog.Attr{}
+					}
+					return a
+				},
+			})
+
+			tc.log(NewSlogLogger(h))
+
+			assert.Equal(t, tc.expected, decodeLines(t, &buf))
+		})
+	}
+}
+
+func TestSlogLevel(t *testing.T) {
+	testCases := []struct {
+		level    zapcore.Level
+		expected slog.Level
+	}{
+		{zapcore.DebugLevel, slog.LevelDebug},
+		{zapcore.InfoLevel, slog.LevelInfo},
+		{zapcore.WarnLevel, slog.LevelWarn},
+		{zapcore.ErrorLevel, slog.LevelError},
+		{zapcore.DPanicLevel, slog.LevelError + 1},
+		{zapcore.FatalLevel, slog.LevelError + 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.level.String(), func(t *testing.T) {
+			assert.Equal(t, tc.expected, slogLevel(tc.level))
+		})
+	}
+}
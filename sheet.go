@@ -7,12 +7,19 @@ import (
 	"github.com/pproj/sheetsorm/api"
 	"github.com/pproj/sheetsorm/cache"
 	e "github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/logging"
 	"github.com/pproj/sheetsorm/typemagic"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/api/sheets/v4"
+	"log/slog"
 	"reflect"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Sheet interface {
@@ -26,21 +33,137 @@ type Sheet interface {
 	UpdateRecords(ctx context.Context, records ...interface{}) error
 }
 
+// The interfaces below are optional extensions of Sheet, so implementations of Sheet outside this package do not break when a feature is added.
+// SheetImpl implements all of them, use a type assertion to check for them on other implementations.
+
+// TolerantSheet can load all records, even if some rows can not be loaded
+type TolerantSheet interface {
+	// GetAllRecordsTolerant is the same as GetAllRecords, but rows that could not be loaded do not abort the whole call.
+	// Every row that could be loaded is stored in out, and the rest is reported in a *errors.RowErrors.
+	// Errors that are not related to a single row (for example API errors) are returned as-is, and out is left untouched.
+	GetAllRecordsTolerant(ctx context.Context, out interface{}) error
+}
+
+// DiffSheet can write only the cells of records that differ from the sheet
+type DiffSheet interface {
+	// UpdateRecordsDiff is the same as UpdateRecords, but it compares the records with the current rows first, and writes only the cells that differ.
+	// Cells are compared the way the fields would dump them, so "1.50" does not differ from 1.5, and the version of a record is only bumped if anything else changed.
+	// It returns the changes written, in the order of the records and columns.
+	UpdateRecordsDiff(ctx context.Context, records ...interface{}) ([]FieldChange, error)
+}
+
+// SnapshotSheet serves reads from a snapshot of the sheet
+type SnapshotSheet interface {
+	// Refresh reloads the snapshot if snapshot mode is enabled, otherwise it does nothing
+	Refresh(ctx context.Context) error
+
+	// SnapshotTakenAt returns the time the snapshot was loaded from the sheet, the second return value is false if there is no snapshot yet
+	SnapshotTakenAt() (time.Time, bool)
+}
+
+// BatchSheet can write creates, updates and deletes together
+type BatchSheet interface {
+	// Batch returns a new, empty batch, that collects creates, updates and deletes to be validated and written together by its Commit
+	Batch() *Batch
+}
+
+var (
+	_ Sheet         = (*SheetImpl)(nil)
+	_ TolerantSheet = (*SheetImpl)(nil)
+	_ DiffSheet     = (*SheetImpl)(nil)
+	_ SnapshotSheet = (*SheetImpl)(nil)
+	_ BatchSheet    = (*SheetImpl)(nil)
+)
+
 type SheetImpl struct {
 	mu *sync.RWMutex
 	aw api.ApiWrapper
 
+	docID string // only used to namespace cache entries
+	sheet string
+
 	logger   *zap.Logger
 	skipRows int
 
+	emptyCellPolicy typemagic.EmptyCellPolicy
+	validateOnLoad  bool
+
 	uidCache cache.RowUIDCache
 	rowCache cache.RowCache
+
+	snapshot *snapshot // nil if snapshot mode is not enabled
+
+	conflictDetection bool
+	loadedRows        loadedRows // the rows as they were last loaded into each record, only used with conflict detection
+
+	lockConfig *AdvisoryLockConfig
+	lock       *advisoryLock // nil if no advisory lock is configured
+
+	retryPolicy     api.RetryPolicy
+	retryPolicySet  bool // only the api wrapper created by NewSheet uses the retry policy
+	rateLimiter     *api.RateLimiter
+	coalescerConfig *api.CoalescerConfig // nil if reads are not coalesced
+	middlewares     []api.Middleware
+	dryRun          *api.DryRunApiWrapper // nil if writes are executed
+	dryRunEnabled   bool
+
+	tracerProvider   trace.TracerProvider
+	meterProvider    metric.MeterProvider
+	telemetryEnabled bool
+	telemetry        *sheetTelemetry // nil if telemetry is not enabled
 }
 
 type SheetInitializationOption func(*SheetImpl)
 
 func NewSheet(srv *sheets.Service, st StructureConfig, opts ...SheetInitializationOption) (*SheetImpl, error) {
+	si, err := newSheetImpl(st, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	aw := api.NewApiWrapperWithRetryPolicy(srv, st.DocID, st.Sheet, si.logger, si.retryPolicy)
+	if si.rateLimiter != nil {
+		aw.SetRateLimiter(si.rateLimiter)
+	}
+	if si.telemetry != nil {
+		aw.SetTelemetry(si.telemetry.api)
+	}
 
+	err = si.setApiWrapper(aw)
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// NewSheetWithWrapper is the same as NewSheet, but the sheet uses the passed ApiWrapper, for example a fake, a decorated wrapper, or another backend.
+// The DocID of the config is still required, it namespaces the cache entries. The wrapper is expected to retry, trace and measure calls on its own,
+// so WithRetryPolicy and WithTelemetry can not be used with it, it returns errors.ErrConfigInvalid if they are passed. WithRateLimiter throttles the calls made to the wrapper.
+func NewSheetWithWrapper(aw api.ApiWrapper, st StructureConfig, opts ...SheetInitializationOption) (*SheetImpl, error) {
+	si, err := newSheetImpl(st, opts)
+	if err != nil {
+		return nil, err
+	}
+	if si.retryPolicySet {
+		return nil, fmt.Errorf("%w: WithRetryPolicy can not be used with a custom api wrapper", e.ErrConfigInvalid)
+	}
+	if si.telemetryEnabled {
+		return nil, fmt.Errorf("%w: WithTelemetry can not be used with a custom api wrapper", e.ErrConfigInvalid)
+	}
+
+	if si.rateLimiter != nil {
+		aw = api.RateLimitingMiddleware(si.rateLimiter)(aw)
+	}
+
+	err = si.setApiWrapper(aw)
+	if err != nil {
+		return nil, err
+	}
+	return si, nil
+}
+
+// newSheetImpl validates the config, and applies the options, except for the ones configuring the api wrapper
+func newSheetImpl(st StructureConfig, opts []SheetInitializationOption) (*SheetImpl, error) {
 	err := st.Validate()
 	if err != nil {
 		return nil, err
@@ -52,21 +175,57 @@ func NewSheet(srv *sheets.Service, st StructureConfig, opts ...SheetInitializati
 	si := &SheetImpl{
 		mu:       &sync.RWMutex{},
 		aw:       nil, // will be initialized after applying options, because they configure the logger as well
+		docID:    st.DocID,
+		sheet:    st.Sheet,
 		logger:   nl,
 		skipRows: st.SkipRows,
 		uidCache: nc,
 		rowCache: nc,
+
+		emptyCellPolicy: st.EmptyCells,
+		retryPolicy:     api.DefaultRetryPolicy(),
 	}
 
 	for _, o := range opts {
 		o(si)
 	}
 
-	si.aw = api.NewApiWrapper(srv, st.DocID, st.Sheet, si.logger)
+	if si.telemetryEnabled {
+		si.telemetry, err = newSheetTelemetry(si.tracerProvider, si.meterProvider, st.DocID, st.Sheet)
+		if err != nil {
+			return nil, err
+		}
+		si.uidCache = &instrumentedUIDCache{RowUIDCache: si.uidCache, telemetry: si.telemetry}
+		si.rowCache = &instrumentedRowCache{RowCache: si.rowCache, telemetry: si.telemetry}
+	}
 
 	return si, nil
 }
 
+// setApiWrapper wraps aw with the middlewares and the coalescer, and sets up everything that uses it
+func (si *SheetImpl) setApiWrapper(aw api.ApiWrapper) error {
+	si.aw = api.Chain(aw, si.middlewares...)
+	if si.coalescerConfig != nil {
+		si.aw = api.NewCoalescer(si.aw, si.logger, *si.coalescerConfig)
+	}
+	if si.dryRunEnabled {
+		si.dryRun = api.NewDryRunApiWrapper(si.aw, si.logger)
+		si.aw = si.dryRun
+	}
+
+	if si.lockConfig != nil && si.dryRun == nil { // nothing is written in a dry run, so there is nothing to lock
+		var err error
+		si.lock, err = newAdvisoryLock(*si.lockConfig)
+		if err != nil {
+			return err
+		}
+		si.lock.aw = si.aw
+		si.lock.logger = si.logger
+	}
+
+	return nil
+}
+
 func WithRowUIDCache(c cache.RowUIDCache) SheetInitializationOption {
 	return func(si *SheetImpl) {
 		si.uidCache = c
@@ -85,6 +244,106 @@ func WithLogger(l *zap.Logger) SheetInitializationOption {
 	}
 }
 
+// WithSlogHandler makes the sheet, and the api wrapper it creates, log to a slog.Handler instead of a zap logger, with the same fields.
+func WithSlogHandler(h slog.Handler) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.logger = logging.NewSlogLogger(h)
+	}
+}
+
+// WithLoadValidation enables checking the constraints defined in the struct tags for records loaded from the sheet as well.
+// By default, constraints are only enforced before writing to the sheet.
+func WithLoadValidation() SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.validateOnLoad = true
+	}
+}
+
+// WithSnapshot enables snapshot mode: the entire sheet is loaded into memory once, and reads are served from there without any API calls.
+// The snapshot is refreshed on the first read after refreshInterval passed, so reads are never older than that. With 0 as interval it is only refreshed by Refresh.
+// Writes still go to the API, and the data read back after them is stored in the snapshot. Reads do not use the caches in snapshot mode.
+func WithSnapshot(refreshInterval time.Duration) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.snapshot = newSnapshot(refreshInterval)
+	}
+}
+
+// WithConflictDetection makes updates check that the rows did not change since the records were loaded by this instance, before writing them.
+// If they did, nothing is written and an *errors.ConflictError is returned.
+// The check is tied to the struct the row was loaded into: copies of it, records that were not loaded before,
+// and records whose row was forgotten since (only the most recently used rows are remembered) are written unchecked.
+// Slices filled by GetAllRecords are tracked by pointers to their elements, so they are unchecked after being reallocated too.
+// Remembered rows keep their records alive until they are forgotten. Unchecked writes are logged at debug level.
+// Only a version field protects every write of a record type, those are always checked by their version instead, regardless of this option.
+func WithConflictDetection() SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.conflictDetection = true
+	}
+}
+
+// WithAdvisoryLock makes every update acquire a lock stored in a cell of the sheet, so instances in different processes do not write at the same time.
+// The lock is renewed while the update runs, and a lock that was not renewed in time is taken over by others.
+func WithAdvisoryLock(config AdvisoryLockConfig) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.lockConfig = &config
+	}
+}
+
+// WithRetryPolicy replaces the default retry policy of API calls, for example with a shorter one in HTTP handlers, or a longer one in batch jobs.
+// It can only be used with NewSheet.
+func WithRetryPolicy(p api.RetryPolicy) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.retryPolicy = p
+		si.retryPolicySet = true
+	}
+}
+
+// WithRateLimiter throttles the API calls of the sheet with the limiter. Pass the same limiter to every sheet using the same project to share the quota.
+func WithRateLimiter(l *api.RateLimiter) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.rateLimiter = l
+	}
+}
+
+// WithCoalescing merges reads issued at the same time, for example by concurrent GetRecord calls, into a single API call
+func WithCoalescing(config api.CoalescerConfig) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.coalescerConfig = &config
+	}
+}
+
+// WithMiddleware decorates the api wrapper of the sheet, the first middleware being the outermost.
+// They are applied directly around the wrapper, so they see the calls as they are sent, after coalescing.
+func WithMiddleware(middlewares ...api.Middleware) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.middlewares = append(si.middlewares, middlewares...)
+	}
+}
+
+// WithDryRun makes the sheet skip every write, the planned writes are logged, and can be retrieved by DryRun along with the current values of the cells.
+// Reads still go to the sheet, but nothing is read back after the planned writes: the records passed to updates and batches are left as they are,
+// and neither the caches nor the rows remembered for conflict detection are updated. The advisory lock is not taken.
+func WithDryRun() SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.dryRunEnabled = true
+	}
+}
+
+// WithTelemetry traces every Sheet method and API call, and records metrics of API calls, retries, waits for the rate limiter, cache lookups and rows read or written.
+// Either provider can be nil to use only the other one. Without this option nothing is traced nor measured. It can only be used with NewSheet.
+func WithTelemetry(tp trace.TracerProvider, mp metric.MeterProvider) SheetInitializationOption {
+	return func(si *SheetImpl) {
+		si.tracerProvider = tp
+		si.meterProvider = mp
+		si.telemetryEnabled = true
+	}
+}
+
+// DryRun returns the wrapper collecting the planned writes if the sheet was created WithDryRun, nil otherwise
+func (si *SheetImpl) DryRun() *api.DryRunApiWrapper {
+	return si.dryRun
+}
+
 // typeAssert asserts that the presented val is a type of expected kind.
 // by presenting multiple expectedKinds, it is possible to check if the type "wraps" an expected type
 // for example: `typeAssert(a, reflect.Ptr, reflect.Slice, reflect.Struct)` asserts that `a` is a pointer pointing to a slice of structs
@@ -101,15 +360,109 @@ func typeAssert(val interface{}, expectedKinds ...reflect.Kind) bool {
 	return true
 }
 
+// unwrapRecords flattens individual records and lists of records into a single list of pointers to structs
+func unwrapRecords(records []interface{}) ([]interface{}, error) {
+	unwrappedRecords := make([]interface{}, 0) // <- will store just pointers to structs
+
+	for _, r := range records {
+		if typeAssert(r, reflect.Ptr, reflect.Struct) {
+			unwrappedRecords = append(unwrappedRecords, r)
+			continue
+		}
+		if typeAssert(r, reflect.Slice, reflect.Ptr, reflect.Struct) {
+			val := reflect.ValueOf(r)
+			for i := 0; i < val.Len(); i++ {
+				unwrappedRecords = append(unwrappedRecords, val.Index(i).Interface())
+			}
+			continue
+		}
+
+		return nil, errors.Join(e.ErrInvalidType, fmt.Errorf("expected pointer to struct or a slice of pointers to structs"))
+	}
+	return unwrappedRecords, nil
+}
+
 // getToolkit instantiates a new toolkit that is configured for the presented sample
 func (si *SheetImpl) getToolkit(sample interface{}) (*sheetsToolkit, error) {
-	cols := typemagic.DumpCols(sample)
-	uidCol := typemagic.DumpUIDCol(sample)
+	schema, err := typemagic.SchemaOf(sample)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := schema.Cols()
+	if err != nil {
+		return nil, err
+	}
+	uidCol, err := schema.UIDCol()
+	if err != nil {
+		return nil, err
+	}
 
-	return newToolkit(si.aw, cols, uidCol, si.skipRows, si.logger, si.uidCache, si.rowCache)
+	uidNs := cache.NewUIDNamespace(si.docID, si.sheet, uidCol)
+	rowNs := cache.NewRowNamespace(si.docID, si.sheet)
+	toolkit, err := newToolkit(si.aw, cols, uidCol, si.skipRows, si.logger, uidNs, rowNs, si.uidCache, si.rowCache)
+	if err != nil {
+		return nil, err
+	}
+	toolkit.typeKey = uidCol + "/" + strings.Join(cols, ",")
+
+	if versionField, ok := schema.VersionField(); ok {
+		toolkit.versionCol = versionField.Tag.Column
+	}
+	toolkit.normalizeCell = schema.NormalizeCell
+	toolkit.dryRun = si.dryRun != nil
+	return toolkit, nil
+}
+
+// loadRecord loads the data into the record, and validates it if load validation is enabled.
+// The rowNum is the row the data was read from, it is added to the returned *errors.FieldError
+// If conflict detection is enabled, the data is remembered for the record, so later updates of it can check that the row did not change since.
+func (si *SheetImpl) loadRecord(toolkit *sheetsToolkit, data map[string]string, rowNum int, out interface{}) error {
+	err := si.loadRecordData(data, rowNum, out)
+	if err != nil {
+		return err
+	}
+	si.rememberLoaded(toolkit, data, out)
+	return nil
+}
+
+// loadRecordData is loadRecord without remembering the data, for records that are copied after loading
+func (si *SheetImpl) loadRecordData(data map[string]string, rowNum int, out interface{}) error {
+	err := typemagic.LoadIntoStructWithPolicy(data, out, si.emptyCellPolicy)
+	if err != nil {
+		var fieldErr *e.FieldError
+		if errors.As(err, &fieldErr) {
+			fieldErr.Row = rowNum
+		}
+		return err
+	}
+	if si.validateOnLoad {
+		err = typemagic.ValidateStruct(out)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rememberLoaded stores the data as it was loaded into the record, if conflict detection is enabled
+func (si *SheetImpl) rememberLoaded(toolkit *sheetsToolkit, data map[string]string, out interface{}) {
+	if si.conflictDetection {
+		si.loadedRows.store(newLoadedRowKey(toolkit, data[toolkit.uidCol], out), data)
+	}
 }
 
 func (si *SheetImpl) GetRecord(ctx context.Context, out interface{}) error {
+	recordType := recordTypeName(out)
+	ctx, end := si.telemetry.start(ctx, "GetRecord", attrRecordType.String(recordType))
+	err := si.getRecord(ctx, out)
+	if err == nil {
+		si.telemetry.addRowsRead(ctx, recordType, 1)
+	}
+	end(err)
+	return err
+}
+
+func (si *SheetImpl) getRecord(ctx context.Context, out interface{}) error {
 	si.mu.RLock()
 	defer si.mu.RUnlock()
 
@@ -123,22 +476,51 @@ func (si *SheetImpl) GetRecord(ctx context.Context, out interface{}) error {
 		return err
 	}
 
-	uid := typemagic.DumpUID(out)
+	uid, err := typemagic.DumpUID(out)
+	if err != nil {
+		return err
+	}
 	if uid == "" {
 		return e.ErrEmptyUID
 	}
 
 	var data map[string]string
-	data, err = toolkit.getRecordData(ctx, uid)
+	var rowNum int
+	if si.snapshot != nil {
+		data, rowNum, err = si.snapshot.getRecordData(ctx, toolkit, uid)
+	} else {
+		data, rowNum, err = toolkit.getRecordData(ctx, uid)
+	}
 	if err != nil {
 		si.logger.Error("error while getting record data")
 		return err
 	}
 
-	return typemagic.LoadIntoStruct(data, out)
+	return si.loadRecord(toolkit, data, rowNum, out)
 }
 
 func (si *SheetImpl) GetAllRecords(ctx context.Context, out interface{}) error {
+	return si.getAllRecordsTraced(ctx, "GetAllRecords", out, false)
+}
+
+func (si *SheetImpl) GetAllRecordsTolerant(ctx context.Context, out interface{}) error {
+	return si.getAllRecordsTraced(ctx, "GetAllRecordsTolerant", out, true)
+}
+
+func (si *SheetImpl) getAllRecordsTraced(ctx context.Context, method string, out interface{}, tolerant bool) error {
+	recordType := recordTypeName(out)
+	ctx, end := si.telemetry.start(ctx, method, attrRecordType.String(recordType))
+	err := si.getAllRecords(ctx, out, tolerant)
+	var rowErrs *e.RowErrors
+	if err == nil || errors.As(err, &rowErrs) { // out is only set in these cases
+		si.telemetry.addRowsRead(ctx, recordType, reflect.ValueOf(out).Elem().Len())
+	}
+	end(err)
+	return err
+}
+
+// getAllRecords either aborts on the first row that could not be loaded, or collects the errors if tolerant is set
+func (si *SheetImpl) getAllRecords(ctx context.Context, out interface{}, tolerant bool) error {
 	si.mu.RLock()
 	defer si.mu.RUnlock()
 
@@ -155,8 +537,12 @@ func (si *SheetImpl) GetAllRecords(ctx context.Context, out interface{}) error {
 		return err
 	}
 
-	var ch <-chan map[string]string
-	ch, err = toolkit.getAllRecordsData(ctx)
+	var ch <-chan rowData
+	if si.snapshot != nil {
+		ch, err = si.snapshot.getAllRecordsData(ctx, toolkit)
+	} else {
+		ch, err = toolkit.getAllRecordsData(ctx)
+	}
 	if err != nil {
 		si.logger.Error("Failure while getting records", zap.Error(err))
 		return err
@@ -165,22 +551,31 @@ func (si *SheetImpl) GetAllRecords(ctx context.Context, out interface{}) error {
 	outSlicePtr := reflect.New(reflect.TypeOf(out).Elem())
 	outSlice := outSlicePtr.Elem()
 
+	var rowErrs []*e.RowError
+	var loadedData []map[string]string // in the order of outSlice
+
 loop:
 	for {
 		select {
-		case data, ok := <-ch:
+		case row, ok := <-ch:
 			if !ok {
 				break loop
 			}
 
 			inst = reflect.New(reflect.TypeOf(out).Elem().Elem())
 
-			err = typemagic.LoadIntoStruct(data, inst.Interface())
+			err = si.loadRecordData(row.data, row.rowNum, inst.Interface())
 			if err != nil {
-				return err
+				if !tolerant {
+					return err
+				}
+				si.logger.Debug("Skipping row that could not be loaded", zap.Int("rowNum", row.rowNum), zap.Error(err))
+				rowErrs = append(rowErrs, &e.RowError{Row: row.rowNum, UID: row.data[toolkit.uidCol], Err: err})
+				continue
 			}
 
 			outSlice.Set(reflect.Append(outSlice, inst.Elem()))
+			loadedData = append(loadedData, row.data)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -188,83 +583,228 @@ loop:
 
 	reflect.ValueOf(out).Elem().Set(outSlicePtr.Elem())
 
+	// the records were copied into the slice, so they are remembered by the address of the elements the caller got
+	outSlice = reflect.ValueOf(out).Elem()
+	for i, data := range loadedData {
+		si.rememberLoaded(toolkit, data, outSlice.Index(i).Addr().Interface())
+	}
+
+	if len(rowErrs) > 0 {
+		return &e.RowErrors{Errors: rowErrs}
+	}
+
 	return nil
 }
 
 // UpdateRecords the corresponding uid field must be filled in the records in receives, if the uid can not be found in the table, it throws an error
 func (si *SheetImpl) UpdateRecords(ctx context.Context, records ...interface{}) error {
+	ctx, end := si.telemetry.start(ctx, "UpdateRecords")
+	_, err := si.updateRecords(ctx, false, records)
+	end(err)
+	return err
+}
+
+// UpdateRecordsDiff is the same as UpdateRecords, but only the cells that differ from the current rows are written
+func (si *SheetImpl) UpdateRecordsDiff(ctx context.Context, records ...interface{}) ([]FieldChange, error) {
+	ctx, end := si.telemetry.start(ctx, "UpdateRecordsDiff")
+	changes, err := si.updateRecords(ctx, true, records)
+	end(err)
+	return changes, err
+}
+
+// updateRecords either writes every dumped field, or only the ones that changed if diff is set. Changes are only reported in diff mode.
+func (si *SheetImpl) updateRecords(ctx context.Context, diff bool, records []interface{}) ([]FieldChange, error) {
 	si.mu.Lock()
 	defer si.mu.Unlock()
 
 	if len(records) == 0 {
-		return nil
+		return nil, nil
 	}
 
-	unwrappedRecords := make([]interface{}, 0) // <- will store just pointers to structs
-
-	for _, r := range records {
-		if typeAssert(r, reflect.Ptr, reflect.Struct) {
-			unwrappedRecords = append(unwrappedRecords, r)
-			continue
-		}
-		if typeAssert(r, reflect.Slice, reflect.Ptr, reflect.Struct) {
-			val := reflect.ValueOf(r)
-			for i := 0; i < val.Len(); i++ {
-				unwrappedRecords = append(unwrappedRecords, val.Index(i).Interface())
-			}
-			continue
-		}
-
-		return errors.Join(e.ErrInvalidType, fmt.Errorf("expected pointer to struct or a slice of pointers to structs"))
+	unwrappedRecords, err := unwrapRecords(records)
+	if err != nil {
+		return nil, err
 	}
 
 	// create a sample first, for the toolkit
 	inst := reflect.New(reflect.TypeOf(unwrappedRecords[0]).Elem())
+	trace.SpanFromContext(ctx).SetAttributes(attrRecords.Int(len(unwrappedRecords)), attrRecordType.String(recordTypeName(inst.Interface())))
 
 	toolkit, err := si.getToolkit(inst.Elem().Interface())
 	if err != nil {
 		si.logger.Error("Failed to initialize toolkit", zap.Error(err))
-		return err
+		return nil, err
 	}
 
 	allData := make([]map[string]string, len(unwrappedRecords))
 	uids := make([]string, len(unwrappedRecords))
 	for i, r := range unwrappedRecords {
-		uid := typemagic.DumpUID(r)
+		var uid string
+		uid, err = typemagic.DumpUID(r)
+		if err != nil {
+			return nil, err
+		}
 
 		if slices.Contains(uids, uid) {
-			return e.ErrMultiUpdate
+			return nil, e.ErrMultiUpdate
 		}
 
 		uids[i] = uid
 		if uids[i] == "" {
-			return e.ErrEmptyUID
+			return nil, e.ErrEmptyUID
 		}
 
-		allData[i] = typemagic.DumpStruct(r, true)
+		allData[i], err = typemagic.DumpStruct(r, true)
+		if err != nil {
+			return nil, err
+		}
 
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return nil, ctx.Err()
+		}
+	}
+
+	if si.lock != nil {
+		err = si.lock.checkOutside(toolkit)
+		if err != nil {
+			return nil, err
 		}
+
+		var release func()
+		ctx, release, err = si.lock.hold(ctx)
+		if err != nil {
+			si.logger.Error("Failed to acquire lock", zap.Error(err))
+			return nil, err
+		}
+		defer release()
+	}
+
+	var expected []map[string]string
+	expected, err = si.expectedRows(toolkit, uids, allData, unwrappedRecords)
+	if err != nil {
+		return nil, err
 	}
 
 	var updatedData []map[string]string
-	updatedData, err = toolkit.updateRecords(ctx, uids, allData)
+	var rowNums []int
+	var changes []FieldChange
+	if diff {
+		var previousData []map[string]string
+		updatedData, rowNums, previousData, err = toolkit.updateChangedCells(ctx, uids, allData, expected)
+		if err == nil {
+			changes, err = collectFieldChanges(inst.Interface(), toolkit, uids, rowNums, previousData, allData)
+		}
+	} else if expected != nil {
+		updatedData, rowNums, err = toolkit.updateRecordsChecked(ctx, uids, allData, expected)
+	} else {
+		updatedData, rowNums, err = toolkit.updateRecords(ctx, uids, allData)
+	}
 	if err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, e.ErrLockLost) {
+			err = errors.Join(cause, err)
+		}
 		si.logger.Error("error while updating records", zap.Error(err))
-		return err
+		return nil, err
+	}
+
+	if len(updatedData) == 0 {
+		// nothing was updated, so nothing was read back either
+		return nil, nil
+	}
+
+	if si.dryRun != nil {
+		// nothing was written, so the records are left as they are
+		return changes, nil
+	}
+
+	rowsWritten := len(rowNums)
+	if diff {
+		rowsWritten = countChangedRows(changes)
+	}
+	si.telemetry.addRowsWritten(ctx, recordTypeName(inst.Interface()), rowsWritten)
+
+	if si.snapshot != nil {
+		si.snapshot.update(toolkit, updatedData, rowNums)
 	}
 
 	for i, r := range unwrappedRecords {
-		err = typemagic.LoadIntoStruct(updatedData[i], r)
+		err = si.loadRecord(toolkit, updatedData[i], rowNums[i], r)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
 	}
 
-	return nil
+	return changes, nil
+}
+
+// expectedRows returns the cells that must not have changed in the sheet for each record, or nil if nothing has to be checked.
+// Records with a version field are expected to have the same version in the sheet, and their version is incremented in the data to be written.
+// Other records are expected to be as they were last loaded into the same struct, if conflict detection is enabled.
+func (si *SheetImpl) expectedRows(toolkit *sheetsToolkit, uids []string, allData []map[string]string, records []interface{}) ([]map[string]string, error) {
+	if toolkit.versionCol == "" && !si.conflictDetection {
+		return nil, nil
+	}
+
+	expected := make([]map[string]string, len(allData))
+	for i, data := range allData {
+		if toolkit.versionCol != "" {
+			version, err := bumpVersion(toolkit, data)
+			if err != nil {
+				return nil, err
+			}
+			expected[i] = map[string]string{toolkit.versionCol: version}
+			continue
+		}
+
+		loaded, ok := si.loadedRows.load(newLoadedRowKey(toolkit, uids[i], records[i]))
+		if ok {
+			expected[i] = loaded
+		} else {
+			si.logger.Debug("Record was not loaded into this struct before, writing it unchecked", zap.String("uid", uids[i]))
+		}
+	}
+
+	if !slices.ContainsFunc(expected, func(m map[string]string) bool { return m != nil }) {
+		return nil, nil // none of the records were loaded before, so there is nothing to check
+	}
+	return expected, nil
+}
+
+// bumpVersion increments the version in the data to be written, and returns the version it had before
+func bumpVersion(toolkit *sheetsToolkit, data map[string]string) (string, error) {
+	version := data[toolkit.versionCol]
+	var current int64
+	if version != "" {
+		var err error
+		current, err = strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return "", err
+		}
+	}
+	data[toolkit.versionCol] = strconv.FormatInt(current+1, 10)
+	return version, nil
+}
+
+// Refresh reloads the snapshot of every record type read so far, it does nothing if snapshot mode is not enabled
+func (si *SheetImpl) Refresh(ctx context.Context) error {
+	if si.snapshot == nil {
+		return nil
+	}
+	ctx, end := si.telemetry.start(ctx, "Refresh")
+	err := si.snapshot.refresh(ctx)
+	end(err)
+	return err
+}
+
+// SnapshotTakenAt returns the time the snapshot was loaded from the sheet, reads return data at least as fresh as this.
+// The second return value is false if snapshot mode is not enabled, or nothing was read yet.
+func (si *SheetImpl) SnapshotTakenAt() (time.Time, bool) {
+	if si.snapshot == nil {
+		return time.Time{}, false
+	}
+	return si.snapshot.takenAtTime()
 }
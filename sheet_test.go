@@ -0,0 +1,626 @@
+package sheetsorm
+
+import (
+	"context"
+	"errors"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/pproj/sheetsorm/cache"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/sheetsormtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/sheets/v4"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSheetRecord struct {
+	Name string `sheet:"A,uid"`
+	Age  int    `sheet:"B"`
+}
+
+func newTestSheet(t *testing.T, aw api.ApiWrapper, skipRows int) *SheetImpl {
+	nc := &cache.NullCache{}
+	return &SheetImpl{
+		mu:       &sync.RWMutex{},
+		aw:       aw,
+		logger:   zaptest.NewLogger(t),
+		skipRows: skipRows,
+		uidCache: nc,
+		rowCache: nc,
+	}
+}
+
+func TestSheet_GetAllRecordsTolerant(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:B").Return(&sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values: [][]interface{}{
+			{"alice", "22"},
+			{"bob", "twenty"},
+			{"", "12"}, // not a record
+			{"carol", "33"},
+			{"dave", "1.5"},
+		},
+	}, nil)
+
+	si := newTestSheet(t, m, 1)
+
+	t.Run("strict", func(t *testing.T) {
+		var records []testSheetRecord
+		err := si.GetAllRecords(context.Background(), &records)
+		assert.ErrorIs(t, err, strconv.ErrSyntax)
+		assert.Nil(t, records)
+
+		var fieldErr *e.FieldError
+		if assert.ErrorAs(t, err, &fieldErr) {
+			assert.Equal(t, 3, fieldErr.Row)
+			assert.Equal(t, "B", fieldErr.Column)
+			assert.Equal(t, "twenty", fieldErr.Value)
+		}
+	})
+
+	t.Run("tolerant", func(t *testing.T) {
+		var records []testSheetRecord
+		err := si.GetAllRecordsTolerant(context.Background(), &records)
+		assert.Equal(t, []testSheetRecord{{Name: "alice", Age: 22}, {Name: "carol", Age: 33}}, records)
+
+		var rowErrs *e.RowErrors
+		if assert.ErrorAs(t, err, &rowErrs) {
+			assert.Len(t, rowErrs.Errors, 2)
+			assert.Equal(t, 3, rowErrs.Errors[0].Row)
+			assert.Equal(t, "bob", rowErrs.Errors[0].UID)
+			assert.Equal(t, 6, rowErrs.Errors[1].Row)
+			assert.Equal(t, "dave", rowErrs.Errors[1].UID)
+		}
+		assert.ErrorIs(t, err, strconv.ErrSyntax)
+
+		var fieldErr *e.FieldError
+		if assert.ErrorAs(t, err, &fieldErr) {
+			assert.Equal(t, 3, fieldErr.Row)
+			assert.Equal(t, "Age", fieldErr.Field)
+		}
+	})
+
+	m.AssertExpectations(t)
+}
+
+func TestSheet_GetAllRecordsTolerantApiError(t *testing.T) {
+	testErr := errors.New("hello")
+
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A1:B").Return((*sheets.ValueRange)(nil), testErr)
+
+	si := newTestSheet(t, m, 0)
+
+	records := []testSheetRecord{{Name: "untouched"}}
+	err := si.GetAllRecordsTolerant(context.Background(), &records)
+	assert.ErrorIs(t, err, testErr)
+	assert.Equal(t, []testSheetRecord{{Name: "untouched"}}, records)
+}
+
+func TestSheet_CacheNamespace(t *testing.T) {
+	type otherRecord struct {
+		Name string `sheet:"A,uid"`
+		City string `sheet:"C"`
+	}
+
+	si := newTestSheet(t, &api.MockApiWrapper{}, 0)
+	si.docID = "doc"
+	si.sheet = "Sheet1"
+
+	tk, err := si.getToolkit(testSheetRecord{})
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Namespace("doc/Sheet1/A"), tk.uidNs)
+	assert.Equal(t, cache.Namespace("doc/Sheet1"), tk.rowNs)
+
+	otherTk, err := si.getToolkit(otherRecord{})
+	assert.NoError(t, err)
+	assert.Equal(t, tk.uidNs, otherTk.uidNs)
+	assert.Equal(t, tk.rowNs, otherTk.rowNs)
+	assert.NotEqual(t, tk.typeKey, otherTk.typeKey)
+
+	si.sheet = "Sheet2"
+	otherSheetTk, err := si.getToolkit(testSheetRecord{})
+	assert.NoError(t, err)
+	assert.NotEqual(t, tk.uidNs, otherSheetTk.uidNs)
+	assert.NotEqual(t, tk.rowNs, otherSheetTk.rowNs)
+}
+
+func TestSheet_CacheSharedBetweenTypes(t *testing.T) {
+	type detailedRecord struct {
+		Name string `sheet:"A,uid"`
+		Age  int    `sheet:"B"`
+		City string `sheet:"C"`
+	}
+
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:C2", [][]string{
+		{"name", "age", "city"},
+		{"alice", "22", "Budapest"},
+	}))
+	aw := &testCountingApiWrapper{ApiWrapper: s.ApiWrapper("")}
+	si := newTestSheet(t, aw, 1)
+	mc := cache.NewMemoryCache(cache.MemoryCacheConfig{})
+	si.uidCache = mc
+	si.rowCache = mc
+	ctx := context.Background()
+
+	detailed := detailedRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, &detailed))
+	assert.Equal(t, detailedRecord{Name: "alice", Age: 22, City: "Budapest"}, detailed)
+
+	// the row cached by the other type has every column needed
+	reads := aw.reads
+	record := testSheetRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, testSheetRecord{Name: "alice", Age: 22}, record)
+	assert.Equal(t, reads, aw.reads)
+
+	// an update through one type drops the row for the other type as well
+	assert.NoError(t, si.UpdateRecords(ctx, &testSheetRecord{Name: "alice", Age: 23}))
+	detailed = detailedRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, &detailed))
+	assert.Equal(t, detailedRecord{Name: "alice", Age: 23, City: "Budapest"}, detailed)
+}
+
+func TestSheet_UpdateRecordsDiff(t *testing.T) {
+	uidCol := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}, {"bob"}},
+	}
+	currentRows := &sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{
+			{Values: [][]interface{}{{"alice", "22"}}},
+			{Values: [][]interface{}{{"bob", "33"}}},
+		},
+	}
+
+	t.Run("changed", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A3:B3"}).Return(currentRows, nil).Once()
+		m.On("BatchUpdate", mock.Anything, mock.MatchedBy(func(vrs []*sheets.ValueRange) bool {
+			return len(vrs) == 1 && vrs[0].Range == "B2" && vrs[0].Values[0][0] == "23"
+		})).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A3:B3"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{
+				{Values: [][]interface{}{{"alice", "23"}}},
+				{Values: [][]interface{}{{"bob", "33"}}},
+			},
+		}, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		records := []*testSheetRecord{{Name: "alice", Age: 23}, {Name: "bob", Age: 33}}
+		changes, err := si.UpdateRecordsDiff(context.Background(), records)
+		assert.NoError(t, err)
+		assert.Equal(t, []FieldChange{{UID: "alice", Row: 2, Field: "Age", Column: "B", Old: "22", New: "23"}}, changes)
+		assert.Equal(t, 23, records[0].Age)
+
+		m.AssertExpectations(t)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A3:B3"}).Return(currentRows, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		record := &testSheetRecord{Name: "bob", Age: 33}
+		changes, err := si.UpdateRecordsDiff(context.Background(), &testSheetRecord{Name: "alice", Age: 22}, record)
+		assert.NoError(t, err)
+		assert.Empty(t, changes)
+
+		m.AssertExpectations(t) // nothing was written
+		m.AssertNotCalled(t, "BatchUpdate", mock.Anything, mock.Anything)
+	})
+
+	t.Run("row_moved", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:B2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"carol", "44"}}}},
+		}, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		_, err := si.UpdateRecordsDiff(context.Background(), &testSheetRecord{Name: "alice", Age: 23})
+		assert.ErrorIs(t, err, e.ErrInconsistentData)
+	})
+}
+
+func TestSheet_UpdateRecordsVersion(t *testing.T) {
+	type versionedRecord struct {
+		Name    string `sheet:"A,uid"`
+		Age     int    `sheet:"B"`
+		Version int    `sheet:"C,version"`
+	}
+
+	uidCol := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}},
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:C2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "22", "3"}}}},
+		}, nil).Once()
+		m.On("BatchUpdate", mock.Anything, mock.MatchedBy(func(vrs []*sheets.ValueRange) bool {
+			return len(vrs) == 1 && vrs[0].Range == "A2:C2" && vrs[0].Values[0][2] == "4"
+		})).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:C2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "23", "4"}}}},
+		}, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		record := &versionedRecord{Name: "alice", Age: 23, Version: 3}
+		assert.NoError(t, si.UpdateRecords(context.Background(), record))
+		assert.Equal(t, versionedRecord{Name: "alice", Age: 23, Version: 4}, *record)
+
+		m.AssertExpectations(t)
+	})
+
+	t.Run("empty_version_cell", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:C2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "22"}}}},
+		}, nil).Once()
+		m.On("BatchUpdate", mock.Anything, mock.MatchedBy(func(vrs []*sheets.ValueRange) bool {
+			return len(vrs) == 1 && vrs[0].Values[0][2] == "1"
+		})).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:C2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "23", "1"}}}},
+		}, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		record := &versionedRecord{Name: "alice", Age: 23}
+		assert.NoError(t, si.UpdateRecords(context.Background(), record))
+		assert.Equal(t, 1, record.Version)
+
+		m.AssertExpectations(t)
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		m := &api.MockApiWrapper{}
+		m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil).Once()
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:C2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "30", "4"}}}},
+		}, nil).Once()
+
+		si := newTestSheet(t, m, 1)
+		record := &versionedRecord{Name: "alice", Age: 23, Version: 3}
+		err := si.UpdateRecords(context.Background(), record)
+		assert.ErrorIs(t, err, e.ErrConflict)
+
+		var conflictErr *e.ConflictError
+		if assert.ErrorAs(t, err, &conflictErr) {
+			assert.Equal(t, []e.Conflict{{
+				UID:     "alice",
+				Row:     2,
+				Columns: []string{"C"},
+				Current: map[string]string{"A": "alice", "B": "30", "C": "4"},
+			}}, conflictErr.Conflicts)
+		}
+		assert.Equal(t, versionedRecord{Name: "alice", Age: 23, Version: 3}, *record) // untouched
+
+		m.AssertExpectations(t)
+		m.AssertNotCalled(t, "BatchUpdate", mock.Anything, mock.Anything)
+	})
+}
+
+func TestSheet_UpdateRecordsDiffNormalized(t *testing.T) {
+	type priced struct {
+		Name    string  `sheet:"A,uid"`
+		Price   float64 `sheet:"B"`
+		Active  bool    `sheet:"C,true=true,false=false"`
+		Version int     `sheet:"D,version"`
+	}
+
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:D2", [][]string{{"name", "price", "active", "version"}, {"apple", "1.50", "TRUE", "3"}}))
+	si := newTestSheet(t, s.ApiWrapper(""), 1)
+	ctx := context.Background()
+
+	t.Run("unchanged", func(t *testing.T) {
+		// formatted differently than dumped, and the version is not bumped if nothing else changed
+		record := &priced{Name: "apple", Price: 1.5, Active: true, Version: 3}
+		changes, err := si.UpdateRecordsDiff(ctx, record)
+		assert.NoError(t, err)
+		assert.Empty(t, changes)
+		assert.Equal(t, 3, record.Version)
+		assert.Equal(t, 0, s.Calls("BatchUpdate"))
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		record := &priced{Name: "apple", Price: 2, Active: true, Version: 3}
+		changes, err := si.UpdateRecordsDiff(ctx, record)
+		assert.NoError(t, err)
+		assert.Equal(t, []FieldChange{
+			{UID: "apple", Row: 2, Field: "Price", Column: "B", Old: "1.50", New: "2"},
+			{UID: "apple", Row: 2, Field: "Version", Column: "D", Old: "3", New: "4"},
+		}, changes)
+		assert.Equal(t, 4, record.Version)
+
+		values, err := s.Values("A2:D2")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"apple", "2", "TRUE", "4"}}, values)
+	})
+}
+
+func TestSheet_ConflictDetection(t *testing.T) {
+	uidCol := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}, {"bob"}},
+	}
+
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:B").Return(&sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice", "22"}, {"bob", "33"}},
+	}, nil).Once()
+	m.On("GetRange", mock.Anything, "A2:A").Return(uidCol, nil)
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2", "A3:B3"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{
+			{Values: [][]interface{}{{"alice", "22"}}},
+			{Values: [][]interface{}{{"bob", "34"}}}, // changed by someone else
+		},
+	}, nil).Once()
+
+	si := newTestSheet(t, m, 1)
+	si.conflictDetection = true
+	ctx := context.Background()
+
+	var records []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &records))
+
+	records[0].Age = 23
+	records[1].Age = 35
+	err := si.UpdateRecords(ctx, []*testSheetRecord{&records[0], &records[1]})
+	var conflictErr *e.ConflictError
+	if assert.ErrorAs(t, err, &conflictErr) {
+		assert.Len(t, conflictErr.Conflicts, 1)
+		assert.Equal(t, "bob", conflictErr.Conflicts[0].UID)
+		assert.Equal(t, []string{"B"}, conflictErr.Conflicts[0].Columns)
+		assert.Equal(t, "34", conflictErr.Conflicts[0].Current["B"])
+	}
+
+	m.AssertNotCalled(t, "BatchUpdate", mock.Anything, mock.Anything)
+
+	t.Run("not_loaded_before", func(t *testing.T) {
+		m.On("BatchGetRanges", mock.Anything, []string{"A3:B3"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"bob", "34"}}}},
+		}, nil)
+		m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+
+		other := newTestSheet(t, m, 1)
+		other.conflictDetection = true
+		assert.NoError(t, other.UpdateRecords(ctx, &testSheetRecord{Name: "bob", Age: 35}))
+	})
+
+	t.Run("copy_not_checked", func(t *testing.T) {
+		m.On("BatchGetRanges", mock.Anything, []string{"A2:B2"}).Return(&sheets.BatchGetValuesResponse{
+			ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "30"}}}},
+		}, nil)
+		m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+
+		alice := records[0] // only the struct the row was loaded into is checked
+		alice.Age = 23
+		assert.NoError(t, si.UpdateRecords(ctx, &alice))
+	})
+}
+
+func TestSheet_ConflictDetectionPerCopy(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:A").Return(&sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}},
+	}, nil)
+	m.On("GetRange", mock.Anything, "A2:B2").Return(&sheets.ValueRange{
+		Values: [][]interface{}{{"alice", "22"}},
+	}, nil).Once()
+	m.On("GetRange", mock.Anything, "A2:B2").Return(&sheets.ValueRange{
+		Values: [][]interface{}{{"alice", "23"}},
+	}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "23"}}}},
+	}, nil)
+
+	si := newTestSheet(t, m, 1)
+	si.conflictDetection = true
+	ctx := context.Background()
+
+	stale := &testSheetRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, stale))
+	fresh := &testSheetRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, fresh)) // loaded after someone else changed the row
+
+	stale.Age = 30
+	err := si.UpdateRecords(ctx, stale)
+	var conflictErr *e.ConflictError
+	if assert.ErrorAs(t, err, &conflictErr) {
+		assert.Equal(t, []string{"B"}, conflictErr.Conflicts[0].Columns)
+		assert.Equal(t, "23", conflictErr.Conflicts[0].Current["B"])
+	}
+	m.AssertNotCalled(t, "BatchUpdate", mock.Anything, mock.Anything)
+}
+
+func TestSheet_FakeSpreadsheet(t *testing.T) {
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:B4", [][]string{
+		{"name", "age"},
+		{"alice", "22"},
+		{},
+		{"carol", "33"},
+	}))
+	si := newTestSheet(t, s.ApiWrapper(""), 1)
+	ctx := context.Background()
+
+	var records []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &records))
+	assert.Equal(t, []testSheetRecord{{Name: "alice", Age: 22}, {Name: "carol", Age: 33}}, records)
+
+	assert.NoError(t, si.UpdateRecords(ctx, &testSheetRecord{Name: "carol", Age: 34}))
+	values, err := s.Values("A2:B")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"alice", "22"}, {}, {"carol", "34"}}, values)
+
+	record := testSheetRecord{Name: "dave"}
+	assert.ErrorIs(t, si.GetRecord(ctx, &record), e.ErrRecordNotFound)
+}
+
+// testCountingApiWrapper counts the reads passing through it
+type testCountingApiWrapper struct {
+	api.ApiWrapper
+	reads int
+}
+
+func (c *testCountingApiWrapper) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	c.reads++
+	return c.ApiWrapper.GetRange(ctx, range_)
+}
+
+func TestNewSheetWithWrapper(t *testing.T) {
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:B2", [][]string{{"name", "age"}, {"alice", "22"}}))
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{SkipRows: 1})
+		assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	})
+
+	t.Run("retry policy", func(t *testing.T) {
+		_, err := NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{DocID: "doc", SkipRows: 1}, WithRetryPolicy(api.DefaultRetryPolicy()))
+		assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	})
+
+	t.Run("telemetry", func(t *testing.T) {
+		_, err := NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{DocID: "doc", SkipRows: 1}, WithTelemetry(nil, nil))
+		assert.ErrorIs(t, err, e.ErrConfigInvalid)
+	})
+
+	t.Run("middleware", func(t *testing.T) {
+		counter := &testCountingApiWrapper{}
+		si, err := NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{DocID: "doc", SkipRows: 1},
+			WithMiddleware(func(next api.ApiWrapper) api.ApiWrapper {
+				counter.ApiWrapper = next
+				return counter
+			}),
+			WithRateLimiter(api.NewRateLimiter(api.RateLimiterConfig{})),
+		)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		record := testSheetRecord{Name: "alice"}
+		assert.NoError(t, si.GetRecord(context.Background(), &record))
+		assert.Equal(t, 22, record.Age)
+		assert.Equal(t, 2, counter.reads) // the uid column and the row
+		assert.Equal(t, 2, s.Calls("GetRange"))
+	})
+}
+
+func TestNewSheet_Server(t *testing.T) {
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:B3", [][]string{{"name", "age"}, {"alice", "22"}, {"bob", "33"}}))
+	srv := sheetsormtest.NewServer(s)
+	defer srv.Close()
+
+	ctx := context.Background()
+	service, err := srv.Service(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	si, err := NewSheet(service, StructureConfig{DocID: sheetsormtest.SpreadsheetID, Sheet: "Sheet1", SkipRows: 1},
+		WithRetryPolicy(api.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s.InjectFault(sheetsormtest.Fault{Err: sheetsormtest.ServiceUnavailable(), Times: 1})
+	var records []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &records))
+	assert.Equal(t, []testSheetRecord{{Name: "alice", Age: 22}, {Name: "bob", Age: 33}}, records)
+	assert.Equal(t, 2, s.Calls("GetRange"))
+
+	assert.NoError(t, si.UpdateRecords(ctx, &testSheetRecord{Name: "bob", Age: 34}))
+	values, err := s.Values("A3:B3")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"bob", "34"}}, values)
+}
+
+func TestSheet_DryRun(t *testing.T) {
+	s := sheetsormtest.NewSpreadsheet()
+	assert.NoError(t, s.SetValues("A1:B3", [][]string{{"name", "age"}, {"alice", "22"}, {"bob", "33"}}))
+
+	mc := cache.NewMemoryCache(cache.MemoryCacheConfig{})
+	si, err := NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{DocID: "doc", SkipRows: 1},
+		WithDryRun(),
+		WithAdvisoryLock(AdvisoryLockConfig{Cell: "Z1"}),
+		WithRowUIDCache(mc),
+		WithRowCache(mc),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+	ctx := context.Background()
+
+	var all []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &all)) // fills the caches
+	uidNs := cache.NewUIDNamespace("doc", "", "A")
+	rowNs := cache.NewRowNamespace("doc", "")
+	assertCached := func(t *testing.T) {
+		for uid, rowNum := range map[string]int{"alice": 2, "bob": 3} {
+			cachedRowNum, ok := mc.GetRowNumByUID(uidNs, uid)
+			assert.True(t, ok)
+			assert.Equal(t, rowNum, cachedRowNum)
+			row, ok := mc.GetRow(rowNs, rowNum)
+			assert.True(t, ok)
+			assert.Equal(t, uid, row["A"])
+		}
+	}
+
+	record := testSheetRecord{Name: "bob", Age: 34}
+	assert.NoError(t, si.UpdateRecords(ctx, &record))
+	assert.Equal(t, testSheetRecord{Name: "bob", Age: 34}, record) // not loaded back
+
+	assert.Equal(t, 0, s.Calls("BatchUpdate"))
+	values, err := s.Values("A1:Z3")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"name", "age"}, {"alice", "22"}, {"bob", "33"}}, values)
+
+	assert.Equal(t, []api.PlannedWrite{
+		{Range: "A3:B3", Values: [][]interface{}{{"bob", "34"}}, OldValues: [][]interface{}{{"bob", "33"}}},
+	}, si.DryRun().Writes())
+	assertCached(t) // nothing was written, so nothing went stale
+
+	t.Run("batch", func(t *testing.T) {
+		si.DryRun().Reset()
+
+		dave := testSheetRecord{Name: "dave", Age: 40}
+		alice := testSheetRecord{Name: "alice"}
+		assert.NoError(t, si.Batch().Create(&dave).Delete(&alice).Commit(ctx))
+		assert.Equal(t, testSheetRecord{Name: "dave", Age: 40}, dave)
+		assert.Equal(t, testSheetRecord{Name: "alice"}, alice)
+
+		assert.Equal(t, 0, s.Calls("BatchUpdate"))
+		values, err := s.Values("A1:Z4")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"name", "age"}, {"alice", "22"}, {"bob", "33"}}, values)
+
+		assert.Equal(t, []api.PlannedWrite{
+			{Range: "A4:B4", Values: [][]interface{}{{"dave", "40"}}, OldValues: [][]interface{}{{"", ""}}},
+			{Range: "A2:B2", Values: [][]interface{}{{"", ""}}, OldValues: [][]interface{}{{"alice", "22"}}},
+		}, si.DryRun().Writes())
+		assertCached(t)
+	})
+
+	si, err = NewSheetWithWrapper(s.ApiWrapper(""), StructureConfig{DocID: "doc", SkipRows: 1})
+	assert.NoError(t, err)
+	assert.Nil(t, si.DryRun())
+}
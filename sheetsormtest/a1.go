@@ -0,0 +1,138 @@
+package sheetsormtest
+
+import (
+	"fmt"
+	"github.com/pproj/sheetsorm/column"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// open marks the end of a range that is not bounded in a dimension, like the rows of "A2:B"
+const open = -1
+
+// a1Range is a parsed A1 range, rows and columns are zero-based and the end is inclusive
+type a1Range struct {
+	sheet    string // empty if the range is not bound to a sheet
+	startRow int
+	startCol int
+	endRow   int // open if the range goes until the last row
+	endCol   int // open if the range goes until the last column
+}
+
+var cellRefRe = regexp.MustCompile(`^([A-Za-z]*)([0-9]*)$`)
+
+// parseA1 parses ranges like "A1", "A2:B5", "A2:B", "A:C", "1:3", optionally prefixed with a sheet name like "Sheet1!" or "'My sheet'!".
+// A range that is only a sheet name can't be told apart from a cell reference without knowing the sheets, so it is not accepted here.
+func parseA1(s string) (a1Range, error) {
+	var r a1Range
+	cells := s
+	if i := strings.LastIndex(s, "!"); i >= 0 {
+		sheet, err := unquoteSheet(s[:i])
+		if err != nil {
+			return r, err
+		}
+		r.sheet = sheet
+		cells = s[i+1:]
+	}
+
+	first, second, isSpan := strings.Cut(cells, ":")
+	startCol, startRow, ok := parseCellRef(first)
+	if !ok || (startCol == open && startRow == open) {
+		return r, fmt.Errorf("unable to parse range: %s", s)
+	}
+
+	if !isSpan {
+		// a single cell, both parts are required
+		if startCol == open || startRow == open {
+			return r, fmt.Errorf("unable to parse range: %s", s)
+		}
+		r.startRow, r.startCol, r.endRow, r.endCol = startRow, startCol, startRow, startCol
+		return r, nil
+	}
+
+	endCol, endRow, ok := parseCellRef(second)
+	if !ok || (endCol == open && endRow == open) {
+		return r, fmt.Errorf("unable to parse range: %s", s)
+	}
+	r.startRow, r.startCol, r.endRow, r.endCol = max(startRow, 0), max(startCol, 0), endRow, endCol
+	// the corners may be given in any order
+	if r.endRow != open && r.endRow < r.startRow {
+		r.startRow, r.endRow = r.endRow, r.startRow
+	}
+	if r.endCol != open && r.endCol < r.startCol {
+		r.startCol, r.endCol = r.endCol, r.startCol
+	}
+	return r, nil
+}
+
+// parseCellRef parses a cell reference, where either the column or the row may be missing, these are returned as open
+func parseCellRef(s string) (int, int, bool) {
+	m := cellRefRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, 0, false
+	}
+
+	col, row := open, open
+	if m[1] != "" {
+		if len(m[1]) > 3 { // the largest column is ZZZ
+			return 0, 0, false
+		}
+		col = column.ColIndex(strings.ToUpper(m[1]))
+	}
+	if m[2] != "" {
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		row = n - 1
+	}
+	return col, row, true
+}
+
+// unquoteSheet removes the quotes around a sheet name, quotes inside are escaped by doubling them
+func unquoteSheet(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if s == "" || strings.Contains(s, "'") { // the API accepts names with spaces without quotes as well
+		return "", fmt.Errorf("unable to parse range: invalid sheet name: %s", s)
+	}
+	return s, nil
+}
+
+var plainSheetRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// quoteSheet quotes a sheet name if needed, the same way the API does in the ranges it returns
+func quoteSheet(s string) string {
+	if _, _, isCellRef := parseCellRef(s); plainSheetRe.MatchString(s) && !isCellRef {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// String formats the range in A1 notation, with the sheet name if it is bound to one
+func (r a1Range) String() string {
+	var sb strings.Builder
+	if r.sheet != "" {
+		sb.WriteString(quoteSheet(r.sheet))
+		sb.WriteByte('!')
+	}
+	sb.WriteString(cellRef(r.startCol, r.startRow))
+	if r.endRow != r.startRow || r.endCol != r.startCol {
+		sb.WriteByte(':')
+		sb.WriteString(cellRef(r.endCol, r.endRow))
+	}
+	return sb.String()
+}
+
+func cellRef(col, row int) string {
+	var s string
+	if col != open {
+		s = column.ColFromIndex(col)
+	}
+	if row != open {
+		s += strconv.Itoa(row + 1)
+	}
+	return s
+}
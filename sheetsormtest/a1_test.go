@@ -0,0 +1,52 @@
+package sheetsormtest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseA1(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected a1Range
+		str      string
+		wantErr  bool
+	}{
+		{input: "A1", expected: a1Range{startRow: 0, startCol: 0, endRow: 0, endCol: 0}, str: "A1"},
+		{input: "b3", expected: a1Range{startRow: 2, startCol: 1, endRow: 2, endCol: 1}, str: "B3"},
+		{input: "A2:C5", expected: a1Range{startRow: 1, startCol: 0, endRow: 4, endCol: 2}, str: "A2:C5"},
+		{input: "C5:A2", expected: a1Range{startRow: 1, startCol: 0, endRow: 4, endCol: 2}, str: "A2:C5"},
+		{input: "A2:A", expected: a1Range{startRow: 1, startCol: 0, endRow: open, endCol: 0}, str: "A2:A"},
+		{input: "A:C", expected: a1Range{startRow: 0, startCol: 0, endRow: open, endCol: 2}, str: "A1:C"},
+		{input: "2:3", expected: a1Range{startRow: 1, startCol: 0, endRow: 2, endCol: open}, str: "A2:3"},
+		{input: "AA10:AB11", expected: a1Range{startRow: 9, startCol: 26, endRow: 10, endCol: 27}, str: "AA10:AB11"},
+		{input: "Sheet1!A1:B2", expected: a1Range{sheet: "Sheet1", endRow: 1, endCol: 1}, str: "Sheet1!A1:B2"},
+		{input: "'My sheet'!Z1", expected: a1Range{sheet: "My sheet", startCol: 25, endCol: 25}, str: "'My sheet'!Z1"},
+		{input: "'Bob''s'!A1", expected: a1Range{sheet: "Bob's"}, str: "'Bob''s'!A1"},
+		{input: "'A1'!A1", expected: a1Range{sheet: "A1"}, str: "'A1'!A1"},
+		{input: "", wantErr: true},
+		{input: "A", wantErr: true},
+		{input: "1", wantErr: true},
+		{input: "A0", wantErr: true},
+		{input: "A1:", wantErr: true},
+		{input: "A1:B2:C3", wantErr: true},
+		{input: "ABCD1", wantErr: true},
+		{input: "A1B", wantErr: true},
+		{input: "My sheet!A1", expected: a1Range{sheet: "My sheet"}, str: "'My sheet'!A1"},
+		{input: "Bob's!A1", wantErr: true},
+		{input: "!A1", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			r, err := parseA1(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, r)
+			assert.Equal(t, tc.str, r.String())
+		})
+	}
+}
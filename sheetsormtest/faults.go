@@ -0,0 +1,86 @@
+package sheetsormtest
+
+import (
+	"context"
+	"google.golang.org/api/googleapi"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Fault makes calls of the wrappers slow or fail, to test how the code under test copes with an unreliable API
+type Fault struct {
+	Method  string        // "GetSpreadsheet", "GetRange", "BatchGetRanges", "BatchUpdate", or "Append" and "BatchUpdateSpreadsheet" served by Server only, empty matches every method
+	Latency time.Duration // added before the call is served or failed, the context is honored while waiting
+	Err     error         // returned instead of serving the call, nil means the call is served
+	Times   int           // number of calls affected, 0 means every call until ClearFaults is called
+}
+
+// InjectFault adds a fault, if more faults match a call, the one injected first is applied
+func (s *Spreadsheet) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults removes every fault
+func (s *Spreadsheet) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// takeFault returns the first fault matching the method, and removes it if it was used up. It must be called with the lock held.
+func (s *Spreadsheet) takeFault(method string) *Fault {
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != method {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+// apply waits for the latency, and returns the error of the fault
+func (f *Fault) apply(ctx context.Context) error {
+	if f == nil {
+		return nil
+	}
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return f.Err
+}
+
+// TooManyRequests returns the error the API responds with when the quota is exceeded, a positive retryAfter sets the Retry-After header
+func TooManyRequests(retryAfter time.Duration) *googleapi.Error {
+	err := &googleapi.Error{
+		Code:    http.StatusTooManyRequests,
+		Message: "Quota exceeded for quota metric 'Read requests' and limit 'Read requests per minute per user'",
+		Header:  http.Header{},
+	}
+	if retryAfter > 0 {
+		err.Header.Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	return err
+}
+
+// ServiceUnavailable returns the error the API responds with when it is temporarily unavailable
+func ServiceUnavailable() *googleapi.Error {
+	return &googleapi.Error{
+		Code:    http.StatusServiceUnavailable,
+		Message: "The service is currently unavailable.",
+	}
+}
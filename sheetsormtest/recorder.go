@@ -0,0 +1,235 @@
+package sheetsormtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	htransport "google.golang.org/api/transport/http"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const redacted = "REDACTED"
+
+// redactedHeaders hold credentials, these are never written to fixtures
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+
+// credentialParams hold credentials in the query string, these are left out of fixtures, and ignored when matching
+var credentialParams = []string{"key", "access_token"}
+
+// Interaction is a request and the response it got, as stored in a fixture
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"` // the path and the query, without the host
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+type fixture struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Recorder is an http.RoundTripper that either records the requests sent through it along with their responses, or replays recorded responses without any network access.
+// Requests are matched on the method, the path with the query, and the body. Identical requests are replayed in the order they were recorded, each response only once.
+// Credentials are redacted from the headers and left out of the query when recording. It is safe for concurrent use.
+type Recorder struct {
+	path      string
+	recording bool
+	next      http.RoundTripper // only used when recording
+
+	mu           sync.Mutex
+	interactions []Interaction
+	played       []bool
+}
+
+// NewRecorder returns a Recorder recording to the fixture at path, the requests are sent by next. Call Save at the end of the session to write the fixture.
+func NewRecorder(path string, next http.RoundTripper) *Recorder {
+	return &Recorder{path: path, recording: true, next: next}
+}
+
+// NewReplayer returns a Recorder replaying the fixture at path
+func NewReplayer(path string) (*Recorder, error) {
+	data, err := os.ReadFile(path) // #nosec G304 the path is given by the test
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	return &Recorder{path: path, interactions: f.Interactions, played: make([]bool, len(f.Interactions))}, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recReq := RecordedRequest{
+		Method: req.Method,
+		URL:    requestURI(req.URL),
+		Header: redactHeader(req.Header),
+		Body:   string(body),
+	}
+
+	if !r.recording {
+		return r.replay(req, recReq)
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err // not recorded, network errors can't be replayed faithfully anyway
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Request: recReq,
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     redactHeader(resp.Header),
+			Body:       string(respBody),
+		},
+	})
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// replay returns the response of the first interaction matching the request, that was not played yet
+func (r *Recorder) replay(req *http.Request, recReq RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, in := range r.interactions {
+		if r.played[i] || in.Request.Method != recReq.Method || in.Request.URL != recReq.URL || strings.TrimSpace(in.Request.Body) != strings.TrimSpace(recReq.Body) {
+			continue
+		}
+		r.played[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Response.StatusCode, http.StatusText(in.Response.StatusCode)),
+			StatusCode:    in.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Response.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(in.Response.Body)),
+			ContentLength: int64(len(in.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded interaction left in %s matching %s %s", r.path, recReq.Method, recReq.URL)
+}
+
+// Save writes the recorded interactions to the fixture, it does nothing when replaying
+func (r *Recorder) Save() error {
+	if !r.recording {
+		return nil
+	}
+	r.mu.Lock()
+	data, err := json.MarshalIndent(fixture{Interactions: r.interactions}, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o600)
+}
+
+// Remaining returns the number of recorded interactions not replayed yet, so tests can check that the session was replayed entirely
+func (r *Recorder) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int
+	for _, played := range r.played {
+		if !played {
+			n++
+		}
+	}
+	return n
+}
+
+// NewRecordingService returns a service talking to the real API, authenticated by the options passed, and recording the session.
+// Call Save on the returned Recorder at the end of the session.
+func NewRecordingService(ctx context.Context, path string, opts ...option.ClientOption) (*sheets.Service, *Recorder, error) {
+	rec := NewRecorder(path, http.DefaultTransport)
+	opts = append([]option.ClientOption{option.WithScopes(sheets.SpreadsheetsScope)}, opts...)
+	transport, err := htransport.NewTransport(ctx, rec, opts...) // the credentials are added above the recorder, so it can redact them
+	if err != nil {
+		return nil, nil, err
+	}
+	srv, err := sheets.NewService(ctx, append(opts, option.WithHTTPClient(&http.Client{Transport: transport}))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return srv, rec, nil
+}
+
+// NewReplayingService returns a service replaying the session recorded to path, without network access
+func NewReplayingService(ctx context.Context, path string) (*sheets.Service, *Recorder, error) {
+	rec, err := NewReplayer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: rec}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return srv, rec, nil
+}
+
+// readBody reads the body of the request, and replaces it with a copy, so it can be sent as well
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, k := range redactedHeaders {
+		if out.Get(k) != "" {
+			out.Set(k, redacted)
+		}
+	}
+	return out
+}
+
+// requestURI returns the path and the query of the url, without the credentials in the query
+func requestURI(u *url.URL) string {
+	query := u.Query()
+	for _, k := range credentialParams {
+		query.Del(k)
+	}
+	out := url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: query.Encode()}
+	return out.RequestURI()
+}
@@ -0,0 +1,136 @@
+package sheetsormtest
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecordedSession does a few calls through the api wrapper, and returns what it read
+func testRecordedSession(t *testing.T, service *sheets.Service) [][]interface{} {
+	aw := api.NewApiWrapperWithRetryPolicy(service, SpreadsheetID, "Sheet1", zaptest.NewLogger(t), api.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	ctx := context.Background()
+
+	_, err := aw.BatchUpdate(ctx, []*sheets.ValueRange{{Range: "B2", Values: [][]interface{}{{"23"}}}})
+	assert.NoError(t, err)
+	vr, err := aw.GetRange(ctx, "A2:C")
+	assert.NoError(t, err)
+	resp, err := aw.BatchGetRanges(ctx, []string{"A2:C2", "A4:C4"})
+	if assert.NoError(t, err) && assert.Len(t, resp.ValueRanges, 2) {
+		return append(vr.Values, append(resp.ValueRanges[0].Values, resp.ValueRanges[1].Values...)...)
+	}
+	return nil
+}
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	ctx := context.Background()
+
+	s := newTestSpreadsheet(t)
+	s.InjectFault(Fault{Method: "GetRange", Err: ServiceUnavailable(), Times: 1}) // the retry is recorded as well
+	srv := NewServer(s)
+	service, rec, err := NewRecordingService(ctx, path, option.WithEndpoint(srv.URL), option.WithAPIKey("secret-key"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	recorded := testRecordedSession(t, service)
+	assert.NoError(t, rec.Save())
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-key")
+
+	service, rec, err = NewReplayingService(ctx, path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 4, rec.Remaining())
+	assert.Equal(t, recorded, testRecordedSession(t, service))
+	assert.Equal(t, 0, rec.Remaining())
+
+	// everything was replayed already
+	_, err = service.Spreadsheets.Values.Get(SpreadsheetID, "Sheet1!A2:C").Do()
+	assert.ErrorContains(t, err, "no recorded interaction left")
+}
+
+// testHeaderTransport answers every request with the headers it got, and a cookie
+type testHeaderTransport struct{}
+
+func (testHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Set-Cookie", "session=secret-cookie")
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: http.NoBody, Request: req}, nil
+}
+
+func TestRecorder_Matching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	rec := NewRecorder(path, testHeaderTransport{})
+	client := &http.Client{Transport: rec}
+	for _, body := range []string{`{"a":1}`, `{"a":2}`, `{"a":1}`} {
+		req, err := http.NewRequest(http.MethodPost, "https://sheets.googleapis.com/v4/spreadsheets/x/values:batchUpdate?alt=json&access_token=secret-token", strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret-token")
+		resp, err := client.Do(req)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "session=secret-cookie", resp.Header.Get("Set-Cookie")) // only the fixture is redacted
+			_ = resp.Body.Close()
+		}
+	}
+	assert.NoError(t, rec.Save())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	testCases := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		wantErr bool
+	}{
+		{name: "match", method: http.MethodPost, url: "https://other.host/v4/spreadsheets/x/values:batchUpdate?access_token=other&alt=json", body: `{"a":2}`},
+		{name: "different body", method: http.MethodPost, url: "https://sheets.googleapis.com/v4/spreadsheets/x/values:batchUpdate?alt=json", body: `{"a":3}`, wantErr: true},
+		{name: "different path", method: http.MethodPost, url: "https://sheets.googleapis.com/v4/spreadsheets/y/values:batchUpdate?alt=json", body: `{"a":2}`, wantErr: true},
+		{name: "different query", method: http.MethodPost, url: "https://sheets.googleapis.com/v4/spreadsheets/x/values:batchUpdate?alt=proto", body: `{"a":2}`, wantErr: true},
+		{name: "different method", method: http.MethodPut, url: "https://sheets.googleapis.com/v4/spreadsheets/x/values:batchUpdate?alt=json", body: `{"a":2}`, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			replayer, err := NewReplayer(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			assert.NoError(t, err)
+			resp, err := (&http.Client{Transport: replayer}).Do(req)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, "REDACTED", resp.Header.Get("Set-Cookie"))
+				_ = resp.Body.Close()
+			}
+			assert.Equal(t, 2, replayer.Remaining())
+		})
+	}
+
+	t.Run("missing fixture", func(t *testing.T) {
+		_, err := NewReplayer(filepath.Join(t.TempDir(), "nope.json"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
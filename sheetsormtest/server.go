@@ -0,0 +1,317 @@
+package sheetsormtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+const spreadsheetsPath = "/v4/spreadsheets/"
+
+// Server emulates the Sheets v4 REST API over a Spreadsheet, so the real api.ApiWrapperImpl and the google client can be tested end to end.
+// It serves spreadsheets.get, spreadsheets.batchUpdate, values.get, values.batchGet, values.batchUpdate and values.append.
+// Written values are interpreted by the valueInputOption of the request, and reads always return the formatted values.
+// Faults of the spreadsheet are applied as well, errors are sent as the API would, including the Retry-After header.
+type Server struct {
+	*httptest.Server
+	s *Spreadsheet
+}
+
+// NewServer starts a server serving the spreadsheet, it must be closed after use
+func NewServer(s *Spreadsheet) *Server {
+	srv := &Server{s: s}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.serve))
+	return srv
+}
+
+// Service returns a client talking to the server
+func (srv *Server) Service(ctx context.Context) (*sheets.Service, error) {
+	return sheets.NewService(ctx, option.WithoutAuthentication(), option.WithEndpoint(srv.URL), option.WithHTTPClient(srv.Client()))
+}
+
+func (srv *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, spreadsheetsPath) {
+		writeError(w, notFound())
+		return
+	}
+	rest := strings.TrimPrefix(path, spreadsheetsPath)
+
+	i := strings.IndexAny(rest, "/:")
+	if i < 0 {
+		i = len(rest)
+	}
+	if rest[:i] != SpreadsheetID {
+		writeError(w, notFound())
+		return
+	}
+	rest = rest[i:]
+
+	var err error
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		err = srv.getSpreadsheet(w, r)
+	case rest == ":batchUpdate" && r.Method == http.MethodPost:
+		err = srv.batchUpdateSpreadsheet(w, r)
+	case rest == "/values:batchGet" && r.Method == http.MethodGet:
+		err = srv.batchGet(w, r)
+	case rest == "/values:batchUpdate" && r.Method == http.MethodPost:
+		err = srv.batchUpdate(w, r)
+	case strings.HasPrefix(rest, "/values/") && strings.HasSuffix(rest, ":append") && r.Method == http.MethodPost:
+		err = srv.append(w, r, strings.TrimSuffix(strings.TrimPrefix(rest, "/values/"), ":append"))
+	case strings.HasPrefix(rest, "/values/") && r.Method == http.MethodGet:
+		err = srv.get(w, r, strings.TrimPrefix(rest, "/values/"))
+	default:
+		err = notFound()
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (srv *Server) getSpreadsheet(w http.ResponseWriter, r *http.Request) error {
+	err := srv.s.begin(r.Context(), "GetSpreadsheet")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+	return writeJSON(w, srv.s.spreadsheet())
+}
+
+func (srv *Server) batchUpdateSpreadsheet(w http.ResponseWriter, r *http.Request) error {
+	var req sheets.BatchUpdateSpreadsheetRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return badRequest("Invalid JSON payload received. %s", err)
+	}
+	err = srv.s.begin(r.Context(), "BatchUpdateSpreadsheet")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+
+	resp, err := srv.s.batchUpdateSpreadsheet(&req)
+	if err != nil {
+		return err
+	}
+	if req.IncludeSpreadsheetInResponse {
+		resp.UpdatedSpreadsheet = srv.s.spreadsheet()
+	}
+	return writeJSON(w, resp)
+}
+
+func (srv *Server) get(w http.ResponseWriter, r *http.Request, escapedRange string) error {
+	range_, err := url.PathUnescape(escapedRange)
+	if err != nil {
+		return badRequest("Unable to parse range: %s", escapedRange)
+	}
+	err = srv.s.begin(r.Context(), "GetRange")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+
+	resp, err := srv.s.batchGet("", []string{range_})
+	if err != nil {
+		return err
+	}
+	vr, err := withMajorDimension(resp.ValueRanges[0], r.URL.Query().Get("majorDimension"))
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, vr)
+}
+
+func (srv *Server) batchGet(w http.ResponseWriter, r *http.Request) error {
+	err := srv.s.begin(r.Context(), "BatchGetRanges")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+
+	resp, err := srv.s.batchGet("", r.URL.Query()["ranges"])
+	if err != nil {
+		return err
+	}
+	for i, vr := range resp.ValueRanges {
+		resp.ValueRanges[i], err = withMajorDimension(vr, r.URL.Query().Get("majorDimension"))
+		if err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, resp)
+}
+
+func (srv *Server) batchUpdate(w http.ResponseWriter, r *http.Request) error {
+	var req sheets.BatchUpdateValuesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return badRequest("Invalid JSON payload received. %s", err)
+	}
+	err = checkValueInputOption(req.ValueInputOption)
+	if err != nil {
+		return err
+	}
+	err = srv.s.begin(r.Context(), "BatchUpdate")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+
+	resp, err := srv.s.batchUpdate("", req.Data, req.ValueInputOption)
+	if err != nil {
+		return err
+	}
+	if req.IncludeValuesInResponse {
+		for _, u := range resp.Responses {
+			u.UpdatedData, err = srv.updatedData(u.UpdatedRange)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writeJSON(w, resp)
+}
+
+func (srv *Server) append(w http.ResponseWriter, r *http.Request, escapedRange string) error {
+	range_, err := url.PathUnescape(escapedRange)
+	if err != nil {
+		return badRequest("Unable to parse range: %s", escapedRange)
+	}
+	var vr sheets.ValueRange
+	err = json.NewDecoder(r.Body).Decode(&vr)
+	if err != nil {
+		return badRequest("Invalid JSON payload received. %s", err)
+	}
+	query := r.URL.Query()
+	err = checkValueInputOption(query.Get("valueInputOption"))
+	if err != nil {
+		return err
+	}
+	err = srv.s.begin(r.Context(), "Append")
+	if err != nil {
+		return err
+	}
+	srv.s.mu.Lock()
+	defer srv.s.mu.Unlock()
+
+	resp, err := srv.s.appendValues("", range_, &vr, query.Get("valueInputOption"), query.Get("insertDataOption"))
+	if err != nil {
+		return err
+	}
+	if query.Get("includeValuesInResponse") == "true" {
+		resp.Updates.UpdatedData, err = srv.updatedData(resp.Updates.UpdatedRange)
+		if err != nil {
+			return err
+		}
+	}
+	return writeJSON(w, resp)
+}
+
+// updatedData reads back a range that was written, it must be called with the lock held
+func (srv *Server) updatedData(range_ string) (*sheets.ValueRange, error) {
+	resp, err := srv.s.batchGet("", []string{range_})
+	if err != nil {
+		return nil, err
+	}
+	return resp.ValueRanges[0], nil
+}
+
+func checkValueInputOption(option string) error {
+	if option != ValueInputUserEntered && option != ValueInputRaw {
+		return badRequest("Invalid valueInputOption: %q", option)
+	}
+	return nil
+}
+
+// withMajorDimension returns the values as columns if asked to
+func withMajorDimension(vr *sheets.ValueRange, majorDimension string) (*sheets.ValueRange, error) {
+	switch majorDimension {
+	case "", "ROWS":
+		return vr, nil
+	case "COLUMNS":
+		columns := transpose(vr.Values)
+		for i, col := range columns {
+			for j, val := range col {
+				if val == nil {
+					col[j] = ""
+				}
+			}
+			columns[i] = trimTrailingEmpty(col)
+		}
+		vr.MajorDimension = "COLUMNS"
+		vr.Values = columns
+		return vr, nil
+	default:
+		return nil, badRequest("Invalid majorDimension: %q", majorDimension)
+	}
+}
+
+func trimTrailingEmpty(vals []interface{}) []interface{} {
+	for len(vals) > 0 && vals[len(vals)-1] == "" {
+		vals = vals[:len(vals)-1]
+	}
+	return vals
+}
+
+func notFound() *googleapi.Error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: "Requested entity was not found."}
+}
+
+var statusNames = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+	http.StatusGatewayTimeout:      "DEADLINE_EXCEEDED",
+}
+
+// writeError sends the error the way the API does, errors other than *googleapi.Error are sent as internal errors
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		apiErr = &googleapi.Error{Code: http.StatusInternalServerError, Message: "Internal error encountered."}
+	}
+	for k, vals := range apiErr.Header {
+		for _, v := range vals {
+			w.Header().Add(k, v)
+		}
+	}
+	status, ok := statusNames[apiErr.Code]
+	if !ok {
+		status = "UNKNOWN"
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(apiErr.Code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    apiErr.Code,
+			"message": apiErr.Message,
+			"status":  status,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, _ = w.Write(data)
+	return nil
+}
@@ -0,0 +1,294 @@
+package sheetsormtest
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Spreadsheet, *sheets.Service) {
+	s := newTestSpreadsheet(t)
+	srv := NewServer(s)
+	t.Cleanup(srv.Close)
+
+	service, err := srv.Service(context.Background())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return s, service
+}
+
+func TestServer_ApiWrapper(t *testing.T) {
+	s, service := newTestServer(t)
+	policy := api.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	aw := api.NewApiWrapperWithRetryPolicy(service, SpreadsheetID, "Sheet1", zaptest.NewLogger(t), policy)
+	ctx := context.Background()
+
+	vr, err := aw.GetRange(ctx, "A2:C")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Sheet1!A2:C10", vr.Range)
+		assert.Equal(t, [][]interface{}{{"alice", "22"}, {}, {"carol", "", "x"}}, vr.Values)
+	}
+
+	resp, err := aw.BatchGetRanges(ctx, []string{"A3:C3", "A4:C4"})
+	if assert.NoError(t, err) && assert.Len(t, resp.ValueRanges, 2) {
+		assert.Nil(t, resp.ValueRanges[0].Values)
+		assert.Equal(t, [][]interface{}{{"carol", "", "x"}}, resp.ValueRanges[1].Values)
+	}
+
+	_, err = aw.BatchUpdate(ctx, []*sheets.ValueRange{{MajorDimension: "ROWS", Range: "A3:B3", Values: [][]interface{}{{"bob", "007"}}}})
+	assert.NoError(t, err)
+	values, err := s.Values("A3:B3")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"bob", "7"}}, values) // written as USER_ENTERED
+
+	ss, err := aw.GetSpreadsheet(ctx)
+	if assert.NoError(t, err) && assert.Len(t, ss.Sheets, 2) {
+		assert.Equal(t, "My sheet", ss.Sheets[1].Properties.Title)
+	}
+
+	t.Run("other sheet", func(t *testing.T) {
+		aw := api.NewApiWrapperWithRetryPolicy(service, SpreadsheetID, "My sheet", zaptest.NewLogger(t), policy)
+		_, err := aw.BatchUpdate(ctx, []*sheets.ValueRange{{Range: "B2", Values: [][]interface{}{{"hello"}}}})
+		assert.NoError(t, err)
+		values, err := s.Values("'My sheet'!A1:B2")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{}, {"", "hello"}}, values)
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		s.InjectFault(Fault{Method: "GetRange", Err: TooManyRequests(0), Times: 2})
+		before := s.Calls("GetRange")
+		vr, err := aw.GetRange(ctx, "A2")
+		if assert.NoError(t, err) {
+			assert.Equal(t, [][]interface{}{{"alice"}}, vr.Values)
+		}
+		assert.Equal(t, before+3, s.Calls("GetRange"))
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		s.InjectFault(Fault{Method: "BatchGetRanges", Err: TooManyRequests(time.Minute)})
+		defer s.ClearFaults()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second) // gives up right away, as the retry would be too late
+		defer cancel()
+		_, err := aw.BatchGetRanges(ctx, []string{"A2"})
+		assert.True(t, api.IsTooManyRequests(err))
+		retryAfter, ok := api.RetryAfter(err)
+		assert.True(t, ok)
+		assert.Equal(t, time.Minute, retryAfter)
+	})
+
+	t.Run("bad request not retried", func(t *testing.T) {
+		before := s.Calls("GetRange")
+		_, err := aw.GetRange(ctx, "A1:AA1")
+		var apiErr *googleapi.Error
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+			assert.Contains(t, apiErr.Message, "exceeds grid limits")
+		}
+		assert.Equal(t, before+1, s.Calls("GetRange"))
+	})
+
+	t.Run("unknown document", func(t *testing.T) {
+		aw := api.NewApiWrapperWithRetryPolicy(service, "nope", "", zaptest.NewLogger(t), policy)
+		_, err := aw.GetRange(ctx, "A1")
+		var apiErr *googleapi.Error
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusNotFound, apiErr.Code)
+		}
+	})
+}
+
+func TestServer_Append(t *testing.T) {
+	testCases := []struct {
+		name             string
+		insertDataOption string
+		range_           string
+		values           [][]interface{}
+		expectedTable    string
+		expectedUpdated  string
+		expectedRows     int64
+	}{
+		{
+			name:            "after the table",
+			range_:          "A1:C",
+			values:          [][]interface{}{{"dave", "44"}},
+			expectedTable:   "Sheet1!A1:C4",
+			expectedUpdated: "Sheet1!A5:B5",
+			expectedRows:    10,
+		},
+		{
+			name:             "insert rows",
+			insertDataOption: "INSERT_ROWS",
+			range_:           "A1:C",
+			values:           [][]interface{}{{"dave", "44"}, {"erin", "55"}},
+			expectedTable:    "Sheet1!A1:C4",
+			expectedUpdated:  "Sheet1!A5:B6",
+			expectedRows:     12,
+		},
+		{
+			name:            "grid expanded",
+			range_:          "E8",
+			values:          [][]interface{}{{"a"}, {"b"}, {"c"}, {"d"}},
+			expectedUpdated: "Sheet1!E8:E11",
+			expectedRows:    11,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, service := newTestServer(t)
+
+			call := service.Spreadsheets.Values.Append(SpreadsheetID, tc.range_, &sheets.ValueRange{Values: tc.values}).ValueInputOption("USER_ENTERED")
+			if tc.insertDataOption != "" {
+				call = call.InsertDataOption(tc.insertDataOption)
+			}
+			resp, err := call.Do()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tc.expectedTable, resp.TableRange)
+			assert.Equal(t, tc.expectedUpdated, resp.Updates.UpdatedRange)
+
+			ss, err := service.Spreadsheets.Get(SpreadsheetID).Do()
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedRows, ss.Sheets[0].Properties.GridProperties.RowCount)
+			}
+			values, err := s.Values(tc.expectedUpdated)
+			assert.NoError(t, err)
+			assert.Len(t, values, len(tc.values))
+		})
+	}
+
+	t.Run("value input option required", func(t *testing.T) {
+		_, service := newTestServer(t)
+		_, err := service.Spreadsheets.Values.Append(SpreadsheetID, "A1", &sheets.ValueRange{Values: [][]interface{}{{"x"}}}).Do()
+		var apiErr *googleapi.Error
+		if assert.ErrorAs(t, err, &apiErr) {
+			assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+		}
+	})
+}
+
+func TestServer_BatchUpdateSpreadsheet(t *testing.T) {
+	testCases := []struct {
+		name           string
+		requests       []*sheets.Request
+		expectedTitles []string
+		expectedGrid   [2]int64 // rows and columns of the first sheet
+		expectedValues [][]string
+		wantErr        bool
+	}{
+		{
+			name:           "add sheet",
+			requests:       []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Other"}}}, {AddSheet: &sheets.AddSheetRequest{}}},
+			expectedTitles: []string{"Sheet1", "My sheet", "Other", "Sheet4"},
+			expectedGrid:   [2]int64{10, 5},
+			expectedValues: [][]string{{"name", "age", "note"}, {"alice", "22"}, {}, {"carol", "", "x"}},
+		},
+		{
+			name:           "delete and rename",
+			requests:       []*sheets.Request{{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: 1}}, {UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{Properties: &sheets.SheetProperties{SheetId: 0, Title: "Records"}, Fields: "title"}}},
+			expectedTitles: []string{"Records"},
+			expectedGrid:   [2]int64{10, 5},
+			expectedValues: [][]string{{"name", "age", "note"}, {"alice", "22"}, {}, {"carol", "", "x"}},
+		},
+		{
+			name: "insert and delete rows",
+			requests: []*sheets.Request{
+				{InsertDimension: &sheets.InsertDimensionRequest{Range: &sheets.DimensionRange{SheetId: 0, Dimension: "ROWS", StartIndex: 1, EndIndex: 2}}},
+				{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{SheetId: 0, Dimension: "ROWS", StartIndex: 3, EndIndex: 4}}},
+			},
+			expectedTitles: []string{"Sheet1", "My sheet"},
+			expectedGrid:   [2]int64{10, 5},
+			expectedValues: [][]string{{"name", "age", "note"}, {}, {"alice", "22"}, {"carol", "", "x"}},
+		},
+		{
+			name: "columns",
+			requests: []*sheets.Request{
+				{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{SheetId: 0, Dimension: "COLUMNS", StartIndex: 1, EndIndex: 2}}},
+				{AppendDimension: &sheets.AppendDimensionRequest{SheetId: 0, Dimension: "COLUMNS", Length: 3}},
+			},
+			expectedTitles: []string{"Sheet1", "My sheet"},
+			expectedGrid:   [2]int64{10, 7},
+			expectedValues: [][]string{{"name", "note"}, {"alice"}, {}, {"carol", "x"}},
+		},
+		{
+			name: "resize",
+			requests: []*sheets.Request{
+				{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{Properties: &sheets.SheetProperties{SheetId: 0, GridProperties: &sheets.GridProperties{RowCount: 2, ColumnCount: 2}}, Fields: "gridProperties"}},
+			},
+			expectedTitles: []string{"Sheet1", "My sheet"},
+			expectedGrid:   [2]int64{2, 2},
+			expectedValues: [][]string{{"name", "age"}, {"alice", "22"}},
+		},
+		{
+			name: "atomic",
+			requests: []*sheets.Request{
+				{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Other"}}},
+				{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Other"}}},
+			},
+			wantErr:        true,
+			expectedTitles: []string{"Sheet1", "My sheet"},
+			expectedGrid:   [2]int64{10, 5},
+			expectedValues: [][]string{{"name", "age", "note"}, {"alice", "22"}, {}, {"carol", "", "x"}},
+		},
+		{
+			name:           "unsupported",
+			requests:       []*sheets.Request{{SortRange: &sheets.SortRangeRequest{}}},
+			wantErr:        true,
+			expectedTitles: []string{"Sheet1", "My sheet"},
+			expectedGrid:   [2]int64{10, 5},
+			expectedValues: [][]string{{"name", "age", "note"}, {"alice", "22"}, {}, {"carol", "", "x"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, service := newTestServer(t)
+
+			_, err := service.Spreadsheets.BatchUpdate(SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{Requests: tc.requests}).Do()
+			if tc.wantErr {
+				var apiErr *googleapi.Error
+				if assert.ErrorAs(t, err, &apiErr) {
+					assert.Equal(t, http.StatusBadRequest, apiErr.Code)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+
+			ss, err := service.Spreadsheets.Get(SpreadsheetID).Do()
+			if !assert.NoError(t, err) {
+				return
+			}
+			var titles []string
+			for _, sh := range ss.Sheets {
+				titles = append(titles, sh.Properties.Title)
+			}
+			assert.Equal(t, tc.expectedTitles, titles)
+			grid := ss.Sheets[0].Properties.GridProperties
+			assert.Equal(t, tc.expectedGrid, [2]int64{grid.RowCount, grid.ColumnCount})
+
+			resp, err := service.Spreadsheets.Values.Get(SpreadsheetID, ss.Sheets[0].Properties.Title).Do()
+			if assert.NoError(t, err) {
+				values, err := s.Values(resp.Range)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedValues, values)
+			}
+		})
+	}
+}
+
+func TestServer_MajorDimension(t *testing.T) {
+	_, service := newTestServer(t)
+	vr, err := service.Spreadsheets.Values.Get(SpreadsheetID, "A1:C4").MajorDimension("COLUMNS").Do()
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]interface{}{{"name", "alice", "", "carol"}, {"age", "22"}, {"note", "", "", "x"}}, vr.Values)
+	}
+}
@@ -0,0 +1,503 @@
+// Package sheetsormtest provides an in-memory fake of the Sheets API, to test code using sheetsorm without network access.
+package sheetsormtest
+
+import (
+	"context"
+	"fmt"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/pproj/sheetsorm/column"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// SpreadsheetID is the id of every fake spreadsheet
+	SpreadsheetID = "sheetsormtest"
+
+	// the size of a new sheet in Google Sheets
+	defaultRowCount    = 1000
+	defaultColumnCount = 26
+
+	ValueInputUserEntered = "USER_ENTERED"
+	ValueInputRaw         = "RAW"
+)
+
+// sheet is a single page of the spreadsheet, cells hold the formatted values, an empty string means an empty cell
+type sheet struct {
+	id       int64
+	title    string
+	rowCount int
+	colCount int
+	cells    [][]string // rows are grown on demand
+}
+
+func (sh *sheet) get(row, col int) string {
+	if row >= len(sh.cells) || col >= len(sh.cells[row]) {
+		return ""
+	}
+	return sh.cells[row][col]
+}
+
+func (sh *sheet) set(row, col int, val string) {
+	for len(sh.cells) <= row {
+		sh.cells = append(sh.cells, nil)
+	}
+	for len(sh.cells[row]) <= col {
+		sh.cells[row] = append(sh.cells[row], "")
+	}
+	sh.cells[row][col] = val
+}
+
+// Spreadsheet is an in-memory spreadsheet, that can be accessed through ApiWrapper instances. It is safe for concurrent use.
+// Values are stored the way Google Sheets displays them, reads return these formatted values, like the real API does by default.
+type Spreadsheet struct {
+	mu          sync.Mutex
+	sheets      []*sheet
+	nextSheetID int64
+
+	valueInputOption string
+	faults           []*Fault
+	calls            map[string]int
+}
+
+type SpreadsheetOption func(*Spreadsheet)
+
+// WithSheet adds a sheet with the given grid size, zero sizes default to the 1000 rows and 26 columns of a new Google sheet.
+// If this option is not used, the spreadsheet has a single sheet called "Sheet1".
+func WithSheet(title string, rowCount, columnCount int) SpreadsheetOption {
+	return func(s *Spreadsheet) {
+		if rowCount <= 0 {
+			rowCount = defaultRowCount
+		}
+		if columnCount <= 0 {
+			columnCount = defaultColumnCount
+		}
+		s.addSheet(title, rowCount, columnCount)
+	}
+}
+
+// WithValueInputOption sets how written values are interpreted, either ValueInputUserEntered (the default, also used by api.ApiWrapperImpl) or ValueInputRaw
+func WithValueInputOption(option string) SpreadsheetOption {
+	return func(s *Spreadsheet) {
+		s.valueInputOption = option
+	}
+}
+
+func NewSpreadsheet(opts ...SpreadsheetOption) *Spreadsheet {
+	s := &Spreadsheet{
+		valueInputOption: ValueInputUserEntered,
+		calls:            make(map[string]int),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	if len(s.sheets) == 0 {
+		WithSheet("Sheet1", 0, 0)(s)
+	}
+	return s
+}
+
+// ApiWrapper returns an api.ApiWrapper for a sheet of the spreadsheet, an empty title means the first sheet, just like with api.NewApiWrapper.
+// Pass it to sheetsorm.NewSheetWithWrapper to use the fake in place of the real API.
+func (s *Spreadsheet) ApiWrapper(sheetTitle string) *ApiWrapper {
+	return &ApiWrapper{s: s, sheet: sheetTitle}
+}
+
+// SetValues writes the values to the range as they are, without any interpretation. The range may be bound to a sheet, the first sheet is used otherwise.
+// It is meant to set up the contents of the spreadsheet, it does not count as a call, and faults are not injected.
+func (s *Spreadsheet) SetValues(range_ string, rows [][]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, r, err := s.resolve("", range_)
+	if err != nil {
+		return err
+	}
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = make([]interface{}, len(row))
+		for j, val := range row {
+			values[i][j] = val
+		}
+	}
+	_, err = s.write(sh, r, "ROWS", values, ValueInputRaw)
+	return err
+}
+
+// Values returns the values in the range the way GetRange would, but as strings. The range may be bound to a sheet, the first sheet is used otherwise.
+// It does not count as a call, and faults are not injected.
+func (s *Spreadsheet) Values(range_ string) ([][]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sh, r, err := s.resolve("", range_)
+	if err != nil {
+		return nil, err
+	}
+	vr := s.read(sh, r)
+	out := make([][]string, len(vr.Values))
+	for i, row := range vr.Values {
+		out[i] = make([]string, len(row))
+		for j, val := range row {
+			out[i][j] = val.(string)
+		}
+	}
+	return out, nil
+}
+
+// Calls returns the number of calls made to a method of the wrappers, including the failed ones
+func (s *Spreadsheet) Calls(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[method]
+}
+
+// addSheet adds a new sheet to the end, it must be called with the lock held
+func (s *Spreadsheet) addSheet(title string, rowCount, columnCount int) *sheet {
+	sh := &sheet{id: s.nextSheetID, title: title, rowCount: rowCount, colCount: columnCount}
+	s.nextSheetID++
+	s.sheets = append(s.sheets, sh)
+	return sh
+}
+
+// begin counts the call, and applies the faults matching it
+func (s *Spreadsheet) begin(ctx context.Context, method string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.mu.Lock()
+	s.calls[method]++
+	f := s.takeFault(method)
+	s.mu.Unlock()
+	return f.apply(ctx)
+}
+
+func (s *Spreadsheet) sheetByTitle(title string) *sheet {
+	if title == "" {
+		return s.sheets[0]
+	}
+	for _, sh := range s.sheets {
+		if sh.title == title {
+			return sh
+		}
+	}
+	return nil
+}
+
+// resolve finds the sheet of the range, and closes the open ends of it at the edge of the grid. defaultSheet is used if the range is not bound to a sheet.
+func (s *Spreadsheet) resolve(defaultSheet string, range_ string) (*sheet, a1Range, error) {
+	if sh := s.sheetByTitle(range_); range_ != "" && sh != nil && !strings.Contains(range_, "!") {
+		if _, _, isCellRef := parseCellRef(range_); !isCellRef {
+			// the range is a whole sheet
+			return sh, a1Range{sheet: sh.title, endRow: sh.rowCount - 1, endCol: sh.colCount - 1}, nil
+		}
+	}
+
+	r, err := parseA1(range_)
+	if err != nil {
+		return nil, r, badRequest("Unable to parse range: %s", range_)
+	}
+	if r.sheet == "" {
+		r.sheet = defaultSheet
+	}
+	sh := s.sheetByTitle(r.sheet)
+	if sh == nil {
+		return nil, r, badRequest("Unable to parse range: %s", range_)
+	}
+	r.sheet = sh.title
+
+	if r.endRow == open {
+		r.endRow = sh.rowCount - 1
+	}
+	if r.endCol == open {
+		r.endCol = sh.colCount - 1
+	}
+	if r.endRow >= sh.rowCount || r.endCol >= sh.colCount {
+		return nil, r, badRequest("Range (%s) exceeds grid limits. Max rows: %d, max columns: %d", r, sh.rowCount, sh.colCount)
+	}
+	return sh, r, nil
+}
+
+// spreadsheet returns the metadata of the spreadsheet, it must be called with the lock held
+func (s *Spreadsheet) spreadsheet() *sheets.Spreadsheet {
+	out := &sheets.Spreadsheet{
+		SpreadsheetId: SpreadsheetID,
+		Properties:    &sheets.SpreadsheetProperties{Title: SpreadsheetID},
+	}
+	for i, sh := range s.sheets {
+		out.Sheets = append(out.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{
+				SheetId:   sh.id,
+				Index:     int64(i),
+				Title:     sh.title,
+				SheetType: "GRID",
+				GridProperties: &sheets.GridProperties{
+					RowCount:    int64(sh.rowCount),
+					ColumnCount: int64(sh.colCount),
+				},
+			},
+		})
+	}
+	return out
+}
+
+// read returns the values in the range, trailing empty rows, and trailing empty cells of each row are omitted, just like the real API does
+func (s *Spreadsheet) read(sh *sheet, r a1Range) *sheets.ValueRange {
+	var values [][]interface{}
+	lastNonEmpty := -1
+	for row := r.startRow; row <= r.endRow && row < len(sh.cells); row++ {
+		vals := make([]interface{}, 0)
+		lastCell := -1
+		for col := r.startCol; col <= r.endCol; col++ {
+			val := sh.get(row, col)
+			vals = append(vals, val)
+			if val != "" {
+				lastCell = len(vals) - 1
+			}
+		}
+		values = append(values, vals[:lastCell+1])
+		if lastCell >= 0 {
+			lastNonEmpty = len(values) - 1
+		}
+	}
+
+	vr := &sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Range:          r.String(),
+	}
+	if lastNonEmpty >= 0 {
+		vr.Values = values[:lastNonEmpty+1]
+	}
+	return vr
+}
+
+// write checks that the values fit the range, and writes them, nil values leave the cell as it is
+func (s *Spreadsheet) write(sh *sheet, r a1Range, majorDimension string, values [][]interface{}, valueInputOption string) (*sheets.UpdateValuesResponse, error) {
+	switch majorDimension {
+	case "", "ROWS":
+	case "COLUMNS":
+		values = transpose(values)
+	default:
+		return nil, badRequest("Invalid value at 'data.major_dimension' (%s)", majorDimension)
+	}
+
+	var cols, cells int
+	for i, row := range values {
+		if r.startRow+i > r.endRow {
+			return nil, badRequest("Requested writing within range [%s], but tried writing to row [%d]", r, r.startRow+i+1)
+		}
+		if r.startCol+len(row)-1 > r.endCol {
+			return nil, badRequest("Requested writing within range [%s], but tried writing to column [%s]", r, column.ColFromIndex(r.endCol+1))
+		}
+		cols = max(cols, len(row))
+		cells += len(row)
+	}
+
+	for i, row := range values {
+		for j, val := range row {
+			if val == nil {
+				continue
+			}
+			sh.set(r.startRow+i, r.startCol+j, formatValue(val, valueInputOption))
+		}
+	}
+
+	updated := a1Range{sheet: r.sheet, startRow: r.startRow, startCol: r.startCol, endRow: r.startRow + max(len(values), 1) - 1, endCol: r.startCol + max(cols, 1) - 1}
+	return &sheets.UpdateValuesResponse{
+		SpreadsheetId:  SpreadsheetID,
+		UpdatedRange:   updated.String(),
+		UpdatedRows:    int64(len(values)),
+		UpdatedColumns: int64(cols),
+		UpdatedCells:   int64(cells),
+	}, nil
+}
+
+// batchGet reads the ranges, it must be called with the lock held
+func (s *Spreadsheet) batchGet(defaultSheet string, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	out := &sheets.BatchGetValuesResponse{
+		SpreadsheetId: SpreadsheetID,
+		ValueRanges:   make([]*sheets.ValueRange, len(ranges)),
+	}
+	for i, range_ := range ranges {
+		sh, r, err := s.resolve(defaultSheet, range_)
+		if err != nil {
+			return nil, err
+		}
+		out.ValueRanges[i] = s.read(sh, r)
+	}
+	return out, nil
+}
+
+// batchUpdate writes the values, if any of them can't be written, nothing is. It must be called with the lock held.
+func (s *Spreadsheet) batchUpdate(defaultSheet string, values []*sheets.ValueRange, valueInputOption string) (*sheets.BatchUpdateValuesResponse, error) {
+	// check the ranges first, and keep the cells of the sheets touched, to restore them if a write fails
+	sheetsBefore := make(map[*sheet][][]string)
+	for _, vr := range values {
+		sh, _, err := s.resolve(defaultSheet, vr.Range)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sheetsBefore[sh]; !ok {
+			sheetsBefore[sh] = copyCells(sh.cells)
+		}
+	}
+
+	out := &sheets.BatchUpdateValuesResponse{SpreadsheetId: SpreadsheetID}
+	for _, vr := range values {
+		sh, r, _ := s.resolve(defaultSheet, vr.Range)
+		resp, err := s.write(sh, r, vr.MajorDimension, vr.Values, valueInputOption)
+		if err != nil {
+			for sh, cells := range sheetsBefore {
+				sh.cells = cells
+			}
+			return nil, err
+		}
+		out.Responses = append(out.Responses, resp)
+		out.TotalUpdatedRows += resp.UpdatedRows
+		out.TotalUpdatedColumns += resp.UpdatedColumns
+		out.TotalUpdatedCells += resp.UpdatedCells
+	}
+	out.TotalUpdatedSheets = int64(len(sheetsBefore))
+	return out, nil
+}
+
+func transpose(values [][]interface{}) [][]interface{} {
+	var out [][]interface{}
+	for col, vals := range values {
+		for row, val := range vals {
+			for len(out) <= row {
+				out = append(out, nil)
+			}
+			for len(out[row]) <= col {
+				out[row] = append(out[row], nil)
+			}
+			out[row][col] = val
+		}
+	}
+	return out
+}
+
+var numberRe = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// formatValue returns how a written value is displayed.
+// With USER_ENTERED, numbers and booleans are parsed and formatted, and a leading apostrophe keeps a value as text.
+// Formulas, dates, currencies and percentages are not interpreted, they are stored as they are.
+func formatValue(val interface{}, valueInputOption string) string {
+	switch v := val.(type) {
+	case string:
+		if valueInputOption != ValueInputUserEntered {
+			return v
+		}
+		if strings.HasPrefix(v, "'") {
+			return v[1:]
+		}
+		if numberRe.MatchString(v) {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+		}
+		if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+			return strings.ToUpper(v)
+		}
+		return v
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func badRequest(format string, args ...interface{}) *googleapi.Error {
+	return &googleapi.Error{
+		Code:    http.StatusBadRequest,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// ApiWrapper implements api.ApiWrapper on a sheet of a Spreadsheet
+type ApiWrapper struct {
+	s     *Spreadsheet
+	sheet string
+}
+
+var _ api.ApiWrapper = (*ApiWrapper)(nil)
+
+// checkUnbound panics on ranges bound to a sheet, just like api.ApiWrapperImpl does
+func checkUnbound(range_ string) {
+	if strings.Contains(range_, "!") {
+		panic("the range must be unbound from a sheet")
+	}
+}
+
+func (aw *ApiWrapper) GetSpreadsheet(ctx context.Context) (*sheets.Spreadsheet, error) {
+	err := aw.s.begin(ctx, "GetSpreadsheet")
+	if err != nil {
+		return nil, err
+	}
+	aw.s.mu.Lock()
+	defer aw.s.mu.Unlock()
+
+	return aw.s.spreadsheet(), nil
+}
+
+func (aw *ApiWrapper) GetRange(ctx context.Context, range_ string) (*sheets.ValueRange, error) {
+	checkUnbound(range_)
+	err := aw.s.begin(ctx, "GetRange")
+	if err != nil {
+		return nil, err
+	}
+	aw.s.mu.Lock()
+	defer aw.s.mu.Unlock()
+
+	sh, r, err := aw.s.resolve(aw.sheet, range_)
+	if err != nil {
+		return nil, err
+	}
+	return aw.s.read(sh, r), nil
+}
+
+func (aw *ApiWrapper) BatchGetRanges(ctx context.Context, ranges []string) (*sheets.BatchGetValuesResponse, error) {
+	for _, r := range ranges {
+		checkUnbound(r)
+	}
+	err := aw.s.begin(ctx, "BatchGetRanges")
+	if err != nil {
+		return nil, err
+	}
+	aw.s.mu.Lock()
+	defer aw.s.mu.Unlock()
+
+	return aw.s.batchGet(aw.sheet, ranges)
+}
+
+// BatchUpdate writes the values, if any of the ranges is invalid, nothing is written
+func (aw *ApiWrapper) BatchUpdate(ctx context.Context, values []*sheets.ValueRange) (*sheets.BatchUpdateValuesResponse, error) {
+	for _, vr := range values {
+		checkUnbound(vr.Range)
+	}
+	err := aw.s.begin(ctx, "BatchUpdate")
+	if err != nil {
+		return nil, err
+	}
+	aw.s.mu.Lock()
+	defer aw.s.mu.Unlock()
+
+	return aw.s.batchUpdate(aw.sheet, values, aw.s.valueInputOption)
+}
+
+func copyCells(cells [][]string) [][]string {
+	out := make([][]string, len(cells))
+	for i, row := range cells {
+		out[i] = append([]string(nil), row...)
+	}
+	return out
+}
@@ -0,0 +1,312 @@
+package sheetsormtest
+
+import (
+	"context"
+	"errors"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestSpreadsheet(t *testing.T) *Spreadsheet {
+	s := NewSpreadsheet(WithSheet("Sheet1", 10, 5), WithSheet("My sheet", 0, 0))
+	assert.NoError(t, s.SetValues("A1:C5", [][]string{
+		{"name", "age", "note"},
+		{"alice", "22"},
+		{},
+		{"carol", "", "x"},
+		{"", "", ""},
+	}))
+	return s
+}
+
+func assertStatus(t *testing.T, code int, err error) {
+	var apiErr *googleapi.Error
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, code, apiErr.Code)
+	}
+}
+
+func TestApiWrapper_GetRange(t *testing.T) {
+	testCases := []struct {
+		name          string
+		sheet         string
+		range_        string
+		expectedRange string
+		expected      [][]interface{}
+		expectedCode  int
+	}{
+		{
+			name:          "trailing cells and rows trimmed",
+			range_:        "A2:C",
+			expectedRange: "Sheet1!A2:C10",
+			expected:      [][]interface{}{{"alice", "22"}, {}, {"carol", "", "x"}},
+		},
+		{
+			name:          "column",
+			range_:        "A2:A",
+			expectedRange: "Sheet1!A2:A10",
+			expected:      [][]interface{}{{"alice"}, {}, {"carol"}},
+		},
+		{
+			name:          "single cell",
+			range_:        "B2",
+			expectedRange: "Sheet1!B2",
+			expected:      [][]interface{}{{"22"}},
+		},
+		{
+			name:          "empty",
+			range_:        "A3:C3",
+			expectedRange: "Sheet1!A3:C3",
+			expected:      nil,
+		},
+		{
+			name:          "other sheet",
+			sheet:         "My sheet",
+			range_:        "A1:B2",
+			expectedRange: "'My sheet'!A1:B2",
+			expected:      nil,
+		},
+		{
+			name:         "exceeds grid",
+			range_:       "A1:F1",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid",
+			range_:       "A1:?",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "no such sheet",
+			sheet:        "Nope",
+			range_:       "A1",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			aw := newTestSpreadsheet(t).ApiWrapper(tc.sheet)
+
+			vr, err := aw.GetRange(context.Background(), tc.range_)
+			if tc.expectedCode != 0 {
+				assertStatus(t, tc.expectedCode, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedRange, vr.Range)
+				assert.Equal(t, tc.expected, vr.Values)
+			}
+		})
+	}
+}
+
+func TestApiWrapper_BatchGetRanges(t *testing.T) {
+	s := newTestSpreadsheet(t)
+	aw := s.ApiWrapper("")
+
+	resp, err := aw.BatchGetRanges(context.Background(), []string{"A4:C4", "A3:C3", "A2:B2"})
+	if assert.NoError(t, err) && assert.Len(t, resp.ValueRanges, 3) {
+		assert.Equal(t, [][]interface{}{{"carol", "", "x"}}, resp.ValueRanges[0].Values)
+		assert.Nil(t, resp.ValueRanges[1].Values)
+		assert.Equal(t, [][]interface{}{{"alice", "22"}}, resp.ValueRanges[2].Values)
+	}
+
+	_, err = aw.BatchGetRanges(context.Background(), []string{"A1", "A11"})
+	assertStatus(t, http.StatusBadRequest, err)
+	assert.Equal(t, 2, s.Calls("BatchGetRanges"))
+}
+
+func TestApiWrapper_BatchUpdate(t *testing.T) {
+	testCases := []struct {
+		name             string
+		valueInputOption string
+		values           []*sheets.ValueRange
+		expected         [][]string
+		expectedCells    int64
+		expectedCode     int
+	}{
+		{
+			name: "user entered",
+			values: []*sheets.ValueRange{
+				{Range: "A2:C2", Values: [][]interface{}{{"bob", "007", "true"}}},
+				{Range: "A3", Values: [][]interface{}{{"'007"}}},
+				{Range: "B3:C3", Values: [][]interface{}{{1.50, false}}},
+			},
+			expected:      [][]string{{"bob", "7", "TRUE"}, {"007", "1.5", "FALSE"}, {"carol", "", "x"}},
+			expectedCells: 6,
+		},
+		{
+			name:             "raw",
+			valueInputOption: ValueInputRaw,
+			values: []*sheets.ValueRange{
+				{Range: "A2:C2", Values: [][]interface{}{{"bob", "007", "true"}}},
+			},
+			expected:      [][]string{{"bob", "007", "true"}, {}, {"carol", "", "x"}},
+			expectedCells: 3,
+		},
+		{
+			name: "clear and skip",
+			values: []*sheets.ValueRange{
+				{Range: "A4:C4", Values: [][]interface{}{{"", nil, ""}}},
+			},
+			expected:      [][]string{{"alice", "22"}},
+			expectedCells: 3,
+		},
+		{
+			name: "columns",
+			values: []*sheets.ValueRange{
+				{Range: "C2:C3", MajorDimension: "COLUMNS", Values: [][]interface{}{{"a", "b"}}},
+			},
+			expected:      [][]string{{"alice", "22", "a"}, {"", "", "b"}, {"carol", "", "x"}},
+			expectedCells: 2,
+		},
+		{
+			name: "too many columns",
+			values: []*sheets.ValueRange{
+				{Range: "A2", Values: [][]interface{}{{"bob"}}},
+				{Range: "A3", Values: [][]interface{}{{"dave", "33"}}},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "too many rows",
+			values: []*sheets.ValueRange{
+				{Range: "A2:B2", Values: [][]interface{}{{"bob"}, {"dave"}}},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "exceeds grid",
+			values: []*sheets.ValueRange{
+				{Range: "A2", Values: [][]interface{}{{"bob"}}},
+				{Range: "F2", Values: [][]interface{}{{"bob"}}},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestSpreadsheet(t)
+			if tc.valueInputOption != "" {
+				WithValueInputOption(tc.valueInputOption)(s)
+			}
+			aw := s.ApiWrapper("Sheet1")
+
+			resp, err := aw.BatchUpdate(context.Background(), tc.values)
+			if tc.expectedCode != 0 {
+				assertStatus(t, tc.expectedCode, err)
+				// nothing is written
+				tc.expected = [][]string{{"alice", "22"}, {}, {"carol", "", "x"}}
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedCells, resp.TotalUpdatedCells)
+				assert.Len(t, resp.Responses, len(tc.values))
+			}
+
+			values, err := s.Values("A2:C")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, values)
+		})
+	}
+}
+
+func TestApiWrapper_GetSpreadsheet(t *testing.T) {
+	ss, err := newTestSpreadsheet(t).ApiWrapper("").GetSpreadsheet(context.Background())
+	if assert.NoError(t, err) && assert.Len(t, ss.Sheets, 2) {
+		assert.Equal(t, "Sheet1", ss.Sheets[0].Properties.Title)
+		assert.Equal(t, int64(10), ss.Sheets[0].Properties.GridProperties.RowCount)
+		assert.Equal(t, "My sheet", ss.Sheets[1].Properties.Title)
+		assert.Equal(t, int64(1000), ss.Sheets[1].Properties.GridProperties.RowCount)
+		assert.Equal(t, int64(26), ss.Sheets[1].Properties.GridProperties.ColumnCount)
+	}
+}
+
+func TestApiWrapper_BoundRangePanics(t *testing.T) {
+	aw := newTestSpreadsheet(t).ApiWrapper("")
+	assert.Panics(t, func() {
+		_, _ = aw.GetRange(context.Background(), "Sheet1!A1")
+	})
+}
+
+func TestSpreadsheet_InjectFault(t *testing.T) {
+	t.Run("times", func(t *testing.T) {
+		s := newTestSpreadsheet(t)
+		s.InjectFault(Fault{Method: "GetRange", Err: TooManyRequests(2 * time.Second), Times: 2})
+		aw := s.ApiWrapper("")
+
+		_, err := aw.BatchGetRanges(context.Background(), []string{"A1"})
+		assert.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err = aw.GetRange(context.Background(), "A1")
+			assert.True(t, api.IsTooManyRequests(err))
+			retryAfter, ok := api.RetryAfter(err)
+			assert.True(t, ok)
+			assert.Equal(t, 2*time.Second, retryAfter)
+		}
+
+		_, err = aw.GetRange(context.Background(), "A1")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, s.Calls("GetRange"))
+	})
+
+	t.Run("until cleared", func(t *testing.T) {
+		s := newTestSpreadsheet(t)
+		s.InjectFault(Fault{Err: ServiceUnavailable()})
+		aw := s.ApiWrapper("")
+
+		_, err := aw.GetSpreadsheet(context.Background())
+		assert.True(t, api.IsServiceUnavailable(err))
+		_, err = aw.BatchUpdate(context.Background(), []*sheets.ValueRange{{Range: "A2", Values: [][]interface{}{{"bob"}}}})
+		assert.True(t, api.IsServiceUnavailable(err))
+
+		s.ClearFaults()
+		_, err = aw.GetSpreadsheet(context.Background())
+		assert.NoError(t, err)
+
+		values, err := s.Values("A2")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"alice"}}, values)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		s := newTestSpreadsheet(t)
+		s.InjectFault(Fault{Latency: 50 * time.Millisecond})
+		aw := s.ApiWrapper("")
+
+		start := time.Now()
+		_, err := aw.GetRange(context.Background(), "A1")
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		_, err = aw.GetRange(ctx, "A1")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		s := newTestSpreadsheet(t)
+		s.InjectFault(Fault{Method: "GetRange", Err: TooManyRequests(0), Times: 2})
+		aw := s.ApiWrapper("")
+
+		var vr *sheets.ValueRange
+		policy := api.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+		err := policy.Do(context.Background(), zap.NewNop(), func() error {
+			var err error
+			vr, err = aw.GetRange(context.Background(), "A2")
+			return err
+		})
+		if assert.NoError(t, err) {
+			assert.Equal(t, [][]interface{}{{"alice"}}, vr.Values)
+		}
+		assert.Equal(t, 3, s.Calls("GetRange"))
+	})
+}
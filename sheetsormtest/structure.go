@@ -0,0 +1,299 @@
+package sheetsormtest
+
+import (
+	"fmt"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+	"slices"
+	"strings"
+)
+
+// insertDimension inserts empty rows or columns before start
+func (sh *sheet) insertDimension(dimension string, start, n int) error {
+	switch dimension {
+	case "ROWS":
+		if start < 0 || start > sh.rowCount || n < 1 {
+			return badRequest("insertDimension: range [%d, %d) is out of bounds, the sheet has %d rows", start, start+n, sh.rowCount)
+		}
+		if start < len(sh.cells) {
+			sh.cells = slices.Insert(sh.cells, start, make([][]string, n)...)
+		}
+		sh.rowCount += n
+	case "COLUMNS":
+		if start < 0 || start > sh.colCount || n < 1 {
+			return badRequest("insertDimension: range [%d, %d) is out of bounds, the sheet has %d columns", start, start+n, sh.colCount)
+		}
+		for i, row := range sh.cells {
+			if start < len(row) {
+				sh.cells[i] = slices.Insert(row, start, make([]string, n)...)
+			}
+		}
+		sh.colCount += n
+	default:
+		return badRequest("Invalid dimension: %q", dimension)
+	}
+	return nil
+}
+
+// deleteDimension deletes the rows or columns in [start, end)
+func (sh *sheet) deleteDimension(dimension string, start, end int) error {
+	switch dimension {
+	case "ROWS":
+		if start < 0 || end > sh.rowCount || start >= end {
+			return badRequest("deleteDimension: range [%d, %d) is out of bounds, the sheet has %d rows", start, end, sh.rowCount)
+		}
+		if end-start == sh.rowCount {
+			return badRequest("deleteDimension: You can't delete all the rows on the sheet.")
+		}
+		if start < len(sh.cells) {
+			sh.cells = slices.Delete(sh.cells, start, min(end, len(sh.cells)))
+		}
+		sh.rowCount -= end - start
+	case "COLUMNS":
+		if start < 0 || end > sh.colCount || start >= end {
+			return badRequest("deleteDimension: range [%d, %d) is out of bounds, the sheet has %d columns", start, end, sh.colCount)
+		}
+		if end-start == sh.colCount {
+			return badRequest("deleteDimension: You can't delete all the columns on the sheet.")
+		}
+		for i, row := range sh.cells {
+			if start < len(row) {
+				sh.cells[i] = slices.Delete(row, start, min(end, len(row)))
+			}
+		}
+		sh.colCount -= end - start
+	default:
+		return badRequest("Invalid dimension: %q", dimension)
+	}
+	return nil
+}
+
+// resize changes the size of the grid, cells outside the new size are dropped
+func (sh *sheet) resize(rowCount, colCount int) error {
+	if rowCount < 1 || colCount < 1 {
+		return badRequest("Invalid grid size: %d rows, %d columns", rowCount, colCount)
+	}
+	if len(sh.cells) > rowCount {
+		sh.cells = sh.cells[:rowCount]
+	}
+	for i, row := range sh.cells {
+		if len(row) > colCount {
+			sh.cells[i] = row[:colCount]
+		}
+	}
+	sh.rowCount, sh.colCount = rowCount, colCount
+	return nil
+}
+
+func (s *Spreadsheet) sheetByID(id int64) (*sheet, error) {
+	for _, sh := range s.sheets {
+		if sh.id == id {
+			return sh, nil
+		}
+	}
+	return nil, badRequest("No grid with id: %d", id)
+}
+
+func (s *Spreadsheet) checkTitleFree(title string) error {
+	if s.sheetByTitle(title) != nil {
+		return badRequest("A sheet with the name %q already exists. Please enter another name.", title)
+	}
+	return nil
+}
+
+// copySheets returns a deep copy of the sheets, to restore them if a batch update fails
+func (s *Spreadsheet) copySheets() []*sheet {
+	out := make([]*sheet, len(s.sheets))
+	for i, sh := range s.sheets {
+		c := *sh
+		c.cells = copyCells(sh.cells)
+		out[i] = &c
+	}
+	return out
+}
+
+// batchUpdateSpreadsheet applies structural changes, either all of them or none. It must be called with the lock held.
+// Only adding, deleting, renaming and resizing sheets, and inserting, appending and deleting rows and columns are supported.
+func (s *Spreadsheet) batchUpdateSpreadsheet(req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	before, nextSheetID := s.copySheets(), s.nextSheetID
+
+	out := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: SpreadsheetID}
+	for i, r := range req.Requests {
+		reply, err := s.applyRequest(r)
+		if err != nil {
+			s.sheets, s.nextSheetID = before, nextSheetID
+			return nil, badRequest("Invalid requests[%d]: %s", i, err.(*googleapi.Error).Message)
+		}
+		out.Replies = append(out.Replies, reply)
+	}
+	return out, nil
+}
+
+func (s *Spreadsheet) applyRequest(r *sheets.Request) (*sheets.Response, error) {
+	switch {
+	case r.AddSheet != nil:
+		props := r.AddSheet.Properties
+		if props == nil {
+			props = &sheets.SheetProperties{}
+		}
+		title := props.Title
+		if title == "" {
+			for n := len(s.sheets) + 1; title == "" || s.sheetByTitle(title) != nil; n++ {
+				title = fmt.Sprintf("Sheet%d", n)
+			}
+		}
+		if err := s.checkTitleFree(title); err != nil {
+			return nil, err
+		}
+		rowCount, colCount := defaultRowCount, defaultColumnCount
+		if grid := props.GridProperties; grid != nil && grid.RowCount > 0 {
+			rowCount = int(grid.RowCount)
+		}
+		if grid := props.GridProperties; grid != nil && grid.ColumnCount > 0 {
+			colCount = int(grid.ColumnCount)
+		}
+		s.addSheet(title, rowCount, colCount)
+		return &sheets.Response{AddSheet: &sheets.AddSheetResponse{Properties: s.spreadsheet().Sheets[len(s.sheets)-1].Properties}}, nil
+
+	case r.DeleteSheet != nil:
+		sh, err := s.sheetByID(r.DeleteSheet.SheetId)
+		if err != nil {
+			return nil, err
+		}
+		if len(s.sheets) == 1 {
+			return nil, badRequest("deleteSheet: You can't remove all the sheets in a document.")
+		}
+		s.sheets = slices.DeleteFunc(s.sheets, func(other *sheet) bool { return other == sh })
+		return &sheets.Response{}, nil
+
+	case r.UpdateSheetProperties != nil:
+		props := r.UpdateSheetProperties.Properties
+		if props == nil {
+			return nil, badRequest("updateSheetProperties: properties are required")
+		}
+		sh, err := s.sheetByID(props.SheetId)
+		if err != nil {
+			return nil, err
+		}
+		rowCount, colCount := sh.rowCount, sh.colCount
+		for _, field := range strings.Split(r.UpdateSheetProperties.Fields, ",") {
+			field = strings.TrimSpace(field)
+			grid := props.GridProperties
+			if grid == nil {
+				grid = &sheets.GridProperties{}
+			}
+			switch field {
+			case "title", "*":
+				if props.Title != sh.title {
+					if err = s.checkTitleFree(props.Title); err != nil {
+						return nil, err
+					}
+					sh.title = props.Title
+				}
+				if field == "title" {
+					continue
+				}
+				rowCount, colCount = int(grid.RowCount), int(grid.ColumnCount)
+			case "gridProperties":
+				rowCount, colCount = int(grid.RowCount), int(grid.ColumnCount)
+			case "gridProperties.rowCount":
+				rowCount = int(grid.RowCount)
+			case "gridProperties.columnCount":
+				colCount = int(grid.ColumnCount)
+			default:
+				return nil, badRequest("updateSheetProperties: the field %q is not supported by sheetsormtest", field)
+			}
+		}
+		return &sheets.Response{}, sh.resize(rowCount, colCount)
+
+	case r.AppendDimension != nil:
+		sh, err := s.sheetByID(r.AppendDimension.SheetId)
+		if err != nil {
+			return nil, err
+		}
+		start := sh.rowCount
+		if r.AppendDimension.Dimension == "COLUMNS" {
+			start = sh.colCount
+		}
+		return &sheets.Response{}, sh.insertDimension(r.AppendDimension.Dimension, start, int(r.AppendDimension.Length))
+
+	case r.InsertDimension != nil && r.InsertDimension.Range != nil:
+		dr := r.InsertDimension.Range
+		sh, err := s.sheetByID(dr.SheetId)
+		if err != nil {
+			return nil, err
+		}
+		return &sheets.Response{}, sh.insertDimension(dr.Dimension, int(dr.StartIndex), int(dr.EndIndex-dr.StartIndex))
+
+	case r.DeleteDimension != nil && r.DeleteDimension.Range != nil:
+		dr := r.DeleteDimension.Range
+		sh, err := s.sheetByID(dr.SheetId)
+		if err != nil {
+			return nil, err
+		}
+		return &sheets.Response{}, sh.deleteDimension(dr.Dimension, int(dr.StartIndex), int(dr.EndIndex))
+
+	default:
+		return nil, badRequest("the request is not supported by sheetsormtest")
+	}
+}
+
+// appendValues writes the values after the last row with data in the range, the grid is expanded if needed. It must be called with the lock held.
+func (s *Spreadsheet) appendValues(defaultSheet string, range_ string, vr *sheets.ValueRange, valueInputOption string, insertDataOption string) (*sheets.AppendValuesResponse, error) {
+	sh, r, err := s.resolve(defaultSheet, range_)
+	if err != nil {
+		return nil, err
+	}
+	if vr.MajorDimension != "" && vr.MajorDimension != "ROWS" && vr.MajorDimension != "COLUMNS" {
+		return nil, badRequest("Invalid value at 'data.major_dimension' (%s)", vr.MajorDimension)
+	}
+
+	// find the table, which is the rows from the start of the range until the last one that has data in the columns of the range
+	lastRow, lastCol := -1, -1
+	for row := r.startRow; row < len(sh.cells); row++ {
+		for col := r.startCol; col <= r.endCol; col++ {
+			if sh.get(row, col) != "" {
+				lastRow = row
+				lastCol = max(lastCol, col)
+			}
+		}
+	}
+	out := &sheets.AppendValuesResponse{SpreadsheetId: SpreadsheetID}
+	if lastRow >= 0 {
+		out.TableRange = a1Range{sheet: sh.title, startRow: r.startRow, startCol: r.startCol, endRow: lastRow, endCol: lastCol}.String()
+	}
+
+	values := vr.Values
+	if vr.MajorDimension == "COLUMNS" {
+		values = transpose(values)
+	}
+	var width int
+	for _, row := range values {
+		width = max(width, len(row))
+	}
+	target := max(lastRow+1, r.startRow)
+	height := max(len(values), 1)
+
+	switch insertDataOption {
+	case "", "OVERWRITE":
+		if target+height > sh.rowCount {
+			sh.rowCount = target + height
+		}
+	case "INSERT_ROWS":
+		if err = sh.insertDimension("ROWS", target, height); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, badRequest("Invalid insertDataOption: %q", insertDataOption)
+	}
+	if r.startCol+width > sh.colCount {
+		sh.colCount = r.startCol + width
+	}
+
+	dest := a1Range{sheet: sh.title, startRow: target, startCol: r.startCol, endRow: target + height - 1, endCol: r.startCol + max(width, 1) - 1}
+	out.Updates, err = s.write(sh, dest, "ROWS", values, valueInputOption)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
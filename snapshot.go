@@ -0,0 +1,218 @@
+package sheetsorm
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/errors"
+	"go.uber.org/zap"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// typeSnapshot holds every row of the sheet that is a valid record of a specific type
+type typeSnapshot struct {
+	toolkit  *sheetsToolkit
+	rows     []rowData      // in the order they are in the sheet
+	byUID    map[string]int // uid -> index in rows
+	byRowNum map[int]int    // rowNum -> index in rows
+}
+
+func newTypeSnapshot(toolkit *sheetsToolkit, rows []rowData) *typeSnapshot {
+	ts := &typeSnapshot{toolkit: toolkit, rows: rows}
+	ts.reindex()
+	return ts
+}
+
+// reindex drops rows without uid, and rebuilds the lookup maps
+func (ts *typeSnapshot) reindex() {
+	ts.rows = slices.DeleteFunc(ts.rows, func(r rowData) bool {
+		return r.data[ts.toolkit.uidCol] == ""
+	})
+	ts.byUID = make(map[string]int, len(ts.rows))
+	ts.byRowNum = make(map[int]int, len(ts.rows))
+	for i, r := range ts.rows {
+		ts.byUID[r.data[ts.toolkit.uidCol]] = i // the last one wins, the same way as uidsToRowNums does it
+		ts.byRowNum[r.rowNum] = i
+	}
+}
+
+// snapshot keeps the entire sheet in memory, so reads can be served without any API calls.
+// Snapshots of every record type used are refreshed together with a single API call, so all of them have the same age.
+// Row data maps stored in the snapshot are never modified, updates replace them, so they can be passed around freely.
+type snapshot struct {
+	mu              sync.Mutex
+	refreshInterval time.Duration // 0 means the snapshot is only refreshed on demand
+	takenAt         time.Time
+	types           map[string]*typeSnapshot
+
+	now func() time.Time // replaced in tests
+}
+
+func newSnapshot(refreshInterval time.Duration) *snapshot {
+	return &snapshot{
+		refreshInterval: refreshInterval,
+		types:           make(map[string]*typeSnapshot),
+		now:             time.Now,
+	}
+}
+
+// refreshLocked reloads the snapshot of every record type, if it fails, the previous snapshot is kept
+func (s *snapshot) refreshLocked(ctx context.Context) error {
+	if len(s.types) == 0 {
+		return nil // nothing to load yet
+	}
+
+	namespaces := slices.Sorted(maps.Keys(s.types))
+	ranges := make([]string, len(namespaces))
+	var toolkit *sheetsToolkit
+	for i, ns := range namespaces {
+		toolkit = s.types[ns].toolkit
+		ranges[i] = toolkit.allRecordsRange()
+	}
+
+	takenAt := s.now() // the data can not be newer than the time we requested it
+	vals, err := toolkit.aw.BatchGetRanges(ctx, ranges)
+	if err != nil {
+		toolkit.logger.Error("Failed to refresh snapshot", zap.Error(err), zap.Strings("ranges", ranges))
+		return err
+	}
+
+	for i, vr := range vals.ValueRanges { // the order of the ValueRanges is the same as the order of the requested ranges
+		tk := s.types[namespaces[i]].toolkit
+		rows := make([]rowData, 0, len(vr.Values))
+		for j, val := range vr.Values {
+			rows = append(rows, rowData{rowNum: j + tk.skipRows + 1, data: tk.translateFullRowToMap(val)})
+		}
+		s.types[namespaces[i]] = newTypeSnapshot(tk, rows)
+	}
+	s.takenAt = takenAt
+
+	toolkit.logger.Debug("Snapshot refreshed", zap.Int("types", len(namespaces)), zap.Time("takenAt", takenAt))
+	return nil
+}
+
+// refresh reloads the snapshot of every record type that was used so far
+func (s *snapshot) refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshLocked(ctx)
+}
+
+// typeSnapshotLocked returns the snapshot for the record type of the toolkit, it loads or refreshes the snapshot if needed
+func (s *snapshot) typeSnapshotLocked(ctx context.Context, toolkit *sheetsToolkit) (*typeSnapshot, error) {
+	ts, ok := s.types[toolkit.typeKey]
+	if !ok {
+		// a new record type is loaded along with every other, so all of them have the same age
+		s.types[toolkit.typeKey] = newTypeSnapshot(toolkit, nil)
+		err := s.refreshLocked(ctx)
+		if err != nil {
+			delete(s.types, toolkit.typeKey)
+			return nil, err
+		}
+		return s.types[toolkit.typeKey], nil
+	}
+
+	if s.refreshInterval > 0 && s.now().Sub(s.takenAt) >= s.refreshInterval {
+		err := s.refreshLocked(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ts = s.types[toolkit.typeKey]
+	}
+
+	return ts, nil
+}
+
+// getRecordData is the snapshot counterpart of sheetsToolkit.getRecordData
+func (s *snapshot) getRecordData(ctx context.Context, toolkit *sheetsToolkit, uid string) (map[string]string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, err := s.typeSnapshotLocked(ctx, toolkit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	idx, ok := ts.byUID[uid]
+	if !ok {
+		return nil, 0, errors.ErrRecordNotFound
+	}
+
+	row := ts.rows[idx]
+	return row.data, row.rowNum, nil
+}
+
+// getAllRecordsData is the snapshot counterpart of sheetsToolkit.getAllRecordsData
+func (s *snapshot) getAllRecordsData(ctx context.Context, toolkit *sheetsToolkit) (<-chan rowData, error) {
+	s.mu.Lock()
+	ts, err := s.typeSnapshotLocked(ctx, toolkit)
+	var rows []rowData
+	if err == nil {
+		rows = ts.rows // the slice is replaced, not modified by updates
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	outChan := make(chan rowData)
+	go func() {
+		defer close(outChan)
+		for _, row := range rows {
+			select {
+			case outChan <- row:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return outChan, nil
+}
+
+// update stores the data read back after an update in the snapshot, so it is visible without a refresh.
+// Every record type that has the row gets the columns it shares with the updated data. Rows that were not valid records of a type before
+// are only added to the snapshot of the type that was updated, others have to wait for the next refresh.
+func (s *snapshot) update(toolkit *sheetsToolkit, updatedData []map[string]string, rowNums []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.types) == 0 {
+		return
+	}
+
+	for typeKey, ts := range s.types {
+		rows := slices.Clone(ts.rows)
+		for i, rowNum := range rowNums {
+			idx, ok := ts.byRowNum[rowNum]
+			if !ok {
+				if typeKey == toolkit.typeKey {
+					rows = append(rows, rowData{rowNum: rowNum, data: updatedData[i]})
+				}
+				continue
+			}
+
+			newData := maps.Clone(rows[idx].data)
+			for _, col := range ts.toolkit.cols {
+				if val, ok := updatedData[i][col]; ok {
+					newData[col] = val
+				}
+			}
+			rows[idx] = rowData{rowNum: rowNum, data: newData}
+		}
+		slices.SortFunc(rows, func(a, b rowData) int {
+			return a.rowNum - b.rowNum
+		})
+
+		s.types[typeKey] = newTypeSnapshot(ts.toolkit, rows)
+	}
+}
+
+// takenAtTime returns the time the snapshot was taken, the second return value is false if it was not loaded yet
+func (s *snapshot) takenAtTime() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.takenAt, !s.takenAt.IsZero()
+}
@@ -0,0 +1,158 @@
+package sheetsorm
+
+import (
+	"context"
+	"errors"
+	"github.com/pproj/sheetsorm/api"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/sheets/v4"
+	"testing"
+	"time"
+)
+
+func newTestSnapshotSheet(t *testing.T, aw api.ApiWrapper, refreshInterval time.Duration) (*SheetImpl, *time.Time) {
+	si := newTestSheet(t, aw, 1)
+	si.snapshot = newSnapshot(refreshInterval)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	si.snapshot.now = func() time.Time {
+		return now
+	}
+	return si, &now
+}
+
+func testSnapshotValues(rows ...[]interface{}) *sheets.BatchGetValuesResponse {
+	return &sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{{MajorDimension: "ROWS", Values: rows}},
+	}
+}
+
+func TestSnapshot_Reads(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B"}).Return(testSnapshotValues(
+		[]interface{}{"alice", "22"},
+		[]interface{}{},
+		[]interface{}{"bob", "33"},
+	), nil).Once()
+
+	si, now := newTestSnapshotSheet(t, m, 0)
+	ctx := context.Background()
+
+	_, ok := si.SnapshotTakenAt()
+	assert.False(t, ok)
+
+	record := testSheetRecord{Name: "bob"}
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, testSheetRecord{Name: "bob", Age: 33}, record)
+
+	takenAt, ok := si.SnapshotTakenAt()
+	assert.True(t, ok)
+	assert.Equal(t, *now, takenAt)
+
+	*now = now.Add(time.Hour) // no interval set, so it should not be refreshed
+
+	var records []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &records))
+	assert.Equal(t, []testSheetRecord{{Name: "alice", Age: 22}, {Name: "bob", Age: 33}}, records)
+
+	err := si.GetRecord(ctx, &testSheetRecord{Name: "carol"})
+	assert.ErrorIs(t, err, e.ErrRecordNotFound)
+
+	m.AssertExpectations(t) // loaded only once
+}
+
+func TestSnapshot_RefreshInterval(t *testing.T) {
+	testErr := errors.New("hello")
+
+	m := &api.MockApiWrapper{}
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B"}).Return(testSnapshotValues(
+		[]interface{}{"alice", "22"},
+	), nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B"}).Return((*sheets.BatchGetValuesResponse)(nil), testErr).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B"}).Return(testSnapshotValues(
+		[]interface{}{"alice", "23"},
+	), nil).Once()
+
+	si, now := newTestSnapshotSheet(t, m, time.Minute)
+	ctx := context.Background()
+	start := *now
+
+	record := testSheetRecord{Name: "alice"}
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, 22, record.Age)
+
+	*now = now.Add(59 * time.Second)
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, 22, record.Age)
+
+	*now = now.Add(time.Second)
+	assert.ErrorIs(t, si.GetRecord(ctx, &record), testErr)
+	takenAt, _ := si.SnapshotTakenAt()
+	assert.Equal(t, start, takenAt) // the old snapshot is kept
+
+	assert.NoError(t, si.Refresh(ctx))
+	takenAt, _ = si.SnapshotTakenAt()
+	assert.Equal(t, *now, takenAt)
+
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, 23, record.Age)
+
+	m.AssertExpectations(t)
+}
+
+func TestSnapshot_UpdateWritesThrough(t *testing.T) {
+	type nameOnly struct {
+		Name string `sheet:"A,uid"`
+	}
+
+	m := &api.MockApiWrapper{}
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:A"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{
+			{MajorDimension: "ROWS", Values: [][]interface{}{{"alice"}, {"bob"}}},
+		},
+	}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:A", "A2:B"}).Return(&sheets.BatchGetValuesResponse{ // a new type refreshes every type
+		ValueRanges: []*sheets.ValueRange{
+			{MajorDimension: "ROWS", Values: [][]interface{}{{"alice"}, {"bob"}, {"carol"}}},
+			{MajorDimension: "ROWS", Values: [][]interface{}{{"alice", "22"}, {"bob", "33"}, {"carol", "44"}}},
+		},
+	}, nil).Once()
+	m.On("GetRange", mock.Anything, "A2:A").Return(&sheets.ValueRange{
+		MajorDimension: "ROWS",
+		Values:         [][]interface{}{{"alice"}, {"bob"}, {"carol"}},
+	}, nil).Once()
+	m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A3:B3"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{{MajorDimension: "ROWS", Values: [][]interface{}{{"bob", "34"}}}},
+	}, nil).Once()
+
+	si, _ := newTestSnapshotSheet(t, m, 0)
+	ctx := context.Background()
+
+	var names []nameOnly
+	assert.NoError(t, si.GetAllRecords(ctx, &names))
+	assert.Equal(t, []nameOnly{{Name: "alice"}, {Name: "bob"}}, names)
+	assert.NoError(t, si.GetRecord(ctx, &testSheetRecord{Name: "bob"}))
+
+	updated := &testSheetRecord{Name: "bob", Age: 34}
+	assert.NoError(t, si.UpdateRecords(ctx, updated))
+
+	// served from the patched snapshot
+	record := testSheetRecord{Name: "bob"}
+	assert.NoError(t, si.GetRecord(ctx, &record))
+	assert.Equal(t, 34, record.Age)
+
+	names = nil
+	assert.NoError(t, si.GetAllRecords(ctx, &names))
+	assert.Equal(t, []nameOnly{{Name: "alice"}, {Name: "bob"}, {Name: "carol"}}, names)
+
+	m.AssertExpectations(t)
+}
+
+func TestSnapshot_RefreshWithoutSnapshotMode(t *testing.T) {
+	si := newTestSheet(t, &api.MockApiWrapper{}, 0)
+	assert.NoError(t, si.Refresh(context.Background()))
+	_, ok := si.SnapshotTakenAt()
+	assert.False(t, ok)
+}
@@ -2,6 +2,7 @@ package sheetsorm
 
 import (
 	"github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/typemagic"
 )
 
 type StructureConfig struct {
@@ -9,6 +10,9 @@ type StructureConfig struct {
 	Sheet string
 
 	SkipRows int
+
+	// EmptyCells is the sheet-wide policy for loading empty cells into fields that have no default= or emptyzero option
+	EmptyCells typemagic.EmptyCellPolicy
 }
 
 func (st StructureConfig) Validate() error {
@@ -18,5 +22,8 @@ func (st StructureConfig) Validate() error {
 	if st.DocID == "" {
 		return errors.ErrConfigInvalid
 	}
+	if !st.EmptyCells.IsValid() {
+		return errors.ErrConfigInvalid
+	}
 	return nil
 }
@@ -2,6 +2,7 @@ package sheetsorm
 
 import (
 	"github.com/pproj/sheetsorm/errors"
+	"github.com/pproj/sheetsorm/typemagic"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -39,6 +40,26 @@ func TestStructureConfig_Validate(t *testing.T) {
 			},
 			expectedErr: errors.ErrConfigInvalid,
 		},
+		{
+			name: "happy__empty_cells_zero",
+			sc: StructureConfig{
+				DocID:      "dummy_doc_id",
+				Sheet:      "dummy_sheet_name",
+				SkipRows:   12,
+				EmptyCells: typemagic.EmptyCellZero,
+			},
+			expectedErr: nil,
+		},
+		{
+			name: "error__invalid_empty_cells",
+			sc: StructureConfig{
+				DocID:      "dummy_doc_id",
+				Sheet:      "dummy_sheet_name",
+				SkipRows:   12,
+				EmptyCells: typemagic.EmptyCellPolicy(42),
+			},
+			expectedErr: errors.ErrConfigInvalid,
+		},
 		{
 			name: "error__skip_rows_negative",
 			sc: StructureConfig{
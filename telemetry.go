@@ -0,0 +1,153 @@
+package sheetsorm
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/pproj/sheetsorm/cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"reflect"
+)
+
+// attribute keys used in spans and metrics, along with the ones in the api package
+const (
+	attrRecordType = attribute.Key("sheetsorm.record_type")
+	attrRecords    = attribute.Key("sheetsorm.records")
+	attrCache      = attribute.Key("sheetsorm.cache")
+	attrCacheHit   = attribute.Key("sheetsorm.cache.hit")
+)
+
+// sheetTelemetry traces Sheet methods, and counts rows and cache lookups. A nil *sheetTelemetry does nothing.
+type sheetTelemetry struct {
+	tracer       trace.Tracer
+	attrs        []attribute.KeyValue // added to every span
+	rowsRead     metric.Int64Counter
+	rowsWritten  metric.Int64Counter
+	cacheLookups metric.Int64Counter
+
+	api *api.Telemetry // passed to the api wrapper
+}
+
+// newSheetTelemetry creates the instruments from the providers, nil providers are replaced with no-op ones
+func newSheetTelemetry(tp trace.TracerProvider, mp metric.MeterProvider, docID string, sheet string) (*sheetTelemetry, error) {
+	if tp == nil {
+		tp = tracenoop.NewTracerProvider()
+	}
+	if mp == nil {
+		mp = metricnoop.NewMeterProvider()
+	}
+	meter := mp.Meter(api.InstrumentationName)
+
+	rowsRead, err := meter.Int64Counter("sheetsorm.rows.read",
+		metric.WithDescription("Number of records loaded from the sheet"),
+		metric.WithUnit("{row}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	rowsWritten, err := meter.Int64Counter("sheetsorm.rows.written",
+		metric.WithDescription("Number of rows written to the sheet"),
+		metric.WithUnit("{row}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	cacheLookups, err := meter.Int64Counter("sheetsorm.cache.lookups",
+		metric.WithDescription("Number of cache lookups, by cache and outcome"),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	apiTelemetry, err := api.NewTelemetry(tp, mp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sheetTelemetry{
+		tracer:       tp.Tracer(api.InstrumentationName),
+		attrs:        []attribute.KeyValue{api.AttrDocID.String(docID), api.AttrSheet.String(sheet)},
+		rowsRead:     rowsRead,
+		rowsWritten:  rowsWritten,
+		cacheLookups: cacheLookups,
+		api:          apiTelemetry,
+	}, nil
+}
+
+// start starts a span for a Sheet method, the returned function ends it with the error passed
+func (t *sheetTelemetry) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	if t == nil {
+		return ctx, func(error) {}
+	}
+	attrs = append(attrs, t.attrs...)
+	ctx, span := t.tracer.Start(ctx, "sheetsorm.Sheet."+method, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (t *sheetTelemetry) addRowsRead(ctx context.Context, recordType string, n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.rowsRead.Add(ctx, int64(n), metric.WithAttributes(attrRecordType.String(recordType)))
+}
+
+func (t *sheetTelemetry) addRowsWritten(ctx context.Context, recordType string, n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.rowsWritten.Add(ctx, int64(n), metric.WithAttributes(attrRecordType.String(recordType)))
+}
+
+func (t *sheetTelemetry) cacheLookup(cacheName string, hit bool) {
+	if t == nil {
+		return
+	}
+	t.cacheLookups.Add(context.Background(), 1, metric.WithAttributes(attrCache.String(cacheName), attrCacheHit.Bool(hit)))
+}
+
+// recordTypeName returns the name of the record type behind pointers and slices, used as an attribute
+func recordTypeName(v interface{}) string {
+	typ := reflect.TypeOf(v)
+	for typ != nil && (typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice) {
+		typ = typ.Elem()
+	}
+	if typ == nil {
+		return ""
+	}
+	return typ.String()
+}
+
+// instrumentedUIDCache counts the lookups of a RowUIDCache
+type instrumentedUIDCache struct {
+	cache.RowUIDCache
+	telemetry *sheetTelemetry
+}
+
+func (c *instrumentedUIDCache) GetRowNumByUID(ns cache.Namespace, uid string) (int, bool) {
+	rowNum, ok := c.RowUIDCache.GetRowNumByUID(ns, uid)
+	c.telemetry.cacheLookup("uid", ok)
+	return rowNum, ok
+}
+
+// instrumentedRowCache counts the lookups of a RowCache
+type instrumentedRowCache struct {
+	cache.RowCache
+	telemetry *sheetTelemetry
+}
+
+func (c *instrumentedRowCache) GetRow(ns cache.Namespace, rowNum int) (map[string]string, bool) {
+	data, ok := c.RowCache.GetRow(ns, rowNum)
+	c.telemetry.cacheLookup("row", ok)
+	return data, ok
+}
@@ -0,0 +1,161 @@
+package sheetsorm
+
+import (
+	"context"
+	"github.com/pproj/sheetsorm/api"
+	"github.com/pproj/sheetsorm/cache"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/api/sheets/v4"
+	"sync"
+	"testing"
+)
+
+type testSpan struct {
+	tracenoop.Span
+	name   string
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+	ended  bool
+}
+
+func (s *testSpan) IsRecording() bool { return true }
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+func (s *testSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+func (s *testSpan) End(...trace.SpanEndOption)          { s.ended = true }
+
+type testTracerProvider struct {
+	tracenoop.TracerProvider
+	tracer testTracer
+}
+
+type testTracer struct {
+	tracenoop.Tracer
+	spans []*testSpan
+}
+
+func (p *testTracerProvider) Tracer(string, ...trace.TracerOption) trace.Tracer { return &p.tracer }
+
+func (p *testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	s := &testSpan{name: name, attrs: make(map[attribute.Key]attribute.Value)}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s.SetAttributes(cfg.Attributes()...)
+	p.spans = append(p.spans, s)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// testMeterProvider sums every counter by instrument name and attributes
+type testMeterProvider struct {
+	metricnoop.MeterProvider
+	meter testMeter
+}
+
+type testMeter struct {
+	metricnoop.Meter
+	mu   sync.Mutex
+	sums map[string]int64
+}
+
+type testCounter struct {
+	metricnoop.Int64Counter
+	name  string
+	meter *testMeter
+}
+
+func (c *testCounter) Add(_ context.Context, incr int64, opts ...metric.AddOption) {
+	cfg := metric.NewAddConfig(opts)
+	attrs := cfg.Attributes()
+	c.meter.mu.Lock()
+	defer c.meter.mu.Unlock()
+	c.meter.sums[c.name+"{"+attrs.Encoded(attribute.DefaultEncoder())+"}"] += incr
+}
+
+func (p *testMeterProvider) Meter(string, ...metric.MeterOption) metric.Meter { return &p.meter }
+
+func (m *testMeter) Int64Counter(name string, _ ...metric.Int64CounterOption) (metric.Int64Counter, error) {
+	return &testCounter{name: name, meter: m}, nil
+}
+
+func newTestTelemetrySheet(t *testing.T, aw api.ApiWrapper) (*SheetImpl, *testTracerProvider, *testMeterProvider) {
+	tp := &testTracerProvider{}
+	mp := &testMeterProvider{meter: testMeter{sums: make(map[string]int64)}}
+	telemetry, err := newSheetTelemetry(tp, mp, "doc", "Sheet1")
+	assert.NoError(t, err)
+
+	si := newTestSheet(t, aw, 1)
+	mc := cache.NewMemoryCache(cache.MemoryCacheConfig{})
+	si.telemetry = telemetry
+	si.uidCache = &instrumentedUIDCache{RowUIDCache: mc, telemetry: telemetry}
+	si.rowCache = &instrumentedRowCache{RowCache: mc, telemetry: telemetry}
+	return si, tp, mp
+}
+
+func TestSheetTelemetry(t *testing.T) {
+	m := &api.MockApiWrapper{}
+	m.On("GetRange", mock.Anything, "A2:A").Return(&sheets.ValueRange{Values: [][]interface{}{{"alice"}, {"bob"}}}, nil).Times(3)
+	m.On("GetRange", mock.Anything, "A2:B2").Return(&sheets.ValueRange{Values: [][]interface{}{{"alice", "22"}}}, nil).Once()
+	m.On("GetRange", mock.Anything, "A2:B").Return(&sheets.ValueRange{Values: [][]interface{}{{"alice", "22"}, {"bob", "33"}}}, nil).Once()
+	m.On("BatchUpdate", mock.Anything, mock.Anything).Return(&sheets.BatchUpdateValuesResponse{}, nil).Once()
+	m.On("BatchGetRanges", mock.Anything, []string{"A2:B2"}).Return(&sheets.BatchGetValuesResponse{
+		ValueRanges: []*sheets.ValueRange{{Values: [][]interface{}{{"alice", "23"}}}},
+	}, nil).Once()
+
+	si, tp, mp := newTestTelemetrySheet(t, m)
+	ctx := context.Background()
+
+	assert.NoError(t, si.GetRecord(ctx, &testSheetRecord{Name: "alice"}))
+	assert.NoError(t, si.GetRecord(ctx, &testSheetRecord{Name: "alice"})) // from the cache
+	var records []testSheetRecord
+	assert.NoError(t, si.GetAllRecords(ctx, &records))
+	assert.NoError(t, si.UpdateRecords(ctx, []*testSheetRecord{{Name: "alice", Age: 23}}))
+	assert.ErrorIs(t, si.GetRecord(ctx, &testSheetRecord{Name: "carol"}), e.ErrRecordNotFound)
+
+	var names []string
+	for _, s := range tp.tracer.spans {
+		names = append(names, s.name)
+		assert.True(t, s.ended)
+		assert.Equal(t, "Sheet1", s.attrs[api.AttrSheet].AsString())
+	}
+	assert.Equal(t, []string{
+		"sheetsorm.Sheet.GetRecord",
+		"sheetsorm.Sheet.GetRecord",
+		"sheetsorm.Sheet.GetAllRecords",
+		"sheetsorm.Sheet.UpdateRecords",
+		"sheetsorm.Sheet.GetRecord",
+	}, names)
+	assert.Equal(t, codes.Unset, tp.tracer.spans[0].status)
+	assert.Equal(t, "sheetsorm.testSheetRecord", tp.tracer.spans[0].attrs[attrRecordType].AsString())
+	assert.Equal(t, int64(1), tp.tracer.spans[3].attrs[attrRecords].AsInt64())
+	assert.Equal(t, codes.Error, tp.tracer.spans[4].status)
+
+	assert.Equal(t, map[string]int64{
+		"sheetsorm.rows.read{sheetsorm.record_type=sheetsorm.testSheetRecord}":    4,
+		"sheetsorm.rows.written{sheetsorm.record_type=sheetsorm.testSheetRecord}": 1,
+		"sheetsorm.cache.lookups{sheetsorm.cache=uid,sheetsorm.cache.hit=false}":  2, // the first alice, and carol
+		"sheetsorm.cache.lookups{sheetsorm.cache=uid,sheetsorm.cache.hit=true}":   1,
+		"sheetsorm.cache.lookups{sheetsorm.cache=row,sheetsorm.cache.hit=false}":  1,
+		"sheetsorm.cache.lookups{sheetsorm.cache=row,sheetsorm.cache.hit=true}":   1,
+	}, mp.meter.sums)
+	m.AssertExpectations(t)
+}
+
+func TestSheetTelemetryDisabled(t *testing.T) {
+	var telemetry *sheetTelemetry
+	ctx, end := telemetry.start(context.Background(), "GetRecord")
+	end(nil)
+	assert.False(t, trace.SpanFromContext(ctx).IsRecording())
+	telemetry.addRowsRead(ctx, "x", 1)
+	telemetry.addRowsWritten(ctx, "x", 1)
+	telemetry.cacheLookup("uid", true)
+}
@@ -12,20 +12,35 @@ import (
 	"slices"
 )
 
+// rowData is the data map of a single row, along with the number of that row
+type rowData struct {
+	rowNum int
+	data   map[string]string
+}
+
 // sheetsToolkit is a toolkit used internally to work with sheets. A toolkit is bound to a specific type of record in a specific sheet
 // The goal of toolkit is to separate record manipulation logic for sheet manipulation logic, therefore it uses only rowData maps for its functionality
 type sheetsToolkit struct {
 	aw api.ApiWrapper
 
 	// data
-	skipRows int
-	firstCol string
-	lastCol  string
-	colShift int
-	cols     []string
-	uidCol   string
+	skipRows   int
+	firstCol   string
+	lastCol    string
+	colShift   int
+	cols       []string
+	uidCol     string
+	versionCol string // empty if the record type has no version field
+	// normalizeCell maps a cell read from the sheet to the way the record type dumps it, nil leaves cells as they are
+	normalizeCell func(col string, cell string) string
+	dryRun        bool // writes are only planned, so nothing is read back after them
+
+	// typeKey identifies the record type within the sheet, for the snapshot and conflict detection
+	typeKey string
 
 	logger   *zap.Logger
+	uidNs    cache.Namespace
+	rowNs    cache.Namespace
 	uidCache cache.RowUIDCache
 	rowCache cache.RowCache
 }
@@ -36,6 +51,8 @@ func newToolkit(
 	uidCol string,
 	skipRows int,
 	logger *zap.Logger,
+	uidNs cache.Namespace,
+	rowNs cache.Namespace,
 	uidCache cache.RowUIDCache,
 	rowCache cache.RowCache,
 ) (*sheetsToolkit, error) {
@@ -54,6 +71,8 @@ func newToolkit(
 		uidCol:   uidCol,
 
 		logger:   logger,
+		uidNs:    uidNs,
+		rowNs:    rowNs,
 		uidCache: uidCache,
 		rowCache: rowCache,
 	}, nil
@@ -72,15 +91,39 @@ func (st *sheetsToolkit) uidsToRowNums(ctx context.Context, uids []string) ([]in
 		}
 	}
 
-	uidColRange := fmt.Sprintf("%[1]s%[2]d:%[1]s", st.uidCol, st.skipRows+1)
-	vals, err := st.aw.GetRange(ctx, uidColRange)
+	scanned, _, err := st.readUIDColumn(ctx)
 	if err != nil {
-		st.logger.Error("Failed to get uid column", zap.String("range", uidColRange), zap.Error(err))
 		return nil, err
 	}
 
 	rowNums := make([]int, len(uids))
+	for i, uid := range uids {
+		rowNum, ok := scanned[uid]
+		if ok {
+			st.logger.Debug("Translated uid to row num", zap.String("uid", uid), zap.Int("rowNum", rowNum))
+			rowNums[i] = rowNum
+		}
+	}
+
+	if slices.Contains(rowNums, 0) {
+		// 0 is not a valid row number in sheets, so if we found one, it means that one record could not be paired
+		return nil, errors.ErrRecordNotFound
+	}
+
+	return rowNums, nil
+}
+
+// readUIDColumn reads the whole uid column with a single API call, and returns the row number of each uid, along with the last row that has any value in it.
+// If a uid is present multiple times, the last one wins. It stores the received data in the cache, but does not do lookups to it.
+func (st *sheetsToolkit) readUIDColumn(ctx context.Context) (map[string]int, int, error) {
+	uidColRange := fmt.Sprintf("%[1]s%[2]d:%[1]s", st.uidCol, st.skipRows+1)
+	vals, err := st.aw.GetRange(ctx, uidColRange)
+	if err != nil {
+		st.logger.Error("Failed to get uid column", zap.String("range", uidColRange), zap.Error(err))
+		return nil, 0, err
+	}
 
+	scanned := make(map[string]int, len(vals.Values))
 	for rowI, row := range vals.Values { // the header is already skipped by the request
 		rowNum := rowI + 1 + st.skipRows // zero index correction plus skipped rows
 
@@ -97,26 +140,16 @@ func (st *sheetsToolkit) uidsToRowNums(ctx context.Context, uids []string) ([]in
 		}
 
 		// we have rowNum - rowUid pairs here, let's greedy cache them...
-		st.uidCache.CacheUID(rowUid, rowNum)
+		st.uidCache.CacheUID(st.uidNs, rowUid, rowNum)
+		scanned[rowUid] = rowNum
 
-		for i, uid := range uids {
-			if rowUid == uid {
-				st.logger.Debug("Translated uid to row num", zap.String("uid", uid), zap.Int("rowNum", rowNum))
-				rowNums[i] = rowNum
-			}
-			if ctx.Err() != nil { // context cancelled
-				return nil, ctx.Err()
-			}
+		if ctx.Err() != nil { // context cancelled
+			return nil, 0, ctx.Err()
 		}
-
-	}
-
-	if slices.Contains(rowNums, 0) {
-		// 0 is not a valid row number in sheets, so if we found one, it means that one record could not be paired
-		return nil, errors.ErrRecordNotFound
 	}
 
-	return rowNums, nil
+	// google omits empty rows from the end, so the last row returned is the last one used
+	return scanned, len(vals.Values) + st.skipRows, nil
 }
 
 // uidToRowNum resolves a single uid to a row number
@@ -167,10 +200,36 @@ func (st *sheetsToolkit) getDataMapFromRowNum(ctx context.Context, rowNum int) (
 	row := vals.Values[0]
 	rowData := st.translateFullRowToMap(row)
 
-	st.rowCache.CacheRow(rowNum, rowData)
+	st.rowCache.CacheRow(st.rowNs, rowNum, rowData)
 	return rowData, nil
 }
 
+// cachedRow looks up the row in the row cache. Rows are shared by every record type of the sheet,
+// so a row cached by another type is only a hit if it has all the columns of this one, and those are returned only.
+func (st *sheetsToolkit) cachedRow(rowNum int) (map[string]string, bool) {
+	row, ok := st.rowCache.GetRow(st.rowNs, rowNum)
+	if !ok || !rowHasCols(row, st.cols) {
+		return nil, false
+	}
+	if len(row) == len(st.cols) {
+		return row, true
+	}
+	out := make(map[string]string, len(st.cols))
+	for _, col := range st.cols {
+		out[col] = row[col]
+	}
+	return out, true
+}
+
+func rowHasCols(row map[string]string, cols []string) bool {
+	for _, col := range cols {
+		if _, ok := row[col]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // getDataMapsFromRowNums does store recieved data in cache, but does not do lookups against it.
 // (the reason for that is that we want to explicit control over when we want data from cache)
 func (st *sheetsToolkit) getDataMapsFromRowNums(ctx context.Context, rowNums []int) ([]map[string]string, error) {
@@ -190,35 +249,35 @@ func (st *sheetsToolkit) getDataMapsFromRowNums(ctx context.Context, rowNums []i
 	out := make([]map[string]string, len(rowNums))
 	for i, row := range vals.ValueRanges { // yay: The order of the ValueRanges is the same as the order of the requested ranges
 		out[i] = st.translateFullRowToMap(row.Values[0])
-		st.rowCache.CacheRow(rowNums[i], out[i])
+		st.rowCache.CacheRow(st.rowNs, rowNums[i], out[i])
 	}
 
 	return out, nil
 }
 
-// getRecordData first tries to look up data from caches, if it fails loads the data from the sheet
-func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[string]string, error) {
+// getRecordData first tries to look up data from caches, if it fails loads the data from the sheet. It returns the row number the data was found in as well.
+func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[string]string, int, error) {
 	var err error
 
-	rowNum, uidCacheHit := st.uidCache.GetRowNumByUID(uid)
+	rowNum, uidCacheHit := st.uidCache.GetRowNumByUID(st.uidNs, uid)
 	st.logger.Debug("uid cache lookup complete", zap.Int("cachedRowNum", rowNum), zap.Bool("cacheHit", uidCacheHit), zap.String("uid", uid))
 
 	if !uidCacheHit {
 		rowNum, err = st.uidToRowNum(ctx, uid)
 		if err != nil {
 			st.logger.Error("Failed to translate UID to row number", zap.Error(err), zap.String("uid", uid))
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
-	recordDataMap, rowCacheHit := st.rowCache.GetRow(rowNum)
+	recordDataMap, rowCacheHit := st.cachedRow(rowNum)
 	st.logger.Debug("row cache lookup complete", zap.Int("rowNum", rowNum), zap.Bool("cacheHit", rowCacheHit))
 
 	if !rowCacheHit {
 		recordDataMap, err = st.getDataMapFromRowNum(ctx, rowNum)
 		if err != nil {
 			st.logger.Error("Failed to get data for row", zap.Error(err), zap.String("uid", uid), zap.Int("rowNum", rowNum))
-			return nil, err
+			return nil, 0, err
 		}
 	}
 
@@ -231,7 +290,7 @@ func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[str
 		if !(uidCacheHit || rowCacheHit) {
 			// caches were not involved, the data returned is just bad...
 			st.logger.Error("The requested UID does not match the UID returned from the API", zap.String("uidRequested", uid), zap.String("uidReturned", uidOut))
-			return nil, errors.ErrInconsistentData
+			return nil, 0, errors.ErrInconsistentData
 		}
 
 		// Seems like there could be cache inconsistency, we drop all data and retry...
@@ -242,11 +301,11 @@ func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[str
 
 		// invalidate data
 		if uidCacheHit {
-			st.uidCache.InvalidateUID(uid)
-			st.uidCache.InvalidateUID(uidOut)
+			st.uidCache.InvalidateUID(st.uidNs, uid)
+			st.uidCache.InvalidateUID(st.uidNs, uidOut)
 		}
 		if rowCacheHit {
-			st.rowCache.InvalidateRow(rowNum)
+			st.rowCache.InvalidateRow(st.rowNs, rowNum)
 		}
 
 		// read data as fresh...
@@ -256,7 +315,7 @@ func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[str
 			rowNum, err = st.uidToRowNum(ctx, uid)
 			if err != nil {
 				st.logger.Error("Failed to translate UID to row number", zap.Error(err), zap.String("uid", uid))
-				return nil, err
+				return nil, 0, err
 			}
 		}
 
@@ -264,24 +323,29 @@ func (st *sheetsToolkit) getRecordData(ctx context.Context, uid string) (map[str
 		recordDataMap, err = st.getDataMapFromRowNum(ctx, rowNum)
 		if err != nil {
 			st.logger.Error("Failed to get data for row", zap.Error(err), zap.String("uid", uid), zap.Int("rowNum", rowNum))
-			return nil, err
+			return nil, 0, err
 		}
 
 		// check success one last time if still wrong, give up...
 		uidOut = recordDataMap[st.uidCol]
 		if uidOut != uid {
 			st.logger.Error("The requested UID does not match the UID returned from the API", zap.String("uidRequested", uid), zap.String("uidReturned", uidOut))
-			return nil, errors.ErrInconsistentData
+			return nil, 0, errors.ErrInconsistentData
 		}
 
 	}
 
-	return recordDataMap, nil
+	return recordDataMap, rowNum, nil
+}
+
+// allRecordsRange returns the range that covers every record in the sheet
+func (st *sheetsToolkit) allRecordsRange() string {
+	return fmt.Sprintf("%s%d:%s", st.firstCol, st.skipRows+1, st.lastCol)
 }
 
 // getAllRecordsData gets all records via a single API call, it does not look up data from cache, but updates it
-func (st *sheetsToolkit) getAllRecordsData(ctx context.Context) (<-chan map[string]string, error) {
-	rangeStr := fmt.Sprintf("%s%d:%s", st.firstCol, st.skipRows+1, st.lastCol)
+func (st *sheetsToolkit) getAllRecordsData(ctx context.Context) (<-chan rowData, error) {
+	rangeStr := st.allRecordsRange()
 
 	vals, err := st.aw.GetRange(ctx, rangeStr)
 	if err != nil {
@@ -289,7 +353,7 @@ func (st *sheetsToolkit) getAllRecordsData(ctx context.Context) (<-chan map[stri
 		return nil, err
 	}
 
-	outChan := make(chan map[string]string)
+	outChan := make(chan rowData)
 
 	go func() {
 		defer close(outChan)
@@ -305,12 +369,12 @@ func (st *sheetsToolkit) getAllRecordsData(ctx context.Context) (<-chan map[stri
 
 			if uid != "" {
 				// greedy caching of data...
-				st.rowCache.CacheRow(rowNum, dataMap)
-				st.uidCache.CacheUID(uid, rowNum)
+				st.rowCache.CacheRow(st.rowNs, rowNum, dataMap)
+				st.uidCache.CacheUID(st.uidNs, uid, rowNum)
 
 				st.logger.Debug("Passing a new row", zap.String("uid", uid))
 				c++
-				outChan <- dataMap
+				outChan <- rowData{rowNum: rowNum, data: dataMap}
 			}
 		}
 		st.logger.Debug("Done, passed all valid-looking rows.", zap.Int("count", c))
@@ -379,11 +443,11 @@ func (st *sheetsToolkit) translateRowDataToUpdateRanges(rowNum int, row map[stri
 }
 
 // updateRecords the uids should be a list of uids and the records should be the corresponding records, but omitting read-only fields, and fields don't wanted to be updated
-// this function does not modify data in-place, instead it returns the new data, in the same order it got it... TODO: consider using channels (some other funcs may need to be changed as well)
+// this function does not modify data in-place, instead it returns the new data and the row numbers of the records, in the same order it got it... TODO: consider using channels (some other funcs may need to be changed as well)
 // make sure there are no duplicates in the uids,...
 // Also this function drops related entries from the cache, so they could be refreshed there as well. It does not look up anything from cache.
 // This is a very taxing call, as it does at least 3 API calls each time it is called, and two of those calls are batch calls.
-func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, records []map[string]string) ([]map[string]string, error) {
+func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, records []map[string]string) ([]map[string]string, []int, error) {
 
 	// Resolve all uids to row numbers using a single API call, we don't want to use the cache here, because
 	// if we base our update call on stale data, that will cause headache
@@ -391,8 +455,146 @@ func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, recor
 	rowNums, err := st.uidsToRowNums(ctx, uids)
 	if err != nil {
 		st.logger.Error("Failure while resolving uids to row nums", zap.Error(err))
-		return nil, err
+		return nil, nil, err
+	}
+
+	return st.writeRecords(ctx, uids, rowNums, records)
+}
+
+// updateRecordsChecked is the same as updateRecords, but it reads the current rows first, and checks that they still hold the expected values.
+// expected holds the cells that must not have changed for each record, nil maps are not checked. If any of them changed, nothing is written,
+// and an *errors.ConflictError is returned. Note that the check and the write are separate API calls, so this narrows the window for conflicts, but can not close it.
+func (st *sheetsToolkit) updateRecordsChecked(ctx context.Context, uids []string, records []map[string]string, expected []map[string]string) ([]map[string]string, []int, error) {
+	rowNums, _, err := st.readCurrentRows(ctx, uids, expected)
+	if err != nil {
+		return nil, nil, err
+	}
+	return st.writeRecords(ctx, uids, rowNums, records)
+}
+
+// updateChangedCells is the same as updateRecordsChecked, but it writes only the cells that differ from the current rows.
+// It returns the rows as they were before the update as well. If nothing changed at all, then nothing is written,
+// and the data read before is returned as the updated data. The current rows are always read from the API, a stale cache could make us skip a write.
+func (st *sheetsToolkit) updateChangedCells(ctx context.Context, uids []string, records []map[string]string, expected []map[string]string) ([]map[string]string, []int, []map[string]string, error) {
+	rowNums, currentData, err := st.readCurrentRows(ctx, uids, expected)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	changedData := make([]map[string]string, len(records))
+	var changedCount int
+	for i, r := range records {
+		changedData[i] = st.diffRowData(currentData[i], r)
+		changedCount += len(changedData[i])
 	}
+	st.logger.Debug("Compared records with the current rows", zap.Int("changedCells", changedCount))
+
+	if changedCount == 0 {
+		return currentData, rowNums, currentData, nil
+	}
+
+	var updatedData []map[string]string
+	updatedData, rowNums, err = st.writeRecords(ctx, uids, rowNums, changedData)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return updatedData, rowNums, currentData, nil
+}
+
+// readCurrentRows resolves the uids and reads the rows fresh from the API, then checks them against the expected values (if not nil)
+func (st *sheetsToolkit) readCurrentRows(ctx context.Context, uids []string, expected []map[string]string) ([]int, []map[string]string, error) {
+	rowNums, err := st.uidsToRowNums(ctx, uids)
+	if err != nil {
+		st.logger.Error("Failure while resolving uids to row nums", zap.Error(err))
+		return nil, nil, err
+	}
+
+	var currentData []map[string]string
+	currentData, err = st.getDataMapsFromRowNums(ctx, rowNums)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = st.checkCurrentRows(uids, rowNums, currentData, expected)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rowNums, currentData, nil
+}
+
+// checkCurrentRows checks that the rows read still belong to the uids, and hold the expected values (if not nil).
+// It returns errors.ErrInconsistentData if a row moved, and an *errors.ConflictError if any of the expected cells differ.
+func (st *sheetsToolkit) checkCurrentRows(uids []string, rowNums []int, currentData []map[string]string, expected []map[string]string) error {
+	var conflicts []errors.Conflict
+	for i := range uids {
+		if currentData[i][st.uidCol] != uids[i] {
+			// the row moved between the two calls
+			st.logger.Error("The requested UID does not match the UID returned from the API", zap.String("uidRequested", uids[i]), zap.String("uidReturned", currentData[i][st.uidCol]))
+			return errors.ErrInconsistentData
+		}
+
+		if expected == nil || expected[i] == nil {
+			continue
+		}
+
+		var changedCols []string
+		for _, col := range st.cols {
+			val, ok := expected[i][col]
+			if ok && !st.cellEquals(col, val, currentData[i][col]) {
+				changedCols = append(changedCols, col)
+			}
+		}
+		if len(changedCols) > 0 {
+			conflicts = append(conflicts, errors.Conflict{UID: uids[i], Row: rowNums[i], Columns: changedCols, Current: currentData[i]})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		st.logger.Debug("Records were changed by someone else", zap.Int("conflicts", len(conflicts)))
+		return &errors.ConflictError{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+// cellEquals compares an expected cell with the current one, an empty version cell is the same as version 0
+func (st *sheetsToolkit) cellEquals(col string, expected string, current string) bool {
+	if col == st.versionCol && current == "" {
+		return expected == "" || expected == "0"
+	}
+	return expected == current
+}
+
+// diffRowData returns the cells of the record that differ from the current row. The current cells are normalized first, so values formatted
+// differently by the sheet do not count as changed. The version is only included if any other cell changed, as it is bumped for every update.
+func (st *sheetsToolkit) diffRowData(current map[string]string, record map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for col, val := range record {
+		if col == st.versionCol {
+			continue
+		}
+		if st.normalize(col, current[col]) != val {
+			changed[col] = val
+		}
+	}
+	if version, ok := record[st.versionCol]; ok && len(changed) > 0 {
+		changed[st.versionCol] = version
+	}
+	return changed
+}
+
+// normalize maps a cell read from the sheet to the way the record type dumps it
+func (st *sheetsToolkit) normalize(col string, cell string) string {
+	if st.normalizeCell == nil {
+		return cell
+	}
+	return st.normalizeCell(col, cell)
+}
+
+// writeRecords does the heavy lifting for updateRecords, once the row numbers are resolved
+func (st *sheetsToolkit) writeRecords(ctx context.Context, uids []string, rowNums []int, records []map[string]string) ([]map[string]string, []int, error) {
+	var err error
 
 	// Then group all updates as necessary
 	valRanges := make([]*sheets.ValueRange, 0)
@@ -408,15 +610,15 @@ func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, recor
 		// check if UID is needed to be dropped from the cache
 		newUID := r[st.uidCol]
 		oldUID := uids[i]
-		if newUID != "" && newUID != oldUID {
+		if newUID != "" && newUID != oldUID && !st.dryRun {
 			// There possibly will be an update in the UID column, so we might want these cache entries to be dropped
-			st.uidCache.InvalidateUID(newUID) // the new uid
-			st.uidCache.InvalidateUID(oldUID) // the old uid
+			st.uidCache.InvalidateUID(st.uidNs, newUID) // the new uid
+			st.uidCache.InvalidateUID(st.uidNs, oldUID) // the old uid
 			st.logger.Debug("Invalidated UIDs in cache", zap.Strings("uids", []string{newUID, oldUID}))
 		}
 
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
 		}
 	}
 	st.logger.Debug("Translated batch update to range updates", zap.Int("len(valRanges)", len(valRanges)))
@@ -424,20 +626,22 @@ func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, recor
 	if len(valRanges) == 0 {
 		// nothing to do
 		st.logger.Debug("nothing to update...")
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	// Before doing the actual update, drop all row cache data that would go stale
-	for _, rowNum := range rowNums {
-		st.rowCache.InvalidateRow(rowNum)
+	// Before doing the actual update, drop all row cache data that would go stale (nothing goes stale in a dry run)
+	if !st.dryRun {
+		for _, rowNum := range rowNums {
+			st.rowCache.InvalidateRow(st.rowNs, rowNum)
+		}
+		st.logger.Debug("Invalidated row data in cache", zap.Ints("rowNums", rowNums))
 	}
-	st.logger.Debug("Invalidated row data in cache", zap.Ints("rowNums", rowNums))
 
 	var resp *sheets.BatchUpdateValuesResponse
 	resp, err = st.aw.BatchUpdate(ctx, valRanges)
 	if err != nil {
 		st.logger.Error("Batch update failed", zap.Error(err))
-		return nil, err
+		return nil, nil, err
 	}
 
 	st.logger.Debug("Batch update completed, reading back data...",
@@ -446,5 +650,16 @@ func (st *sheetsToolkit) updateRecords(ctx context.Context, uids []string, recor
 		zap.Int64("TotalUpdatedColumns", resp.TotalUpdatedColumns),
 	)
 
-	return st.getDataMapsFromRowNums(ctx, rowNums) // see? we don't want to load stuff from cache,... even if it's invalidated, but we want to fill it up with the new values, which is done by this function automagically
+	if st.dryRun {
+		// nothing was written, reading back would only return the rows as they were
+		return records, rowNums, nil
+	}
+
+	var updatedData []map[string]string
+	updatedData, err = st.getDataMapsFromRowNums(ctx, rowNums) // see? we don't want to load stuff from cache,... even if it's invalidated, but we want to fill it up with the new values, which is done by this function automagically
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return updatedData, rowNums, nil
 }
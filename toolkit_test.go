@@ -14,6 +14,8 @@ import (
 	"testing"
 )
 
+var testUIDNs = cache.NewUIDNamespace("doc", "Sheet1", "A")
+
 func TestToolkit_uidsToRowNums(t *testing.T) {
 	testError := errors.New("hello")
 
@@ -234,10 +236,10 @@ func TestToolkit_uidsToRowNums(t *testing.T) {
 			var cacheCalled int
 
 			muic := &cache.MockRowUIDCache{}
-			muic.On("CacheUID", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			muic.On("CacheUID", testUIDNs, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				cacheCalled++
-				uid := args.String(0)
-				rowNum := args.Int(1)
+				uid := args.String(1)
+				rowNum := args.Int(2)
 				assert.Equal(t, tc.apiResult.Values[rowNum-tc.toolkitSkipRows-1][0], uid)
 			})
 
@@ -247,6 +249,7 @@ func TestToolkit_uidsToRowNums(t *testing.T) {
 				skipRows: tc.toolkitSkipRows,
 				uidCol:   tc.toolkitUidCol,
 				logger:   testLogger,
+				uidNs:    testUIDNs,
 				uidCache: muic,
 			}
 
@@ -0,0 +1,88 @@
+package typemagic
+
+import (
+	"fmt"
+	"github.com/pproj/sheetsorm/errors"
+	"reflect"
+	"sync"
+)
+
+// EncodeFunc converts a value to its cell representation. The value is never a pointer, it is the value the pointer field points to.
+type EncodeFunc func(value any) (string, error)
+
+// DecodeFunc parses a cell into a value, the returned value must be assignable (or convertible) to the type of the field
+type DecodeFunc func(cell string) (any, error)
+
+// Codec is a pair of functions used to load and dump types that can not implement any of the supported interfaces, like types of third-party packages
+type Codec struct {
+	Encode EncodeFunc
+	Decode DecodeFunc
+}
+
+var codecRegistryMu sync.RWMutex
+var typeCodecs = map[reflect.Type]Codec{}
+var namedCodecs = map[string]Codec{}
+
+// RegisterCodec registers a codec for the type, it is used for every field of this type (or pointer to this type) instead of the built-in conversions.
+// Registering a codec for the same type again replaces the previous one.
+func RegisterCodec(typ reflect.Type, encode EncodeFunc, decode DecodeFunc) {
+	if typ == nil || encode == nil || decode == nil {
+		panic("codec type, encode and decode must not be nil")
+	}
+	codecRegistryMu.Lock()
+	defer codecRegistryMu.Unlock()
+	typeCodecs[typ] = Codec{Encode: encode, Decode: decode}
+}
+
+// RegisterNamedCodec registers a codec that is used only for fields that select it with the codec= struct tag option.
+// Registering a codec with the same name again replaces the previous one.
+func RegisterNamedCodec(name string, encode EncodeFunc, decode DecodeFunc) {
+	if name == "" || encode == nil || decode == nil {
+		panic("codec name, encode and decode must not be empty")
+	}
+	codecRegistryMu.Lock()
+	defer codecRegistryMu.Unlock()
+	namedCodecs[name] = Codec{Encode: encode, Decode: decode}
+}
+
+// lookupCodec returns the codec to be used for the value, the codec selected by the tag takes priority over the codec registered for the type.
+// It returns an error only if the tag selects a codec that is not registered.
+func lookupCodec(typ reflect.Type, t Tag) (Codec, bool, error) {
+	codecRegistryMu.RLock()
+	defer codecRegistryMu.RUnlock()
+
+	if t.Codec != "" {
+		c, ok := namedCodecs[t.Codec]
+		if !ok {
+			return Codec{}, false, fmt.Errorf("%w: %s", errors.ErrUnknownCodec, t.Codec)
+		}
+		return c, true, nil
+	}
+
+	c, ok := typeCodecs[typ]
+	return c, ok, nil
+}
+
+// decodeAndStore stores the value returned by the codec in value
+func decodeAndStore(c Codec, value reflect.Value, data string) error {
+	decoded, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	decodedVal := reflect.ValueOf(decoded)
+	if !decodedVal.IsValid() {
+		value.Set(reflect.Zero(value.Type()))
+		return nil
+	}
+	if decodedVal.Type().AssignableTo(value.Type()) {
+		value.Set(decodedVal)
+		return nil
+	}
+	if decodedVal.Type().ConvertibleTo(value.Type()) {
+		value.Set(decodedVal.Convert(value.Type()))
+		return nil
+	}
+
+	return fmt.Errorf("%w: codec returned %s, which can not be stored in %s", errors.ErrInvalidType, decodedVal.Type(), value.Type())
+}
@@ -0,0 +1,100 @@
+package typemagic
+
+import (
+	"errors"
+	"fmt"
+	e "github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testCodecPoint struct {
+	X, Y int
+}
+
+type testCodecCelsius float64
+
+var testCodecErr = errors.New("invalid point")
+
+func init() {
+	RegisterCodec(reflect.TypeOf(testCodecPoint{}), func(value any) (string, error) {
+		p := value.(testCodecPoint)
+		return fmt.Sprintf("%d;%d", p.X, p.Y), nil
+	}, func(cell string) (any, error) {
+		var p testCodecPoint
+		_, err := fmt.Sscanf(cell, "%d;%d", &p.X, &p.Y)
+		if err != nil {
+			return nil, testCodecErr
+		}
+		return p, nil
+	})
+
+	RegisterNamedCodec("test_upper", func(value any) (string, error) {
+		return strings.ToUpper(fmt.Sprint(value)), nil
+	}, func(cell string) (any, error) {
+		return strings.ToLower(cell), nil
+	})
+
+	RegisterNamedCodec("test_celsius", func(value any) (string, error) {
+		return fmt.Sprintf("%v°C", value), nil
+	}, func(cell string) (any, error) {
+		var f float64
+		_, err := fmt.Sscanf(strings.TrimSuffix(cell, "°C"), "%g", &f)
+		return f, err // float64 is convertible to testCodecCelsius
+	})
+}
+
+func TestCodecs(t *testing.T) {
+	type testStruct struct {
+		Point    testCodecPoint   `sheet:"A"`
+		PointPtr *testCodecPoint  `sheet:"B"`
+		Name     string           `sheet:"C,codec=test_upper"`
+		Plain    string           `sheet:"D"`
+		Temp     testCodecCelsius `sheet:"E,codec=test_celsius"`
+	}
+
+	item := testStruct{
+		Point:    testCodecPoint{X: 1, Y: 2},
+		PointPtr: &testCodecPoint{X: 3, Y: 4},
+		Name:     "alma",
+		Plain:    "alma",
+		Temp:     21.5,
+	}
+	expectedDump := map[string]string{
+		"A": "1;2",
+		"B": "3;4",
+		"C": "ALMA",
+		"D": "alma",
+		"E": "21.5°C",
+	}
+
+	t.Run("dump", func(t *testing.T) {
+		result, err := DumpStruct(item, false)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedDump, result)
+	})
+
+	t.Run("load", func(t *testing.T) {
+		var loaded testStruct
+		assert.NoError(t, LoadIntoStruct(expectedDump, &loaded))
+		assert.Equal(t, item, loaded)
+	})
+
+	t.Run("load_error", func(t *testing.T) {
+		var loaded testStruct
+		err := LoadIntoStruct(map[string]string{"A": "alma"}, &loaded)
+		assert.ErrorIs(t, err, testCodecErr)
+	})
+
+	t.Run("unknown_named_codec", func(t *testing.T) {
+		type testStructUnknown struct {
+			Name string `sheet:"A,codec=test_does_not_exist"`
+		}
+		_, err := DumpStruct(testStructUnknown{Name: "alma"}, false)
+		assert.ErrorIs(t, err, e.ErrUnknownCodec)
+		err = LoadIntoStruct(map[string]string{"A": "alma"}, &testStructUnknown{})
+		assert.ErrorIs(t, err, e.ErrUnknownCodec)
+	})
+}
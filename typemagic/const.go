@@ -8,4 +8,24 @@ const (
 	SheetTagOptionTrueRepr      = "true="
 	SheetTagOptionFalseRepr     = "false="
 	SheetTagOptionUnknownIsTrue = "utrue"
+
+	SheetTagOptionRequired = "required"
+	SheetTagOptionMin      = "min="
+	SheetTagOptionMax      = "max="
+	SheetTagOptionMatch    = "match="
+	SheetTagOptionOneOf    = "oneof="
+
+	SheetTagOptionDefault   = "default="
+	SheetTagOptionEmptyZero = "emptyzero"
+	SheetTagOptionOmitZero  = "omitzero"
+
+	SheetTagOptionCodec = "codec="
+
+	SheetTagOptionMarshaler            = "marshaler="
+	SheetTagOptionMarshalerStringer    = "stringer"
+	SheetTagOptionMarshalerTextMarshal = "text"
+
+	SheetTagOptionVersion = "version"
+
+	oneOfSeparator = "|"
 )
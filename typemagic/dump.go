@@ -5,8 +5,8 @@ import (
 	"encoding"
 	"fmt"
 	"github.com/pproj/sheetsorm/column"
+	"github.com/pproj/sheetsorm/errors"
 	"reflect"
-	"slices"
 	"strconv"
 	"time"
 )
@@ -15,75 +15,72 @@ var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()                //
 var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()                 // yes...
 var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem() // yes...
 
-func workOutValue(value reflect.Value, br BoolRepresentation) string {
+// workOutValue returns an error only if one of the interfaces implemented by the value (or the registered codec) fails to produce a value
+func workOutValue(value reflect.Value, t Tag) (string, error) {
 	// value should never be a pointer...
 
+	if !value.IsValid() {
+		return "", nil
+	}
+
 	v := value.Interface()
+	br := t.BoolRepresentation
 
-	if !value.IsValid() {
-		return "" // TODO, maybe panic?
+	// registered codecs take priority over everything
+	c, ok, err := lookupCodec(value.Type(), t)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return c.Encode(v)
 	}
 
-	// try valuer first
+	// then our own interface
+	if impl, ok := addressableImplements(value, cellMarshalerType); ok {
+		return impl.Interface().(CellMarshaler).MarshalCell(t)
+	}
+
+	// then valuer
 	if value.Type().Implements(valuerType) {
 		valuer := v.(driver.Valuer)
 		valuedValue, err := valuer.Value()
 		if err != nil {
-			panic(err)
+			return "", err
 		}
 
 		switch valuedValue := valuedValue.(type) { // the only possible values should be this, by documentation
 		case int64:
-			return fmt.Sprintf("%d", valuedValue)
+			return fmt.Sprintf("%d", valuedValue), nil
 		case float64:
-			return strconv.FormatFloat(valuedValue, 'f', -1, 64)
+			return strconv.FormatFloat(valuedValue, 'f', -1, 64), nil
 		case bool:
-			return br.Represent(valuedValue)
+			return br.Represent(valuedValue), nil
 		case []byte:
-			return string(valuedValue)
+			return string(valuedValue), nil
 		case string:
-			return valuedValue
+			return valuedValue, nil
 		case time.Time:
-			return valuedValue.String()
+			return valuedValue.String(), nil
 		default:
-			panic("valuer implemented, but returned invalid value")
+			return "", fmt.Errorf("%w: valuer implemented, but returned invalid value of type %T", errors.ErrConversionFailed, valuedValue)
 		}
 
 	}
 
-	// then stringer
-	if value.Type().Implements(stringerType) {
-		s := v.(fmt.Stringer)
-		return s.String()
-	} else { // this is getting weird...
-		if value.CanAddr() {
-			vPtr := value.Addr()
-			if vPtr.Type().Implements(stringerType) {
-				s := vPtr.Interface().(fmt.Stringer)
-				return s.String()
-			}
+	// then stringer and textMarshaler, in the configured order
+	if t.MarshalerPriority.resolve() == PreferTextMarshaler {
+		if str, ok, err := tryTextMarshaler(value); ok {
+			return str, err
 		}
-	}
-
-	// then textMarshaler
-	if value.Type().Implements(textMarshalerType) {
-		s := v.(encoding.TextMarshaler)
-		binstr, err := s.MarshalText()
-		if err != nil {
-			panic(err)
+		if str, ok := tryStringer(value); ok {
+			return str, nil
 		}
-		return string(binstr)
-	} else { // this is getting weird...
-		if value.CanAddr() {
-			vPtr := value.Addr()
-			if vPtr.Type().Implements(textMarshalerType) {
-				s := vPtr.Interface().(encoding.TextMarshaler)
-				binstr, err := s.MarshalText()
-				if err != nil {
-					panic(err)
-				}
-				return string(binstr)
-			}
+	} else {
+		if str, ok := tryStringer(value); ok {
+			return str, nil
+		}
+		if str, ok, err := tryTextMarshaler(value); ok {
+			return str, err
 		}
 	}
 
@@ -91,218 +88,126 @@ func workOutValue(value reflect.Value, br BoolRepresentation) string {
 
 	switch v := v.(type) {
 	case bool:
-		return br.Represent(v)
+		return br.Represent(v), nil
 	case string:
-		return v
+		return v, nil
 	case float64:
-		return strconv.FormatFloat(v, 'f', -1, 64)
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
 	case float32:
-		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
 	}
 
 	if value.CanInt() || value.CanUint() {
-		return fmt.Sprintf("%d", v)
+		return fmt.Sprintf("%d", v), nil
 	}
 
 	// if none working, resort to this
-	return fmt.Sprintf("%v", value)
+	return fmt.Sprintf("%v", value), nil
 }
 
-func magicDumpIter(item interface{}, iterator func(valueValid bool, value reflect.Value, t Tag) bool) {
-	val := reflect.ValueOf(item)
-
-	if val.Kind() == reflect.Ptr {
-		if val.IsNil() {
-			panic("this is nil")
-		}
-
-		val = val.Elem()
-		if val.Kind() == reflect.Interface { // okay, I probably should read some documentation at this point...
-			if val.IsNil() {
-				panic("this is nil")
-			}
-			val = val.Elem()
-		}
-	}
-
-	if val.Kind() != reflect.Struct {
-		panic("expected struct or pointer to struct, not " + val.Kind().String())
-	}
-
-	for i := 0; i < val.NumField(); i++ {
-		f := val.Field(i)
-
-		if !val.Type().Field(i).IsExported() {
-			// ignore unexported fields
-			continue
-		}
-
-		valueValid := true // in other words... non-nil
-		if f.Kind() == reflect.Ptr {
-			if f.IsNil() {
-				// skip nil field
-				valueValid = false
-			} else {
-				// pointer but not nil
-				f = f.Elem() // up one level
-
-				if f.Kind() == reflect.Ptr { // check if it's not another pointer
-					panic("multi-level pointers are not supported") // because I'm lazy
-				}
-			}
-		}
-
-		// Okay, this is getting tricky... so how we decide if we should recurse into a struct or not?
-		// Naive idea: Let's just use the struct tag... if it has one, we won't recurse, if not then we should...
-		// probably a better idea would be to take into consideration if it implements valuer or stringer...
-
-		tagVal := val.Type().Field(i).Tag.Get(SheetTag)
-
-		if tagVal == "" {
-			if valueValid && f.Kind() == reflect.Struct {
-				// if another struct, then recurse into it
-				magicDumpIter(f.Interface(), iterator)
-				continue
-			}
-
-			// ignore un-configured, non-struct
-			continue
-		}
-
-		// parse struct tag
-		tag := ParseTagValString(tagVal)
-
-		if !tag.HasColumn() { // has a "-" as the column, should be ignored ...
-			continue
-		}
-
-		shouldContinue := iterator(valueValid, f, tag)
-		if !shouldContinue {
-			return // stop iterator
-		}
+// dumpValue works out the cell value for the field, taking the options of the tag into account
+func dumpValue(value reflect.Value, t Tag) (string, error) {
+	if t.OmitZero && value.IsZero() {
+		return "", nil
 	}
+	return workOutValue(value, t)
 }
 
 // DumpStruct dumps the structure into a rowData map based on the sheet:"..." struct tag. It can omit fields marked as read-only
 // The uid field is not read-only by default, so if you want to omit it from the dump, you must mark it as read-only in the struct tag.
-func DumpStruct(item interface{}, omitReadOnly bool) map[string]string {
+// Every dumped field is checked against the constraints in its struct tag, if any of them fails, a *errors.ValidationError listing all of them is returned.
+// Nil pointer fields are not dumped, but they still violate the required constraint, unless they are omitted as read-only.
+func DumpStruct(item interface{}, omitReadOnly bool) (map[string]string, error) {
 	// We are writing type-safe type-unsafe code here...
+	val, schema, err := structAndSchema(item)
+	if err != nil {
+		return nil, err
+	}
 
 	data := make(map[string]string)
+	var violations []errors.Violation
 
-	magicDumpIter(item, func(valid bool, value reflect.Value, t Tag) bool {
-		if !valid {
-			return true
-		}
-		if t.IsReadOnly && omitReadOnly {
+	for _, f := range schema.fields {
+		if f.Tag.IsReadOnly && omitReadOnly {
 			// If a value is read-only then we might not want to get it dumped, for example for updates..
-			return true
+			continue
+		}
+		value, valid := f.valueForDump(val)
+		if !valid {
+			violations = collectViolations(violations, f.Name, valid, value, f.Tag, "")
+			continue
 		}
 
-		_, ok := data[t.Column]
+		_, ok := data[f.Tag.Column]
 		if ok {
-			panic("multiple values assigned to the same column")
+			return nil, &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: errors.ErrDuplicateColumn}
 		}
-		data[t.Column] = workOutValue(value, t.BoolRepresentation)
-		return true
-	})
 
-	return data
+		cell, err := dumpValue(value, f.Tag)
+		if err != nil {
+			return nil, &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: err}
+		}
+		data[f.Tag.Column] = cell
+		violations = collectViolations(violations, f.Name, valid, value, f.Tag, cell)
+	}
+
+	if len(violations) > 0 {
+		return nil, &errors.ValidationError{Violations: violations}
+	}
 
+	return data, nil
 }
 
 // DumpUID extracts the UID value from the struct, if it is not configured it will use the left-most value, it dumps the value even if the uid col is marked read-only
-func DumpUID(item interface{}) string {
-
-	var result string // used in place of uid if not defined (the left most column)
-	var minCol = -1   // invalid
-	var found bool
-
-	magicDumpIter(item, func(valid bool, value reflect.Value, t Tag) bool {
-		if t.IsUID {
-			if valid {
-				result = workOutValue(value, t.BoolRepresentation)
-			} else {
-				result = ""
-			}
-			found = true
-			return false // found the explicit uid definition
-		}
-
-		// if not explicitly configured, then check if it's lefter than the previous
-		colIdx := column.ColIndex(t.Column)
-		if minCol == -1 || colIdx < minCol {
-			if valid {
-				result = workOutValue(value, t.BoolRepresentation)
-			} else {
-				result = ""
-			}
-			minCol = colIdx
-			found = true
-		}
-		return true
+func DumpUID(item interface{}) (string, error) {
+	val, schema, err := structAndSchema(item)
+	if err != nil {
+		return "", err
+	}
 
-	})
-	if !found {
-		panic("no suitable field for uid found")
+	f, err := schema.UIDField()
+	if err != nil {
+		return "", err
 	}
 
-	return result
+	value, valid := f.valueForDump(val)
+	if !valid {
+		return "", nil
+	}
+	result, err := workOutValue(value, f.Tag)
+	if err != nil {
+		return "", &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: err}
+	}
+	return result, nil
 }
 
 // DumpUIDCol is the same as DumpUID but with the column itself
-func DumpUIDCol(item interface{}) string {
-
-	var result string // used in place of uid if not defined (the left most column)
-	var minCol = -1   // invalid
-
-	magicDumpIter(item, func(_ bool, _ reflect.Value, t Tag) bool {
-		if t.IsUID {
-			result = t.Column
-			return false // found the explicit uid definition
-		}
-
-		// if not explicitly configured, then check if it's lefter than the previous
-		colIdx := column.ColIndex(t.Column)
-		if minCol == -1 || colIdx < minCol {
-			result = t.Column
-			minCol = colIdx
-		}
-		return true
-
-	})
-	if result == "" {
-		panic("no suitable field for uid found")
+func DumpUIDCol(item interface{}) (string, error) {
+	schema, err := SchemaOf(item)
+	if err != nil {
+		return "", err
 	}
-
-	return result
+	return schema.UIDCol()
 }
 
 // DumpCols returns column.Cols that are used for this type (regardless if the column has a valid value or not)
-func DumpCols(item interface{}) column.Cols {
-
-	var resultS []string
-
-	magicDumpIter(item, func(_ bool, _ reflect.Value, t Tag) bool {
-
-		if slices.Contains(resultS, t.Column) {
-			panic("multiple values assigned to the same column")
-		}
-
-		resultS = append(resultS, t.Column)
-		return true
-	})
-
-	slices.SortFunc(resultS, func(a, b string) int {
-		return column.ColIndex(a) - column.ColIndex(b)
-	})
-
-	result := column.Cols(resultS)
-
-	err := result.Validate()
+func DumpCols(item interface{}) (column.Cols, error) {
+	schema, err := SchemaOf(item)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	return schema.Cols()
+}
 
-	return result
+// structAndSchema returns the struct value behind item along with its schema
+func structAndSchema(item interface{}) (reflect.Value, *Schema, error) {
+	val, err := structValue(item)
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	schema, err := SchemaOfType(val.Type())
+	if err != nil {
+		return reflect.Value{}, nil, err
+	}
+	return val, schema, nil
 }
@@ -325,44 +325,41 @@ func TestWorkOutValue(t *testing.T) {
 		"hello",
 		"hello",
 		testTimestamp.String(),
-		"!!!PANIC!!!",
+		"!!!ERROR!!!",
 		"1",
 		"1.2",
 		"yes",
 		"hello",
 		"hello",
 		testTimestamp.String(),
-		"!!!PANIC!!!",
+		"!!!ERROR!!!",
 		"78",
 		"786",
-		"!!!PANIC!!!",
-		"!!!PANIC!!!",
+		"!!!ERROR!!!",
+		"!!!ERROR!!!",
 		"hello",
 		"hello",
-		"!!!PANIC!!!",
-		"!!!PANIC!!!",
+		"!!!ERROR!!!",
+		"!!!ERROR!!!",
 	}
 
 	val := reflect.ValueOf(testVal)
 	for i := 0; i < val.NumField(); i++ {
 		t.Run(val.Type().Field(i).Name, func(t *testing.T) {
 
-			expectedPanic := expectedStrings[i] == "!!!PANIC!!!"
+			expectedErr := expectedStrings[i] == "!!!ERROR!!!"
 			f := val.Field(i)
 
-			if expectedPanic {
-				assert.Panics(t, func() {
-					workOutValue(f, BoolRepresentation{})
-				})
+			valStr, err := workOutValue(f, Tag{BoolRepresentation: BoolRepresentation{
+				True:    "yes",
+				False:   "no",
+				Unknown: false,
+			}})
+			if expectedErr {
+				assert.Error(t, err)
 			} else {
-				assert.NotPanics(t, func() {
-					valStr := workOutValue(f, BoolRepresentation{
-						True:    "yes",
-						False:   "no",
-						Unknown: false,
-					})
-					assert.Equal(t, expectedStrings[i], valStr)
-				})
+				assert.NoError(t, err)
+				assert.Equal(t, expectedStrings[i], valStr)
 			}
 
 		})
@@ -442,10 +439,10 @@ func TestDumpCols(t *testing.T) {
 	}
 
 	testCases := []struct {
-		name          string
-		item          interface{}
-		expectedCols  column.Cols
-		expectedPanic bool
+		name         string
+		item         interface{}
+		expectedCols column.Cols
+		expectedErr  error
 	}{
 		{
 			name:         "simple",
@@ -453,9 +450,9 @@ func TestDumpCols(t *testing.T) {
 			expectedCols: []string{"A", "B", "C"},
 		},
 		{
-			name:          "duplicate",
-			item:          test2{},
-			expectedPanic: true,
+			name:        "duplicate",
+			item:        test2{},
+			expectedErr: errors.ErrDuplicateColumn,
 		},
 		{
 			name:         "explicitly_skipped_one",
@@ -468,14 +465,14 @@ func TestDumpCols(t *testing.T) {
 			expectedCols: []string{"A", "C"},
 		},
 		{
-			name:          "invalid",
-			item:          test5{},
-			expectedPanic: true,
+			name:        "invalid",
+			item:        test5{},
+			expectedErr: errors.ErrInvalidTag,
 		},
 		{
-			name:          "empty",
-			item:          struct{}{},
-			expectedPanic: true,
+			name:        "empty",
+			item:        struct{}{},
+			expectedErr: errors.ErrColsInvalid,
 		},
 		{
 			name:         "works_with_nil_and_empty",
@@ -483,9 +480,9 @@ func TestDumpCols(t *testing.T) {
 			expectedCols: []string{"A", "B", "C", "D", "E"},
 		},
 		{
-			name:          "panics_nil_multi_col",
-			item:          test7{},
-			expectedPanic: true,
+			name:        "error__nil_multi_col",
+			item:        test7{},
+			expectedErr: errors.ErrDuplicateColumn,
 		},
 		{
 			name:         "unexported_ignored",
@@ -501,15 +498,12 @@ func TestDumpCols(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if tc.expectedPanic {
-				assert.Panics(t, func() {
-					DumpCols(tc.item)
-				})
+			result, err := DumpCols(tc.item)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
 			} else {
-				assert.NotPanics(t, func() {
-					result := DumpCols(tc.item)
-					assert.Equal(t, tc.expectedCols, result)
-				})
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedCols, result)
 			}
 		})
 	}
@@ -574,7 +568,7 @@ func TestDumpUID(t *testing.T) {
 		item           interface{}
 		expectedUID    string
 		expectedUIDCol string
-		expectedPanic  bool
+		expectedErr    error
 	}{
 		{
 			name: "explicit",
@@ -631,9 +625,9 @@ func TestDumpUID(t *testing.T) {
 			expectedUID:    "barack",
 			expectedUIDCol: "B",
 		}, {
-			name:          "empty_struct",
-			item:          struct{}{},
-			expectedPanic: true,
+			name:        "empty_struct",
+			item:        struct{}{},
+			expectedErr: errors.ErrNoUIDField,
 		}, {
 			name: "irrelevant_stuff",
 			item: struct {
@@ -641,7 +635,7 @@ func TestDumpUID(t *testing.T) {
 				Age   int
 				ohBoi bool
 			}{},
-			expectedPanic: true,
+			expectedErr: errors.ErrNoUIDField,
 		}, {
 			name:           "explicit_but_nil",
 			item:           test7{},
@@ -668,20 +662,16 @@ func TestDumpUID(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 
-			if tc.expectedPanic {
-				assert.Panics(t, func() {
-					DumpUID(tc.item)
-				})
-				assert.Panics(t, func() {
-					DumpUIDCol(tc.item)
-				})
+			result, err := DumpUID(tc.item)
+			resultCol, errCol := DumpUIDCol(tc.item)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.ErrorIs(t, errCol, tc.expectedErr)
 			} else {
-				assert.NotPanics(t, func() {
-					result := DumpUID(tc.item)
-					assert.Equal(t, tc.expectedUID, result)
-					resultCol := DumpUIDCol(tc.item)
-					assert.Equal(t, tc.expectedUIDCol, resultCol)
-				})
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedUID, result)
+				assert.NoError(t, errCol)
+				assert.Equal(t, tc.expectedUIDCol, resultCol)
 			}
 		})
 	}
@@ -692,14 +682,15 @@ func TestDumpStruct(t *testing.T) {
 	var testValString = "test"
 	var testValStringPtr = &testValString
 	var testValInt = 12
+	var testValZero = 0
 	var testValBool = true
 
 	testCases := []struct {
-		name          string
-		item          interface{}
-		omitReadOnly  bool
-		expectedDump  map[string]string
-		expectedPanic bool
+		name         string
+		item         interface{}
+		omitReadOnly bool
+		expectedDump map[string]string
+		expectedErr  error
 	}{
 		{
 			name: "test__simple",
@@ -952,7 +943,7 @@ func TestDumpStruct(t *testing.T) {
 				"D": "ohboi",
 			},
 		}, {
-			name: "test__multiptr_panic",
+			name: "test__multiptr_error",
 			item: struct {
 				Name       string   `sheet:"A"`
 				NamePtrPtr **string `sheet:"B"`
@@ -960,9 +951,9 @@ func TestDumpStruct(t *testing.T) {
 				Name:       "alma",
 				NamePtrPtr: &testValStringPtr,
 			},
-			expectedPanic: true,
+			expectedErr: errors.ErrUnsupportedType,
 		}, {
-			name: "test__duplicate_panic",
+			name: "test__duplicate_error",
 			item: struct {
 				Name       string `sheet:"A"`
 				NamePtrPtr string `sheet:"A"`
@@ -970,15 +961,36 @@ func TestDumpStruct(t *testing.T) {
 				Name:       "alma",
 				NamePtrPtr: "barack",
 			},
-			expectedPanic: true,
+			expectedErr: errors.ErrDuplicateColumn,
 		}, {
-			name:          "test__nil_panic",
-			item:          nil,
-			expectedPanic: true,
+			name:        "test__nil_error",
+			item:        nil,
+			expectedErr: errors.ErrInvalidType,
 		}, {
-			name:          "test__int_panic",
-			item:          12,
-			expectedPanic: true,
+			name:        "test__int_error",
+			item:        12,
+			expectedErr: errors.ErrInvalidType,
+		}, {
+			name: "test__omitzero",
+			item: struct {
+				Age     int     `sheet:"A,omitzero"`
+				Valid   bool    `sheet:"B,omitzero"`
+				PtrVal  *int    `sheet:"C,omitzero"`
+				Age2    int     `sheet:"D"`
+				Score   float64 `sheet:"E,omitzero"`
+				PtrVal2 *int    `sheet:"F,omitzero"`
+			}{
+				PtrVal:  &testValZero,
+				PtrVal2: &testValInt,
+			},
+			expectedDump: map[string]string{
+				"A": "",
+				"B": "",
+				"C": "",
+				"D": "0",
+				"E": "",
+				"F": "12",
+			},
 		}, {
 			name:         "test__empty",
 			item:         struct{}{},
@@ -987,20 +999,18 @@ func TestDumpStruct(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if tc.expectedPanic {
-				assert.Panics(t, func() {
-					DumpStruct(tc.item, tc.omitReadOnly)
-				})
-			} else {
-				assert.NotPanics(t, func() {
-					result := DumpStruct(tc.item, tc.omitReadOnly)
-					assert.Equal(t, tc.expectedDump, result)
+			result, err := DumpStruct(tc.item, tc.omitReadOnly)
+			// this should be valid too
+			result2, err2 := DumpStruct(&tc.item, tc.omitReadOnly)
 
-					// this should be valid too
-					result2 := DumpStruct(&tc.item, tc.omitReadOnly)
-					assert.Equal(t, tc.expectedDump, result2)
-
-				})
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.ErrorIs(t, err2, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedDump, result)
+				assert.NoError(t, err2)
+				assert.Equal(t, tc.expectedDump, result2)
 			}
 		})
 	}
@@ -3,109 +3,32 @@ package typemagic
 import (
 	"database/sql"
 	"encoding"
+	"fmt"
 	"github.com/pproj/sheetsorm/errors"
 	"reflect"
 	"strconv"
 )
 
-func magicLoaderIter(item interface{}, iterator func(value reflect.Value, t Tag) error) (int, error) {
-	val := reflect.ValueOf(item)
-
-	if val.Kind() != reflect.Ptr || val.IsNil() {
-		panic("expected pointer to a struct, not " + val.Kind().String())
-	}
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()                  // yes...
+var unmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem() // eh
 
-	val = reflect.Indirect(val)
-	if val.Kind() == reflect.Interface {
-		val = val.Elem()
+// convertAndStoreProperly returns an error if the data is invalid for the format, or the type of the value is not supported
+func convertAndStoreProperly(value reflect.Value, data string, t Tag) error {
+	// registered codecs take priority over everything
+	c, ok, err := lookupCodec(value.Type(), t)
+	if err != nil {
+		return err
 	}
-
-	if val.Kind() != reflect.Struct {
-		panic("expected pointer to a struct, not pointer to " + val.Kind().String())
+	if ok {
+		return decodeAndStore(c, value, data)
 	}
 
-	successfullyVisitedCount := 0
-	for i := 0; i < val.NumField(); i++ {
-		f := val.Field(i)
-
-		if !val.Type().Field(i).IsExported() {
-			// ignore unexported fields
-			continue
-		}
-
-		if !f.CanSet() {
-			// ignore fields we could not set anyway
-			continue
-		}
-
-		// Same issue as with dumper
-		tagVal := val.Type().Field(i).Tag.Get(SheetTag)
-
-		if tagVal == "" {
-			if f.Kind() == reflect.Struct || (f.Kind() == reflect.Ptr && f.Type().Elem().Kind() == reflect.Struct) { // (this type check works with nil ptrs)
-
-				var toRecurseInto reflect.Value
-				var shouldAddBack bool
-				if f.Kind() == reflect.Ptr {
-					if f.IsNil() { // if nil, then create it
-						toRecurseInto = reflect.New(f.Type().Elem())
-						shouldAddBack = true
-					} else {
-						toRecurseInto = f
-						shouldAddBack = false
-					}
-				} else {
-					// it's not a pointer type of field, but we need a pointer to it to be able to modify
-					toRecurseInto = f.Addr()
-					shouldAddBack = false
-				}
-
-				// if another struct, then recurse into it
-				subVisited, err := magicLoaderIter(toRecurseInto.Interface(), iterator) // this may panic in weird cases...
-				if err != nil {
-					return 0, err
-				}
-
-				if shouldAddBack && subVisited > 0 { // only add the new struct if it was useful (i.e.: don't add empty struct)
-					f.Set(toRecurseInto)
-				}
-
-				continue
-			}
-
-			// ignore that does not have tagval and not even a struct
-			continue
-		}
-
-		// parse struct tag
-		t := ParseTagValString(tagVal)
-
-		if !t.HasColumn() { // has a "-" as the column, should be ignored ...
-			continue
-		}
-
-		if f.Kind() == reflect.Ptr { // multi level ptrs should only cause panic, if we try to use them, that's why it's after all possible skips
-			if f.Type().Elem().Kind() == reflect.Ptr { // check if it's not another pointer
-				panic("multi-level pointers are not supported")
-			}
-		}
-
-		err := iterator(f, t)
-		if err != nil {
-			return 0, err
-		}
-		successfullyVisitedCount++
+	// then our own interface
+	if impl, ok := addressableImplements(value, cellUnmarshalerType); ok {
+		return impl.Interface().(CellUnmarshaler).UnmarshalCell(data, t)
 	}
-	return successfullyVisitedCount, nil
-}
 
-var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()                  // yes...
-var unmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem() // eh
-
-// convertAndStoreProperly returns an error only if the data is invalid for the format, otherwise it panics as usual
-func convertAndStoreProperly(value reflect.Value, data string, br BoolRepresentation) error {
-
-	// First, try if it implements scanner type
+	// then try if it implements scanner type
 	if value.Type().Implements(scannerType) { // might happen if we already got a pointer type
 		s := value.Interface().(sql.Scanner)
 		return s.Scan(data)
@@ -175,35 +98,91 @@ func convertAndStoreProperly(value reflect.Value, data string, br BoolRepresenta
 		return nil
 
 	case reflect.Bool:
-		value.SetBool(br.UnRepresent(data))
+		value.SetBool(t.BoolRepresentation.UnRepresent(data))
 		return nil
 
 	default:
-		panic("unsupported kind: " + value.Kind().String())
+		return fmt.Errorf("%w: unsupported kind: %s", errors.ErrUnsupportedType, value.Kind().String())
 	}
 }
 
-// LoadIntoStruct returns an error only if the supplied data (coming from sheets) is not valid for the type in the struct. If the struct itself has issues, it will panic as ususal.
+// EmptyCellPolicy decides what happens with empty cells loaded into fields that have neither the default= nor the emptyzero option set
+type EmptyCellPolicy int
+
+const (
+	// EmptyCellParse passes the empty string to the parser like any other value, this fails for numeric fields
+	EmptyCellParse EmptyCellPolicy = iota
+	// EmptyCellZero sets the field to its zero value (nil for pointers), as if every field had the emptyzero option set
+	EmptyCellZero
+)
+
+func (p EmptyCellPolicy) IsValid() bool {
+	return p == EmptyCellParse || p == EmptyCellZero
+}
+
+// LoadIntoStruct returns an error if the supplied data (coming from sheets) is not valid for the type in the struct, or the struct itself has issues.
+// Errors related to a single field are wrapped in *errors.FieldError, the row number is left for the caller to fill.
 func LoadIntoStruct(data map[string]string, item interface{}) error {
-	var err error
-	_, err = magicLoaderIter(item, func(value reflect.Value, t Tag) error {
+	return LoadIntoStructWithPolicy(data, item, EmptyCellParse)
+}
+
+// LoadIntoStructWithPolicy is the same as LoadIntoStruct, but empty cells are handled according to the policy, unless the struct tag says otherwise
+func LoadIntoStructWithPolicy(data map[string]string, item interface{}, policy EmptyCellPolicy) error {
+	val := reflect.ValueOf(item)
+
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return fmt.Errorf("%w: expected pointer to a struct, not %s", errors.ErrInvalidType, val.Kind().String())
+	}
+
+	val = reflect.Indirect(val)
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("%w: expected pointer to a struct, not pointer to %s", errors.ErrInvalidType, val.Kind().String())
+	}
+
+	schema, err := SchemaOfType(val.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range schema.fields {
+		value := f.valueForLoad(val)
+		if !value.CanSet() {
+			// ignore fields we could not set anyway
+			continue
+		}
 
-		dataVal, ok := data[t.Column]
+		dataVal, ok := data[f.Tag.Column]
 		if !ok {
-			return nil // nothing to set, continue iteration..
+			continue // nothing to set
 		}
 
+		if dataVal == "" {
+			if f.Tag.Default != nil {
+				dataVal = *f.Tag.Default
+			} else if f.Tag.EmptyIsZero || policy == EmptyCellZero {
+				value.Set(reflect.Zero(value.Type()))
+				continue
+			}
+		}
+
+		var internalErr error
 		if value.Kind() == reflect.Ptr {
 			immediateVal := reflect.New(value.Type().Elem())
-			internalErr := convertAndStoreProperly(immediateVal.Elem(), dataVal, t.BoolRepresentation)
-			if internalErr != nil {
-				return internalErr
+			internalErr = convertAndStoreProperly(immediateVal.Elem(), dataVal, f.Tag)
+			if internalErr == nil {
+				value.Set(immediateVal)
 			}
-			value.Set(immediateVal)
-			return nil
 		} else {
-			return convertAndStoreProperly(value, dataVal, t.BoolRepresentation)
+			internalErr = convertAndStoreProperly(value, dataVal, f.Tag)
+		}
+
+		if internalErr != nil {
+			return &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Value: data[f.Tag.Column], Err: internalErr}
 		}
-	})
-	return err
+	}
+	return nil
 }
@@ -142,12 +142,11 @@ func TestLoadIntoStruct(t *testing.T) {
 	testScannerNonStruct := TestScannerNotStructBased(0)
 
 	testCases := []struct {
-		name          string
-		data          map[string]string
-		item          interface{}
-		expectedItem  interface{}
-		expectedErr   error
-		expectedPanic bool
+		name         string
+		data         map[string]string
+		item         interface{}
+		expectedItem interface{}
+		expectedErr  error
 	}{
 		{
 			name: "simple",
@@ -287,8 +286,7 @@ func TestLoadIntoStruct(t *testing.T) {
 				IP:    net.IPv4(127, 0, 0, 2),
 				IPPtr: &testValIPv4,
 			},
-			expectedErr:   nil,
-			expectedPanic: false,
+			expectedErr: nil,
 		},
 		{
 			name: "ips_v6",
@@ -301,8 +299,7 @@ func TestLoadIntoStruct(t *testing.T) {
 				IP:    net.ParseIP("fe80::1"),
 				IPPtr: &testValIPv6,
 			},
-			expectedErr:   nil,
-			expectedPanic: false,
+			expectedErr: nil,
 		},
 		{
 			name: "overwrite",
@@ -500,14 +497,14 @@ func TestLoadIntoStruct(t *testing.T) {
 				Exported:    3,
 			},
 		}, {
-			name: "panic__multiptr",
+			name: "error__multiptr",
 			data: map[string]string{
 				"A": "1", // placeholder
 				"B": "2",
 				"C": "3",
 			},
-			item:          &testStructMultiptr{},
-			expectedPanic: true,
+			item:        &testStructMultiptr{},
+			expectedErr: errors.ErrUnsupportedType,
 		}, {
 			name: "multiptr_ignored",
 			data: map[string]string{
@@ -519,50 +516,42 @@ func TestLoadIntoStruct(t *testing.T) {
 				Hello:    "world",
 			},
 		}, {
-			name: "panic__nil",
+			name: "error__nil",
 			data: map[string]string{
 				"A": "1", // placeholder
 				"B": "2",
 				"C": "3",
 			},
-			item:          nil,
-			expectedPanic: true,
+			item:        nil,
+			expectedErr: errors.ErrInvalidType,
 		}, {
-			name: "panic__unexpected_ptr",
+			name: "error__unexpected_ptr",
 			data: map[string]string{
 				"A": "1", // placeholder
 				"B": "2",
 				"C": "3",
 			},
-			item:          &testValBoolFalse,
-			expectedPanic: true,
+			item:        &testValBoolFalse,
+			expectedErr: errors.ErrInvalidType,
 		}, {
-			name: "panic__store_into_invalid_type",
+			name: "error__store_into_invalid_type",
 			data: map[string]string{
 				"A": "1",
 			},
-			item:          &testStructInvalidType{},
-			expectedPanic: true,
+			item:        &testStructInvalidType{},
+			expectedErr: errors.ErrUnsupportedType,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 
-			if tc.expectedPanic {
-				assert.Panics(t, func() {
-					_ = LoadIntoStruct(tc.data, tc.item)
-				})
+			err := LoadIntoStruct(tc.data, tc.item)
+			if tc.expectedErr != nil {
+				assert.Error(t, err)
+				assert.ErrorIs(t, err, tc.expectedErr)
 			} else {
-				assert.NotPanics(t, func() {
-					err := LoadIntoStruct(tc.data, tc.item)
-					if tc.expectedErr != nil {
-						assert.Error(t, err)
-						assert.ErrorIs(t, err, tc.expectedErr)
-					} else {
-						assert.NoError(t, err)
-						assert.Equal(t, tc.expectedItem, reflect.ValueOf(tc.item).Elem().Interface())
-					}
-				})
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedItem, reflect.ValueOf(tc.item).Elem().Interface())
 			}
 
 		})
@@ -590,3 +579,95 @@ func TestLoadIntoStructSimplified(t *testing.T) {
 	assert.Equal(t, te, tv)
 	assert.Equal(t, tv.Name, "alma")
 }
+
+func TestLoadIntoStructEmptyCells(t *testing.T) {
+	type testStructOptions struct {
+		Int        int     `sheet:"A"`
+		IntDefault int     `sheet:"B,default=42"`
+		IntZero    int     `sheet:"C,emptyzero"`
+		PtrDefault *uint   `sheet:"D,default=12"`
+		PtrZero    *int    `sheet:"E,emptyzero"`
+		Str        *string `sheet:"F"`
+	}
+
+	testValUint := uint(12)
+	testValEmpty := ""
+
+	testCases := []struct {
+		name         string
+		data         map[string]string
+		policy       EmptyCellPolicy
+		expectedItem testStructOptions
+		expectErr    bool
+	}{
+		{
+			name:      "parse_policy_fails_on_int",
+			data:      map[string]string{"A": "", "B": "", "C": "", "D": "", "E": "", "F": ""},
+			policy:    EmptyCellParse,
+			expectErr: true,
+		},
+		{
+			name:   "parse_policy_tag_options",
+			data:   map[string]string{"B": "", "C": "", "D": "", "E": "", "F": ""},
+			policy: EmptyCellParse,
+			expectedItem: testStructOptions{
+				IntDefault: 42,
+				PtrDefault: &testValUint,
+				Str:        &testValEmpty,
+			},
+		},
+		{
+			name:   "zero_policy",
+			data:   map[string]string{"A": "", "B": "", "C": "", "D": "", "E": "", "F": ""},
+			policy: EmptyCellZero,
+			expectedItem: testStructOptions{
+				IntDefault: 42,
+				PtrDefault: &testValUint,
+			},
+		},
+		{
+			name:   "non_empty_values_are_loaded",
+			data:   map[string]string{"A": "1", "B": "2", "C": "3"},
+			policy: EmptyCellZero,
+			expectedItem: testStructOptions{
+				Int:        1,
+				IntDefault: 2,
+				IntZero:    3,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var item testStructOptions
+			err := LoadIntoStructWithPolicy(tc.data, &item, tc.policy)
+			if tc.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedItem, item)
+			}
+		})
+	}
+}
+
+func TestLoadIntoStructFieldError(t *testing.T) {
+	type testStruct struct {
+		Name string `sheet:"A"`
+		Data struct {
+			Age *int `sheet:"B"`
+		}
+	}
+
+	var item testStruct
+	err := LoadIntoStruct(map[string]string{"A": "alma", "B": "twelve"}, &item)
+
+	var fieldErr *errors.FieldError
+	if assert.ErrorAs(t, err, &fieldErr) {
+		assert.Equal(t, "Data.Age", fieldErr.Field)
+		assert.Equal(t, "B", fieldErr.Column)
+		assert.Equal(t, "twelve", fieldErr.Value)
+		assert.Equal(t, 0, fieldErr.Row)
+		assert.ErrorIs(t, err, strconv.ErrSyntax)
+	}
+}
@@ -0,0 +1,91 @@
+package typemagic
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// CellMarshaler is implemented by types that know how to represent themselves in a sheet cell.
+// It takes priority over driver.Valuer, fmt.Stringer and encoding.TextMarshaler, only registered codecs are consulted before it.
+type CellMarshaler interface {
+	MarshalCell(t Tag) (string, error)
+}
+
+// CellUnmarshaler is implemented by types that know how to load themselves from a sheet cell.
+// It takes priority over sql.Scanner and encoding.TextUnmarshaler, only registered codecs are consulted before it.
+type CellUnmarshaler interface {
+	UnmarshalCell(cell string, t Tag) error
+}
+
+var cellMarshalerType = reflect.TypeOf((*CellMarshaler)(nil)).Elem()     // yes...
+var cellUnmarshalerType = reflect.TypeOf((*CellUnmarshaler)(nil)).Elem() // yes...
+
+// MarshalerPriority decides which interface is used for dumping, when a type implements both fmt.Stringer and encoding.TextMarshaler
+type MarshalerPriority int
+
+const (
+	// MarshalerPriorityDefault falls back to the package-wide default, see SetDefaultMarshalerPriority
+	MarshalerPriorityDefault MarshalerPriority = iota
+	PreferStringer
+	PreferTextMarshaler
+)
+
+var defaultMarshalerPriority atomic.Int32
+
+func init() {
+	defaultMarshalerPriority.Store(int32(PreferStringer))
+}
+
+// SetDefaultMarshalerPriority sets the priority used by fields that do not set one with the marshaler= struct tag option.
+// The default is PreferStringer.
+func SetDefaultMarshalerPriority(p MarshalerPriority) {
+	if p != PreferStringer && p != PreferTextMarshaler {
+		panic("invalid marshaler priority")
+	}
+	defaultMarshalerPriority.Store(int32(p))
+}
+
+func (p MarshalerPriority) resolve() MarshalerPriority {
+	if p == MarshalerPriorityDefault {
+		return MarshalerPriority(defaultMarshalerPriority.Load())
+	}
+	return p
+}
+
+// addressableImplements returns the value or the pointer to it, whichever implements the interface type.
+// The second return value is false if neither of them does.
+func addressableImplements(value reflect.Value, ifaceType reflect.Type) (reflect.Value, bool) {
+	if value.Type().Implements(ifaceType) {
+		return value, true
+	}
+	if value.CanAddr() { // this is getting weird...
+		vPtr := value.Addr()
+		if vPtr.Type().Implements(ifaceType) {
+			return vPtr, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// tryStringer and tryTextMarshaler return false if the value does not implement the interface
+func tryStringer(value reflect.Value) (string, bool) {
+	impl, ok := addressableImplements(value, stringerType)
+	if !ok {
+		return "", false
+	}
+	return impl.Interface().(fmt.Stringer).String(), true
+}
+
+func tryTextMarshaler(value reflect.Value) (string, bool, error) {
+	impl, ok := addressableImplements(value, textMarshalerType)
+	if !ok {
+		return "", false, nil
+	}
+	binstr, err := impl.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return "", true, err
+	}
+	return string(binstr), true, nil
+}
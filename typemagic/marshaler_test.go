@@ -0,0 +1,96 @@
+package typemagic
+
+import (
+	"github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// TestCellMarshaler implements every interface, but the cell interfaces should win
+type TestCellMarshaler struct {
+	Val bool
+}
+
+func (tcm TestCellMarshaler) MarshalCell(t Tag) (string, error) {
+	return "cell:" + t.BoolRepresentation.Represent(tcm.Val), nil
+}
+
+func (tcm TestCellMarshaler) String() string {
+	return "stringer"
+}
+
+func (tcm TestCellMarshaler) MarshalText() ([]byte, error) {
+	return []byte("text"), nil
+}
+
+func (tcm *TestCellMarshaler) UnmarshalCell(cell string, t Tag) error {
+	tcm.Val = t.BoolRepresentation.UnRepresent(strings.TrimPrefix(cell, "cell:"))
+	return nil
+}
+
+func (tcm *TestCellMarshaler) UnmarshalText(text []byte) error {
+	panic("should not be called")
+}
+
+type TestStringerAndTextMarshaler struct{}
+
+func (TestStringerAndTextMarshaler) String() string {
+	return "Human readable"
+}
+
+func (TestStringerAndTextMarshaler) MarshalText() ([]byte, error) {
+	return []byte("machine"), nil
+}
+
+func TestCellMarshalerInterfaces(t *testing.T) {
+	type testStruct struct {
+		Val    TestCellMarshaler  `sheet:"A,true=yes,false=no"`
+		ValPtr *TestCellMarshaler `sheet:"B"`
+	}
+
+	item := testStruct{
+		Val:    TestCellMarshaler{Val: true},
+		ValPtr: &TestCellMarshaler{Val: false},
+	}
+	expectedDump := map[string]string{
+		"A": "cell:yes",
+		"B": "cell:0",
+	}
+
+	result, err := DumpStruct(item, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedDump, result)
+
+	var loaded testStruct
+	assert.NoError(t, LoadIntoStruct(expectedDump, &loaded))
+	assert.Equal(t, item, loaded)
+}
+
+func TestMarshalerPriority(t *testing.T) {
+	type testStruct struct {
+		Default  TestStringerAndTextMarshaler `sheet:"A"`
+		Stringer TestStringerAndTextMarshaler `sheet:"B,marshaler=stringer"`
+		Text     TestStringerAndTextMarshaler `sheet:"C,marshaler=text"`
+	}
+
+	t.Run("default_prefers_stringer", func(t *testing.T) {
+		result, err := DumpStruct(testStruct{}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"A": "Human readable", "B": "Human readable", "C": "machine"}, result)
+	})
+
+	t.Run("changed_default", func(t *testing.T) {
+		SetDefaultMarshalerPriority(PreferTextMarshaler)
+		defer SetDefaultMarshalerPriority(PreferStringer)
+
+		result, err := DumpStruct(testStruct{}, false)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"A": "machine", "B": "Human readable", "C": "machine"}, result)
+	})
+
+	t.Run("invalid_tag", func(t *testing.T) {
+		_, err := ParseTagValString("A,marshaler=json")
+		assert.ErrorIs(t, err, errors.ErrInvalidTag)
+	})
+}
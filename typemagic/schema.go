@@ -0,0 +1,342 @@
+package typemagic
+
+import (
+	"fmt"
+	"github.com/pproj/sheetsorm/column"
+	"github.com/pproj/sheetsorm/errors"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SchemaField is a single field of a struct that is mapped to a column
+type SchemaField struct {
+	Name  string // the Go field path, nested fields are separated by dots
+	Index []int  // the index path of the field, nested structs (and pointers to them) are traversed along the way
+	Tag   Tag
+}
+
+// Schema is the compiled form of a struct type, it holds everything typemagic needs to load and dump values of that type without parsing the struct again.
+// Schemas are immutable and cached, use SchemaOf or SchemaOfType to get one.
+type Schema struct {
+	typ    reflect.Type
+	fields []SchemaField
+
+	uidField     int // index in fields, -1 if there are no fields
+	versionField int // index in fields, -1 if there is no version field
+	cols         column.Cols
+	colsErr      error
+}
+
+type schemaCacheEntry struct {
+	schema *Schema
+	err    error
+}
+
+var schemaCache sync.Map // reflect.Type -> schemaCacheEntry
+
+// SchemaOf returns the schema of the struct (or pointer to struct) passed
+func SchemaOf(item interface{}) (*Schema, error) {
+	val, err := structValue(item)
+	if err != nil {
+		return nil, err
+	}
+	return SchemaOfType(val.Type())
+}
+
+// SchemaOfType returns the schema of the struct type, it is compiled on the first call for each type, and cached afterward
+func SchemaOfType(typ reflect.Type) (*Schema, error) {
+	if cached, ok := schemaCache.Load(typ); ok {
+		entry := cached.(schemaCacheEntry)
+		return entry.schema, entry.err
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: expected struct, not %s", errors.ErrInvalidType, typ.Kind().String())
+	}
+
+	schema, err := compileSchema(typ)
+	// it does not matter if another goroutine compiled the same schema in the meantime, they are equal
+	schemaCache.Store(typ, schemaCacheEntry{schema: schema, err: err})
+	return schema, err
+}
+
+func compileSchema(typ reflect.Type) (*Schema, error) {
+	s := &Schema{typ: typ, uidField: -1, versionField: -1}
+
+	err := s.collectFields(typ, "", nil, []reflect.Type{typ})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, f := range s.fields {
+		if !f.Tag.IsVersion {
+			continue
+		}
+		if s.versionField != -1 {
+			return nil, &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: fmt.Errorf("%w: only one version field is allowed", errors.ErrInvalidTag)}
+		}
+		s.versionField = i
+	}
+
+	// find the uid column, if it is not configured explicitly, then the left most one is used
+	minCol := -1 // invalid
+	for i, f := range s.fields {
+		if f.Tag.IsUID {
+			s.uidField = i
+			break
+		}
+		colIdx := column.ColIndex(f.Tag.Column)
+		if minCol == -1 || colIdx < minCol {
+			s.uidField = i
+			minCol = colIdx
+		}
+	}
+
+	// the columns are only needed when working with a sheet, so issues with them are reported only when they are needed
+	cols := make([]string, 0, len(s.fields))
+	for _, f := range s.fields {
+		if slices.Contains(cols, f.Tag.Column) {
+			s.colsErr = &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: errors.ErrDuplicateColumn}
+			break
+		}
+		cols = append(cols, f.Tag.Column)
+	}
+	if s.colsErr == nil {
+		slices.SortFunc(cols, func(a, b string) int {
+			return column.ColIndex(a) - column.ColIndex(b)
+		})
+		s.cols = cols
+		s.colsErr = s.cols.Validate()
+	}
+
+	return s, nil
+}
+
+// collectFields walks the struct recursively, parents is the list of struct types currently being walked, used to avoid infinite recursion
+func (s *Schema) collectFields(typ reflect.Type, fieldPrefix string, indexPrefix []int, parents []reflect.Type) error {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+
+		if !sf.IsExported() {
+			// ignore unexported fields
+			continue
+		}
+
+		field := fieldPrefix + sf.Name
+		index := append(slices.Clone(indexPrefix), i)
+
+		// Okay, this is getting tricky... so how we decide if we should recurse into a struct or not?
+		// Naive idea: Let's just use the struct tag... if it has one, we won't recurse, if not then we should...
+		tagVal := sf.Tag.Get(SheetTag)
+
+		if tagVal == "" {
+			fieldType := sf.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				if slices.Contains(parents, fieldType) {
+					// recursive types can not be mapped to a single row anyway
+					continue
+				}
+				err := s.collectFields(fieldType, field+".", index, append(parents, fieldType))
+				if err != nil {
+					return err
+				}
+			}
+
+			// ignore un-configured, non-struct
+			continue
+		}
+
+		// parse struct tag
+		t, err := ParseTagValString(tagVal)
+		if err != nil {
+			return &errors.FieldError{Field: field, Err: err}
+		}
+
+		if !t.HasColumn() { // has a "-" as the column, should be ignored ...
+			continue
+		}
+
+		if sf.Type.Kind() == reflect.Ptr && sf.Type.Elem().Kind() == reflect.Ptr { // multi level ptrs only cause an error, if we try to use them, that's why it's after all possible skips
+			return &errors.FieldError{Field: field, Column: t.Column, Err: fmt.Errorf("%w: multi-level pointers are not supported", errors.ErrUnsupportedType)}
+		}
+
+		if t.IsVersion && !isIntegerKind(sf.Type.Kind()) {
+			return &errors.FieldError{Field: field, Column: t.Column, Err: fmt.Errorf("%w: version field must be an integer, not %s", errors.ErrUnsupportedType, sf.Type)}
+		}
+		if t.IsVersion && t.IsReadOnly {
+			return &errors.FieldError{Field: field, Column: t.Column, Err: fmt.Errorf("%w: version field can not be read-only", errors.ErrInvalidTag)}
+		}
+
+		s.fields = append(s.fields, SchemaField{
+			Name:  field,
+			Index: index,
+			Tag:   t,
+		})
+	}
+	return nil
+}
+
+// Type returns the struct type the schema was compiled from
+func (s *Schema) Type() reflect.Type {
+	return s.typ
+}
+
+// Fields returns the mapped fields in the order they are declared in the struct, the returned slice must not be modified
+func (s *Schema) Fields() []SchemaField {
+	return s.fields
+}
+
+// UIDField returns the explicitly configured uid field, or the field in the left most column if there is none
+func (s *Schema) UIDField() (SchemaField, error) {
+	if s.uidField == -1 {
+		return SchemaField{}, errors.ErrNoUIDField
+	}
+	return s.fields[s.uidField], nil
+}
+
+// UIDCol is the same as UIDField but with the column itself
+func (s *Schema) UIDCol() (string, error) {
+	f, err := s.UIDField()
+	if err != nil {
+		return "", err
+	}
+	return f.Tag.Column, nil
+}
+
+// VersionField returns the field marked with the version option, the second return value is false if there is none
+func (s *Schema) VersionField() (SchemaField, bool) {
+	if s.versionField == -1 {
+		return SchemaField{}, false
+	}
+	return s.fields[s.versionField], true
+}
+
+// Cols returns the sorted columns used by this type
+func (s *Schema) Cols() (column.Cols, error) {
+	if s.colsErr != nil {
+		return nil, s.colsErr
+	}
+	return s.cols, nil
+}
+
+// NormalizeCell returns the cell the way the field in the column would dump it after loading it, so cells formatted differently than
+// the dumped values (like "1.50" for 1.5, or "TRUE" for a bool dumped as "true") can be compared with them. Bools are matched case-insensitively.
+// The cell is returned as it is if it is empty, there is no field in the column, or the cell can not be loaded into it.
+func (s *Schema) NormalizeCell(col string, cell string) string {
+	if cell == "" {
+		return cell
+	}
+	idx := slices.IndexFunc(s.fields, func(f SchemaField) bool { return f.Tag.Column == col })
+	if idx == -1 {
+		return cell
+	}
+	f := s.fields[idx]
+
+	typ := s.typ
+	for _, i := range f.Index {
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		typ = typ.Field(i).Type
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	value := reflect.New(typ).Elem()
+
+	if typ.Kind() == reflect.Bool && f.Tag.Codec == "" {
+		// the sheet displays booleans in upper case, no matter how they were written
+		switch {
+		case strings.EqualFold(cell, f.Tag.BoolRepresentation.True):
+			return f.Tag.BoolRepresentation.True
+		case strings.EqualFold(cell, f.Tag.BoolRepresentation.False):
+			return f.Tag.BoolRepresentation.False
+		default:
+			return cell
+		}
+	}
+
+	err := convertAndStoreProperly(value, cell, f.Tag)
+	if err != nil {
+		return cell
+	}
+	normalized, err := workOutValue(value, f.Tag)
+	if err != nil {
+		return cell
+	}
+	return normalized
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+// structValue dereferences pointers and interfaces until it gets to a struct
+func structValue(item interface{}) (reflect.Value, error) {
+	val := reflect.ValueOf(item)
+
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return reflect.Value{}, fmt.Errorf("%w: expected struct or pointer to struct, got nil", errors.ErrInvalidType)
+		}
+
+		val = val.Elem()
+		if val.Kind() == reflect.Interface { // okay, I probably should read some documentation at this point...
+			if val.IsNil() {
+				return reflect.Value{}, fmt.Errorf("%w: expected struct or pointer to struct, got nil", errors.ErrInvalidType)
+			}
+			val = val.Elem()
+		}
+	}
+
+	if val.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("%w: expected struct or pointer to struct, not %s", errors.ErrInvalidType, val.Kind().String())
+	}
+
+	return val, nil
+}
+
+// valueForDump returns the value of the field in val, dereferencing pointers. The second return value is false if the value is nil (or a struct containing it is nil)
+func (sf SchemaField) valueForDump(val reflect.Value) (reflect.Value, bool) {
+	for _, i := range sf.Index {
+		if val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				return reflect.Value{}, false
+			}
+			val = val.Elem()
+		}
+		val = val.Field(i)
+	}
+	if val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return reflect.Value{}, false
+		}
+		val = val.Elem()
+	}
+	return val, true
+}
+
+// valueForLoad returns the field in val (which may be a pointer), creating nil structs along the way
+func (sf SchemaField) valueForLoad(val reflect.Value) reflect.Value {
+	for _, i := range sf.Index {
+		if val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				val.Set(reflect.New(val.Type().Elem()))
+			}
+			val = val.Elem()
+		}
+		val = val.Field(i)
+	}
+	return val
+}
@@ -0,0 +1,236 @@
+package typemagic
+
+import (
+	"github.com/pproj/sheetsorm/column"
+	"github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testSchemaRecursive struct {
+	Name string `sheet:"A"`
+	Next *testSchemaRecursive
+}
+
+func TestSchemaOf(t *testing.T) {
+	type nested struct {
+		City string `sheet:"C"`
+	}
+	type testStruct struct {
+		Name    string `sheet:"B"`
+		ID      int    `sheet:"D,uid,readonly"`
+		Ignored string `sheet:"-"`
+		Nested  nested
+		NestPtr *struct {
+			Zip string `sheet:"A"`
+		}
+		unexported string `sheet:"E"`
+	}
+
+	schema, err := SchemaOf(&testStruct{})
+	assert.NoError(t, err)
+	assert.Equal(t, reflect.TypeOf(testStruct{}), schema.Type())
+
+	var names []string
+	var indexes [][]int
+	for _, f := range schema.Fields() {
+		names = append(names, f.Name)
+		indexes = append(indexes, f.Index)
+	}
+	assert.Equal(t, []string{"Name", "ID", "Nested.City", "NestPtr.Zip"}, names)
+	assert.Equal(t, [][]int{{0}, {1}, {3, 0}, {4, 0}}, indexes)
+	assert.True(t, schema.Fields()[1].Tag.IsReadOnly)
+
+	_, ok := schema.VersionField()
+	assert.False(t, ok)
+
+	uidCol, err := schema.UIDCol()
+	assert.NoError(t, err)
+	assert.Equal(t, "D", uidCol)
+
+	cols, err := schema.Cols()
+	assert.NoError(t, err)
+	assert.Equal(t, column.Cols{"A", "B", "C", "D"}, cols)
+
+	t.Run("cached", func(t *testing.T) {
+		again, err := SchemaOf(testStruct{})
+		assert.NoError(t, err)
+		assert.Same(t, schema, again)
+	})
+}
+
+func TestSchemaOfErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		item        interface{}
+		expectedErr error
+	}{
+		{
+			name:        "nil",
+			item:        (*struct{})(nil),
+			expectedErr: errors.ErrInvalidType,
+		},
+		{
+			name:        "not_struct",
+			item:        "alma",
+			expectedErr: errors.ErrInvalidType,
+		},
+		{
+			name: "invalid_tag",
+			item: struct {
+				Name string `sheet:"A,min=a"`
+			}{},
+			expectedErr: errors.ErrInvalidTag,
+		},
+		{
+			name: "multi_pointer",
+			item: struct {
+				Name **string `sheet:"A"`
+			}{},
+			expectedErr: errors.ErrUnsupportedType,
+		},
+		{
+			name: "version_not_integer",
+			item: struct {
+				Version string `sheet:"A,version"`
+			}{},
+			expectedErr: errors.ErrUnsupportedType,
+		},
+		{
+			name: "version_readonly",
+			item: struct {
+				Version int `sheet:"A,version,readonly"`
+			}{},
+			expectedErr: errors.ErrInvalidTag,
+		},
+		{
+			name: "multiple_versions",
+			item: struct {
+				Version      int `sheet:"A,version"`
+				OtherVersion int `sheet:"B,version"`
+			}{},
+			expectedErr: errors.ErrInvalidTag,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schema, err := SchemaOf(tc.item)
+			assert.ErrorIs(t, err, tc.expectedErr)
+			assert.Nil(t, schema)
+
+			// errors are cached as well, they must be returned again
+			if tc.expectedErr != errors.ErrInvalidType {
+				_, err = SchemaOf(tc.item)
+				assert.ErrorIs(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestSchemaVersionField(t *testing.T) {
+	schema, err := SchemaOf(struct {
+		Name    string `sheet:"A"`
+		Version uint   `sheet:"B,version"`
+	}{})
+	assert.NoError(t, err)
+
+	f, ok := schema.VersionField()
+	assert.True(t, ok)
+	assert.Equal(t, "Version", f.Name)
+	assert.Equal(t, "B", f.Tag.Column)
+}
+
+func TestSchemaNormalizeCell(t *testing.T) {
+	type nested struct {
+		Price *float64 `sheet:"D"`
+	}
+	schema, err := SchemaOf(struct {
+		Name   string  `sheet:"A"`
+		Amount float64 `sheet:"B"`
+		Active bool    `sheet:"C,true=true,false=false"`
+		Nested *nested
+	}{})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		col      string
+		cell     string
+		expected string
+	}{
+		{col: "A", cell: " 1.50", expected: " 1.50"},
+		{col: "B", cell: "1.50", expected: "1.5"},
+		{col: "B", cell: "1.5", expected: "1.5"},
+		{col: "B", cell: "1,50", expected: "1,50"}, // can not be loaded
+		{col: "B", cell: "", expected: ""},
+		{col: "C", cell: "TRUE", expected: "true"},
+		{col: "C", cell: "False", expected: "false"},
+		{col: "C", cell: "maybe", expected: "maybe"},
+		{col: "D", cell: "2.0", expected: "2"},
+		{col: "E", cell: "2.0", expected: "2.0"}, // no such column
+	}
+	for _, tc := range testCases {
+		t.Run(tc.col+"_"+tc.cell, func(t *testing.T) {
+			assert.Equal(t, tc.expected, schema.NormalizeCell(tc.col, tc.cell))
+		})
+	}
+}
+
+func TestSchemaColsErrors(t *testing.T) {
+	t.Run("duplicate", func(t *testing.T) {
+		schema, err := SchemaOf(struct {
+			Name  string `sheet:"A"`
+			Other string `sheet:"A"`
+		}{})
+		assert.NoError(t, err) // the schema is still usable for loading
+		_, err = schema.Cols()
+		assert.ErrorIs(t, err, errors.ErrDuplicateColumn)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		schema, err := SchemaOf(struct{}{})
+		assert.NoError(t, err)
+		_, err = schema.Cols()
+		assert.ErrorIs(t, err, errors.ErrColsInvalid)
+		_, err = schema.UIDCol()
+		assert.ErrorIs(t, err, errors.ErrNoUIDField)
+	})
+}
+
+func TestSchemaRecursiveType(t *testing.T) {
+	schema, err := SchemaOf(testSchemaRecursive{})
+	assert.NoError(t, err)
+	assert.Len(t, schema.Fields(), 1)
+
+	var loaded testSchemaRecursive
+	assert.NoError(t, LoadIntoStruct(map[string]string{"A": "alma"}, &loaded))
+	assert.Equal(t, testSchemaRecursive{Name: "alma"}, loaded)
+
+	result, err := DumpStruct(testSchemaRecursive{Name: "alma", Next: &testSchemaRecursive{Name: "korte"}}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"A": "alma"}, result)
+}
+
+func TestSchemaConcurrentUse(t *testing.T) {
+	type testStruct struct {
+		Name string `sheet:"A"`
+		Age  int    `sheet:"B"`
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var loaded testStruct
+			assert.NoError(t, LoadIntoStruct(map[string]string{"A": "alma", "B": "12"}, &loaded))
+			assert.Equal(t, testStruct{Name: "alma", Age: 12}, loaded)
+			uid, err := DumpUID(loaded)
+			assert.NoError(t, err)
+			assert.Equal(t, "alma", uid)
+		}()
+	}
+	wg.Wait()
+}
@@ -1,7 +1,11 @@
 package typemagic
 
 import (
+	"fmt"
 	"github.com/pproj/sheetsorm/column"
+	"github.com/pproj/sheetsorm/errors"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -20,6 +24,23 @@ type Tag struct {
 	// IsReadOnly is in the context of the sheet, in other words, if this is set to true, the value will be read from the sheet, but never written to the sheet
 	IsReadOnly         bool
 	BoolRepresentation BoolRepresentation
+	Constraints        Constraints
+
+	// Default is loaded in place of empty cells, if set
+	Default *string
+	// EmptyIsZero makes empty cells load as the zero value of the field, instead of being parsed
+	EmptyIsZero bool
+	// OmitZero makes zero values dump as empty cells
+	OmitZero bool
+
+	// Codec is the name of the registered codec used for this field, empty means the codec registered for the type (if any)
+	Codec string
+
+	// MarshalerPriority decides whether fmt.Stringer or encoding.TextMarshaler is preferred when dumping this field
+	MarshalerPriority MarshalerPriority
+
+	// IsVersion marks the integer field used for optimistic concurrency control, it is checked and incremented by every update
+	IsVersion bool
 }
 
 func (t Tag) HasColumn() bool {
@@ -64,27 +85,27 @@ func NewDefaultTag() Tag {
 	}
 }
 
-func ParseTagValString(tagVal string) Tag {
+// ParseTagValString parses the value of the sheet struct tag, the returned error wraps errors.ErrInvalidTag
+func ParseTagValString(tagVal string) (Tag, error) {
 	if tagVal == "" {
-		panic("tag string could not be empty")
+		return Tag{}, fmt.Errorf("%w: tag string could not be empty", errors.ErrInvalidTag)
 	}
 	elems := strings.Split(tagVal, ",")
-	if len(elems) == 0 {
-		panic("wtf")
-	}
 	t := NewDefaultTag()
 	t.Column = elems[0]
 
 	if t.HasColumn() {
 		if !column.IsValidCol(t.Column) {
-			panic("invalid column name defined")
+			return Tag{}, fmt.Errorf("%w: invalid column name defined: %q", errors.ErrInvalidTag, t.Column)
 		}
 	}
 
 	if len(elems) == 1 {
-		return t
+		return t, nil
 	}
 
+	var err error
+
 	for _, elem := range elems[1:] { // we will no longer need the first element
 		// If this gets out of hand, we should just split on the first = and use the first part in a split case
 		if elem == SheetTagOptionUID {
@@ -107,8 +128,78 @@ func ParseTagValString(tagVal string) Tag {
 			t.BoolRepresentation.False = strings.TrimPrefix(elem, SheetTagOptionFalseRepr)
 			continue
 		}
+		if elem == SheetTagOptionRequired {
+			t.Constraints.Required = true
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionMin) {
+			t.Constraints.Min, err = parseLimit(strings.TrimPrefix(elem, SheetTagOptionMin))
+			if err != nil {
+				return Tag{}, err
+			}
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionMax) {
+			t.Constraints.Max, err = parseLimit(strings.TrimPrefix(elem, SheetTagOptionMax))
+			if err != nil {
+				return Tag{}, err
+			}
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionMatch) {
+			// Note: the tag is split on commas, so the expression itself can not contain one
+			t.Constraints.Match, err = regexp.Compile(strings.TrimPrefix(elem, SheetTagOptionMatch))
+			if err != nil {
+				return Tag{}, fmt.Errorf("%w: invalid expression defined: %w", errors.ErrInvalidTag, err)
+			}
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionOneOf) {
+			t.Constraints.OneOf = strings.Split(strings.TrimPrefix(elem, SheetTagOptionOneOf), oneOfSeparator)
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionDefault) {
+			defaultVal := strings.TrimPrefix(elem, SheetTagOptionDefault)
+			t.Default = &defaultVal
+			continue
+		}
+		if elem == SheetTagOptionEmptyZero {
+			t.EmptyIsZero = true
+			continue
+		}
+		if elem == SheetTagOptionOmitZero {
+			t.OmitZero = true
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionCodec) {
+			t.Codec = strings.TrimPrefix(elem, SheetTagOptionCodec)
+			continue
+		}
+		if elem == SheetTagOptionVersion {
+			t.IsVersion = true
+			continue
+		}
+		if strings.HasPrefix(elem, SheetTagOptionMarshaler) {
+			switch strings.TrimPrefix(elem, SheetTagOptionMarshaler) {
+			case SheetTagOptionMarshalerStringer:
+				t.MarshalerPriority = PreferStringer
+			case SheetTagOptionMarshalerTextMarshal:
+				t.MarshalerPriority = PreferTextMarshaler
+			default:
+				return Tag{}, fmt.Errorf("%w: invalid marshaler defined: %q", errors.ErrInvalidTag, elem)
+			}
+			continue
+		}
 
 	}
 
-	return t
+	return t, nil
+}
+
+func parseLimit(val string) (*float64, error) {
+	limit, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid limit defined: %q", errors.ErrInvalidTag, val)
+	}
+	return &limit, nil
 }
@@ -1,17 +1,23 @@
 package typemagic
 
 import (
+	"github.com/pproj/sheetsorm/errors"
 	"github.com/stretchr/testify/assert"
+	"regexp"
 	"testing"
 )
 
 func TestParseTagValString(t *testing.T) {
+	testLimitMin := 1.0
+	testLimitMax := 10.5
+	testDefault := "42"
+
 	testCases := []struct {
 		name            string
 		tagValString    string
 		expectedTag     Tag
 		expectHasColumn bool
-		expectPanic     bool
+		expectErr       bool
 	}{
 		{
 			name:         "simple",
@@ -62,7 +68,7 @@ func TestParseTagValString(t *testing.T) {
 		{
 			name:         "invalidcol",
 			tagValString: "abc123",
-			expectPanic:  true,
+			expectErr:    true,
 		},
 		{
 			name:         "with_uid",
@@ -155,24 +161,120 @@ func TestParseTagValString(t *testing.T) {
 			expectHasColumn: true,
 		},
 		{
-			name:         "panic_empty",
+			name:         "with_constraints",
+			tagValString: "AB,required,min=1,max=10.5,oneof=a|b|c",
+			expectedTag: Tag{
+				Column:     "AB",
+				IsUID:      false,
+				IsReadOnly: false,
+				BoolRepresentation: BoolRepresentation{
+					True:    "1",
+					False:   "0",
+					Unknown: false,
+				},
+				Constraints: Constraints{
+					Required: true,
+					Min:      &testLimitMin,
+					Max:      &testLimitMax,
+					OneOf:    []string{"a", "b", "c"},
+				},
+			},
+			expectHasColumn: true,
+		},
+		{
+			name:         "with_match",
+			tagValString: "AB,match=^[a-z]+$",
+			expectedTag: Tag{
+				Column:     "AB",
+				IsUID:      false,
+				IsReadOnly: false,
+				BoolRepresentation: BoolRepresentation{
+					True:    "1",
+					False:   "0",
+					Unknown: false,
+				},
+				Constraints: Constraints{
+					Match: regexp.MustCompile("^[a-z]+$"),
+				},
+			},
+			expectHasColumn: true,
+		},
+		{
+			name:         "with_empty_cell_options",
+			tagValString: "AB,default=42,emptyzero,omitzero",
+			expectedTag: Tag{
+				Column:     "AB",
+				IsUID:      false,
+				IsReadOnly: false,
+				BoolRepresentation: BoolRepresentation{
+					True:    "1",
+					False:   "0",
+					Unknown: false,
+				},
+				Default:     &testDefault,
+				EmptyIsZero: true,
+				OmitZero:    true,
+			},
+			expectHasColumn: true,
+		},
+		{
+			name:         "with_version",
+			tagValString: "F,version",
+			expectedTag: Tag{
+				Column:     "F",
+				IsUID:      false,
+				IsReadOnly: false,
+				BoolRepresentation: BoolRepresentation{
+					True:    "1",
+					False:   "0",
+					Unknown: false,
+				},
+				IsVersion: true,
+			},
+			expectHasColumn: true,
+		},
+		{
+			name:         "with_codec",
+			tagValString: "AB,codec=decimal",
+			expectedTag: Tag{
+				Column:     "AB",
+				IsUID:      false,
+				IsReadOnly: false,
+				BoolRepresentation: BoolRepresentation{
+					True:    "1",
+					False:   "0",
+					Unknown: false,
+				},
+				Codec: "decimal",
+			},
+			expectHasColumn: true,
+		},
+		{
+			name:         "error_invalid_min",
+			tagValString: "AB,min=alma",
+			expectErr:    true,
+		},
+		{
+			name:         "error_invalid_match",
+			tagValString: "AB,match=[",
+			expectErr:    true,
+		},
+		{
+			name:         "error_empty",
 			tagValString: "",
-			expectPanic:  true,
+			expectErr:    true,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 
-			if tc.expectPanic {
-				assert.Panics(t, func() {
-					ParseTagValString(tc.tagValString)
-				})
+			result, err := ParseTagValString(tc.tagValString)
+			if tc.expectErr {
+				assert.ErrorIs(t, err, errors.ErrInvalidTag)
 			} else {
-				assert.NotPanics(t, func() {
-					result := ParseTagValString(tc.tagValString)
-					assert.Equal(t, tc.expectedTag, result)
-					assert.Equal(t, tc.expectHasColumn, result.HasColumn())
-				})
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedTag, result)
+				assert.Equal(t, tc.expectHasColumn, result.HasColumn())
 			}
 		})
 	}
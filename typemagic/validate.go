@@ -0,0 +1,118 @@
+package typemagic
+
+import (
+	"github.com/pproj/sheetsorm/errors"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Constraints are the validation rules defined in the struct tag of a field
+// Min and Max are checked against the value itself for numeric fields, and against the length of the cell for everything else.
+// Match and OneOf are always checked against the cell representation of the value.
+type Constraints struct {
+	Required bool
+	Min      *float64
+	Max      *float64
+	Match    *regexp.Regexp
+	OneOf    []string
+}
+
+func (c Constraints) IsEmpty() bool {
+	return !c.Required && c.Min == nil && c.Max == nil && c.Match == nil && c.OneOf == nil
+}
+
+// numericValue returns the value as float64 if it is a numeric kind, the second return value is false otherwise
+func numericValue(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func formatLimit(limit float64) string {
+	return strconv.FormatFloat(limit, 'f', -1, 64)
+}
+
+// check returns the rules violated by the value. valueValid should be false for nil values, in that case the cell is considered empty.
+// Empty cells only violate the required rule, so optional fields could be left empty.
+func (c Constraints) check(valueValid bool, value reflect.Value, cell string) []string {
+	if !valueValid || cell == "" {
+		if c.Required {
+			return []string{SheetTagOptionRequired}
+		}
+		return nil
+	}
+
+	var violated []string
+
+	measure, isNumeric := numericValue(value)
+	if !isNumeric {
+		measure = float64(utf8.RuneCountInString(cell))
+	}
+	if c.Min != nil && measure < *c.Min {
+		violated = append(violated, SheetTagOptionMin+formatLimit(*c.Min))
+	}
+	if c.Max != nil && measure > *c.Max {
+		violated = append(violated, SheetTagOptionMax+formatLimit(*c.Max))
+	}
+	if c.Match != nil && !c.Match.MatchString(cell) {
+		violated = append(violated, SheetTagOptionMatch+c.Match.String())
+	}
+	if c.OneOf != nil && !slices.Contains(c.OneOf, cell) {
+		violated = append(violated, SheetTagOptionOneOf+strings.Join(c.OneOf, oneOfSeparator))
+	}
+
+	return violated
+}
+
+// collectViolations checks a single field and appends the violations found to the list
+func collectViolations(violations []errors.Violation, field string, valueValid bool, value reflect.Value, t Tag, cell string) []errors.Violation {
+	if t.Constraints.IsEmpty() {
+		return violations
+	}
+	for _, rule := range t.Constraints.check(valueValid, value, cell) {
+		violations = append(violations, errors.Violation{
+			Field:  field,
+			Column: t.Column,
+			Value:  cell,
+			Rule:   rule,
+		})
+	}
+	return violations
+}
+
+// ValidateStruct checks every field of the struct against the constraints defined in its struct tag (including read-only and nil fields)
+// It returns a *errors.ValidationError listing every violation, or nil if the struct is valid. Other errors are returned if the struct could not be dumped.
+func ValidateStruct(item interface{}) error {
+	val, schema, err := structAndSchema(item)
+	if err != nil {
+		return err
+	}
+
+	var violations []errors.Violation
+	for _, f := range schema.fields {
+		value, valid := f.valueForDump(val)
+		var cell string
+		if valid {
+			cell, err = dumpValue(value, f.Tag)
+			if err != nil {
+				return &errors.FieldError{Field: f.Name, Column: f.Tag.Column, Err: err}
+			}
+		}
+		violations = collectViolations(violations, f.Name, valid, value, f.Tag, cell)
+	}
+	if len(violations) > 0 {
+		return &errors.ValidationError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package typemagic
+
+import (
+	"github.com/pproj/sheetsorm/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateStruct(t *testing.T) {
+	type testStructRequired struct {
+		Name    string  `sheet:"A,required"`
+		NamePtr *string `sheet:"B,required"`
+	}
+	type testStructLimits struct {
+		Age   int     `sheet:"A,min=18,max=99"`
+		Score float64 `sheet:"B,min=0.5"`
+		Name  string  `sheet:"C,min=2,max=4"`
+	}
+	type testStructMatch struct {
+		Code  string `sheet:"A,match=^[A-Z]{3}$"`
+		Level string `sheet:"B,oneof=low|mid|high"`
+	}
+	type testStructNested struct {
+		Name string `sheet:"A,required"`
+		Data struct {
+			Age int `sheet:"B,max=10"`
+		}
+	}
+
+	testValString := "alma"
+
+	testCases := []struct {
+		name               string
+		item               interface{}
+		expectedViolations []errors.Violation
+	}{
+		{
+			name: "happy__required",
+			item: testStructRequired{
+				Name:    "alma",
+				NamePtr: &testValString,
+			},
+		},
+		{
+			name: "error__required",
+			item: testStructRequired{},
+			expectedViolations: []errors.Violation{
+				{Field: "Name", Column: "A", Value: "", Rule: "required"},
+				{Field: "NamePtr", Column: "B", Value: "", Rule: "required"},
+			},
+		},
+		{
+			name: "happy__limits",
+			item: testStructLimits{
+				Age:   18,
+				Score: 0.5,
+				Name:  "alma",
+			},
+		},
+		{
+			name: "error__limits",
+			item: testStructLimits{
+				Age:   100,
+				Score: 0.4,
+				Name:  "a",
+			},
+			expectedViolations: []errors.Violation{
+				{Field: "Age", Column: "A", Value: "100", Rule: "max=99"},
+				{Field: "Score", Column: "B", Value: "0.4", Rule: "min=0.5"},
+				{Field: "Name", Column: "C", Value: "a", Rule: "min=2"},
+			},
+		},
+		{
+			name: "happy__empty_is_not_checked",
+			item: testStructMatch{},
+		},
+		{
+			name: "happy__match",
+			item: testStructMatch{
+				Code:  "ABC",
+				Level: "mid",
+			},
+		},
+		{
+			name: "error__match",
+			item: testStructMatch{
+				Code:  "abc",
+				Level: "extreme",
+			},
+			expectedViolations: []errors.Violation{
+				{Field: "Code", Column: "A", Value: "abc", Rule: "match=^[A-Z]{3}$"},
+				{Field: "Level", Column: "B", Value: "extreme", Rule: "oneof=low|mid|high"},
+			},
+		},
+		{
+			name: "error__nested",
+			item: testStructNested{
+				Data: struct {
+					Age int `sheet:"B,max=10"`
+				}{Age: 11},
+			},
+			expectedViolations: []errors.Violation{
+				{Field: "Name", Column: "A", Value: "", Rule: "required"},
+				{Field: "Data.Age", Column: "B", Value: "11", Rule: "max=10"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateStruct(tc.item)
+			if tc.expectedViolations == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, errors.ErrValidationFailed)
+			var validationErr *errors.ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, tc.expectedViolations, validationErr.Violations)
+			}
+		})
+	}
+}
+
+func TestDumpStructValidation(t *testing.T) {
+	type testStruct struct {
+		Name     string  `sheet:"A,required"`
+		Age      *int    `sheet:"B,required,min=18"`
+		Comment  string  `sheet:"C,readonly,required"`
+		Optional *string `sheet:"D,min=3"`
+	}
+
+	t.Run("nil_and_omitted_fields_are_not_checked", func(t *testing.T) {
+		age := 20
+		result, err := DumpStruct(testStruct{Name: "alma", Age: &age}, true)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"A": "alma", "B": "20"}, result)
+	})
+
+	t.Run("nil_required_field", func(t *testing.T) {
+		result, err := DumpStruct(testStruct{Name: "alma"}, true)
+		var validationErr *errors.ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, []errors.Violation{
+				{Field: "Age", Column: "B", Value: "", Rule: "required"},
+			}, validationErr.Violations)
+		}
+		assert.Nil(t, result)
+	})
+
+	t.Run("read_only_checked_when_dumped", func(t *testing.T) {
+		result, err := DumpStruct(testStruct{Name: "alma"}, false)
+		assert.ErrorIs(t, err, errors.ErrValidationFailed)
+		assert.Nil(t, result)
+	})
+
+	t.Run("every_violation_is_reported", func(t *testing.T) {
+		age := 12
+		_, err := DumpStruct(testStruct{Age: &age}, true)
+		var validationErr *errors.ValidationError
+		if assert.ErrorAs(t, err, &validationErr) {
+			assert.Equal(t, []errors.Violation{
+				{Field: "Name", Column: "A", Value: "", Rule: "required"},
+				{Field: "Age", Column: "B", Value: "12", Rule: "min=18"},
+			}, validationErr.Violations)
+		}
+	})
+}